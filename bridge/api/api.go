@@ -2,7 +2,13 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,8 +16,8 @@ import (
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
 	ring "github.com/zfjagann/golang-ring"
 )
 
@@ -20,6 +26,54 @@ type API struct {
 	sync.RWMutex
 	*bridge.Config
 	mrouter *melody.Melody
+	hub     *hub
+	seq     uint64                    // sequence number of the last buffered message
+	lastID  int64                     // last message ID we handed out
+	cursors map[string]*readCursor    // what the /api/messages clients have read, by cursorKey
+	joined  map[string]bool           // names of the channels configured for the API
+	posted  map[string]config.Message // messages posted by clients, by ID, to edit or delete them
+	order   []string                  // IDs of the posted messages, oldest first
+	hooks   []*webhook
+	tokens  []*apiToken
+	clients map[*melody.Session]*subscriber // subscriptions of the websocket clients
+
+	tokenLimiter *limiter // rate limit of the messages per token
+	ipLimiter    *limiter // rate limit of the messages per client address
+}
+
+const (
+	// eventsKeepalive is the interval of the comments sent to keep idle /api/events
+	// connections open.
+	eventsKeepalive = 30 * time.Second
+	// defaultChannel is the channel of messages that don't specify one.
+	defaultChannel = "api"
+	// maxPosted is the amount of posted messages that can be edited or deleted.
+	maxPosted = 1000
+	// maxCursors is the amount of /api/messages clients we remember what they've read for.
+	maxCursors = 1000
+	// cursorTTL is how long we remember what an /api/messages client has read.
+	cursorTTL = 24 * time.Hour
+)
+
+// readCursor is the sequence number of the last message an /api/messages client has read.
+type readCursor struct {
+	seq  uint64
+	used time.Time
+}
+
+// accountConfig returns the nested values of the config of account, like its Tokens.
+func accountConfig(cfg config.Config, account string) config.Protocol {
+	values := cfg.BridgeValues()
+	if name := strings.TrimPrefix(account, grpcProtocol+"."); name != account {
+		return values.GRPC[strings.ToLower(name)]
+	}
+	return values.API[strings.ToLower(strings.TrimPrefix(account, "api."))]
+}
+
+// bufferedMessage is a message in the Messages buffer.
+type bufferedMessage struct {
+	seq uint64
+	msg config.Message
 }
 
 type Message struct {
@@ -31,7 +85,7 @@ type Message struct {
 }
 
 func New(cfg *bridge.Config) bridge.Bridger {
-	b := &API{Config: cfg}
+	b := newAPI(cfg)
 	e := echo.New()
 	e.HideBanner = true
 	e.HidePort = true
@@ -58,96 +112,508 @@ func New(cfg *bridge.Config) bridge.Bridger {
 			b.Log.Errorf("failed to write message '%s'", string(data))
 			return
 		}
-		// TODO: send message history buffer from `b.Messages` here
+		f, _ := session.Get("filter")
+		backlog, sub := b.subscribe(session.Request, f.(filter))
+		b.Lock()
+		b.clients[session] = sub
+		b.Unlock()
+		for _, m := range backlog {
+			data, err := json.Marshal(m.msg)
+			if err != nil {
+				b.Log.Errorf("failed to encode message '%v'", m.msg)
+				continue
+			}
+			if err := session.Write(data); err != nil {
+				b.Log.Errorf("failed to write message '%s'", string(data))
+				return
+			}
+		}
+		go b.handleWebsocketSubscriber(session, sub)
+	})
+	b.mrouter.HandleDisconnect(func(session *melody.Session) {
+		b.Lock()
+		sub, ok := b.clients[session]
+		delete(b.clients, session)
+		b.Unlock()
+		if ok {
+			b.hub.unsubscribe(sub)
+		}
 	})
+	b.mrouter.HandleError(func(session *melody.Session, err error) {
+		// melody drops messages when a client doesn't keep up, disconnect it instead
+		if err.Error() == "session message buffer is full" {
+			b.Log.Warnf("websocket client %s is too slow, disconnecting", session.Request.RemoteAddr)
+			_ = session.Close()
+		}
+	})
+
+	b.addRoutes(e)
+	go func() {
+		if b.GetString("BindAddress") == "" {
+			b.Log.Fatalf("No BindAddress configured.")
+		}
+		b.Log.Infof("Listening on %s", b.GetString("BindAddress"))
+		b.Log.Fatal(e.Start(b.GetString("BindAddress")))
+	}()
+	return b
+}
+
+// newAPI returns the API without a server, it's shared by the HTTP and the gRPC API.
+func newAPI(cfg *bridge.Config) *API {
+	b := &API{
+		Config:  cfg,
+		cursors: make(map[string]*readCursor),
+		joined:  make(map[string]bool),
+		posted:  make(map[string]config.Message),
+		clients: make(map[*melody.Session]*subscriber),
+	}
+	b.hub = newHub(b.GetInt("SubscriberBuffer"))
 
 	b.Messages = ring.Ring{}
 	if b.GetInt("Buffer") != 0 {
 		b.Messages.SetCapacity(b.GetInt("Buffer"))
 	}
-	if b.GetString("Token") != "" {
-		e.Use(middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
-			return key == b.GetString("Token"), nil
-		}))
-	}
+	b.tokens = b.newTokens()
+	b.tokenLimiter = newLimiter(b.GetInt("RateLimit"), b.GetInt("RateLimitBurst"))
+	b.ipLimiter = newLimiter(b.GetInt("RateLimitIP"), b.GetInt("RateLimitBurst"))
 
 	// Set RemoteNickFormat to a sane default
 	if !b.IsKeySet("RemoteNickFormat") {
 		b.Log.Debugln("RemoteNickFormat is unset, defaulting to \"{NICK}\"")
 		b.Config.Config.Viper().Set(b.GetConfigKey("RemoteNickFormat"), "{NICK}")
 	}
-
-	e.GET("/api/health", b.handleHealthcheck)
-	e.GET("/api/messages", b.handleMessages)
-	e.GET("/api/stream", b.handleStream)
-	e.GET("/api/websocket", b.handleWebsocket)
-	e.POST("/api/message", b.handlePostMessage)
-	go func() {
-		if b.GetString("BindAddress") == "" {
-			b.Log.Fatalf("No BindAddress configured.")
-		}
-		b.Log.Infof("Listening on %s", b.GetString("BindAddress"))
-		b.Log.Fatal(e.Start(b.GetString("BindAddress")))
-	}()
 	return b
 }
 
+// addRoutes adds the routes of the API to e, they're documented in routeDocs.
+func (b *API) addRoutes(e *echo.Echo) {
+	e.HTTPErrorHandler = b.handleError
+	e.GET("/api/health", b.handleHealthcheck, b.require(accessAny))
+	e.GET("/api/messages", b.handleMessages, b.require(accessRead))
+	e.GET("/api/stream", b.handleStream, b.require(accessRead))
+	e.GET("/api/events", b.handleEvents, b.require(accessRead))
+	// websocket clients need write access to send messages as well
+	e.GET("/api/websocket", b.handleWebsocket, b.require(accessRead))
+	e.POST("/api/message", b.handlePostMessage, b.require(accessWrite), b.rateLimit)
+	e.PUT("/api/message/:id", b.handlePutMessage, b.require(accessWrite), b.rateLimit)
+	e.DELETE("/api/message/:id", b.handleDeleteMessage, b.require(accessWrite), b.rateLimit)
+	e.GET("/api/openapi.json", b.handleOpenAPI, b.require(accessAny))
+}
+
 func (b *API) Connect() error {
+	b.Lock()
+	defer b.Unlock()
+	if b.hooks == nil {
+		b.hooks = newWebhooks(b.Config.Config, b.Account, b.Log)
+	}
 	return nil
 }
 
 func (b *API) Disconnect() error {
+	b.Lock()
+	defer b.Unlock()
+	for _, hook := range b.hooks {
+		hook.close()
+	}
+	b.hooks = nil
 	return nil
 }
 
 func (b *API) JoinChannel(channel config.ChannelInfo) error {
+	b.Lock()
+	defer b.Unlock()
+	b.joined[channel.Name] = true
 	return nil
 }
 
+// Capabilities returns what the API relays to its clients, they get every message
+// except typing and avatar events.
+func (b *API) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{
+		Edits:      true,
+		Deletes:    true,
+		Threads:    true,
+		Reactions:  true,
+		Files:      true,
+		Formatting: true,
+	}
+}
+
 func (b *API) Send(msg config.Message) (string, error) {
 	b.Lock()
 	defer b.Unlock()
+	if msg.ID == "" && msg.Event != config.EventMsgDelete {
+		msg.ID = b.newID()
+	}
+	for _, hook := range b.hooks {
+		hook.push(msg)
+	}
 	// ignore delete messages
 	if msg.Event == config.EventMsgDelete {
 		return "", nil
 	}
 	b.Log.Debugf("enqueueing message from %s on ring buffer", msg.Username)
-	b.Messages.Enqueue(msg)
+	b.seq++
+	b.Messages.Enqueue(bufferedMessage{seq: b.seq, msg: msg})
 
 	data, err := json.Marshal(msg)
 	if err != nil {
-		b.Log.Errorf("failed to encode message  '%s'", msg)
+		b.Log.Errorf("failed to encode message %#v", msg)
+		return msg.ID, nil
+	}
+	b.hub.publish(hubMessage{seq: b.seq, msg: msg, data: data})
+	return msg.ID, nil
+}
+
+// subscribe returns the backlog the client of r asked for and subscribes it to the
+// messages sent after that, both limited to the messages matching f.
+func (b *API) subscribe(r *http.Request, f filter) ([]bufferedMessage, *subscriber) {
+	b.Lock()
+	defer b.Unlock()
+	backlog, _ := b.backlog(b.replayCursor(r), f)
+	return backlog, b.hub.subscribe(f)
+}
+
+// readFilter returns the filter of the gateway and channel parameters of the request,
+// limited to the gateways its token can read.
+func (b *API) readFilter(c echo.Context) (filter, error) {
+	return b.tokenFilter(c.Request(), contextToken(c), c.QueryParams())
+}
+
+// tokenFilter returns the filter of the gateway and channel values, limited to the
+// gateways token t can read.
+func (b *API) tokenFilter(r *http.Request, t *apiToken, values url.Values) (filter, error) {
+	f, ok := t.restrict(newFilter(values))
+	if !ok {
+		return f, b.reject(r, t, rejectGateway, http.StatusForbidden)
 	}
-	_ = b.mrouter.Broadcast(data)
-	return "", nil
+	return f, nil
 }
 
+// newID returns a new message ID, IDs are unique across restarts.
+func (b *API) newID() string {
+	id := time.Now().UnixNano()
+	if id <= b.lastID {
+		id = b.lastID + 1
+	}
+	b.lastID = id
+	return strconv.FormatInt(id, 10)
+}
+
+// backlog returns the buffered messages after sequence number after that match f
+// and the sequence number of the last message. The caller must hold the lock.
+func (b *API) backlog(after uint64, f filter) ([]bufferedMessage, uint64) {
+	var msgs []bufferedMessage
+	for _, v := range b.Messages.Values() {
+		if m := v.(bufferedMessage); m.seq > after && f.match(&m.msg) {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, b.seq
+}
+
+// cursor returns the sequence number since refers to. since is the ID or the
+// RFC3339 timestamp of the last message the client has seen. Unknown IDs
+// return the whole buffer. The caller must hold the lock.
+func (b *API) cursor(since string) uint64 {
+	values := b.Messages.Values()
+	if t, err := time.Parse(time.RFC3339Nano, since); err == nil {
+		for _, v := range values {
+			if m := v.(bufferedMessage); m.msg.Timestamp.After(t) {
+				return m.seq - 1
+			}
+		}
+		return b.seq
+	}
+	// edits have the ID of the original message, use the last one
+	for i := len(values) - 1; i >= 0; i-- {
+		if m := values[i].(bufferedMessage); m.msg.ID == since {
+			return m.seq
+		}
+	}
+	return 0
+}
+
+// replayCursor returns from where a websocket, stream or events client wants its backlog
+// replayed: after the event in the Last-Event-ID header, after the message in the since
+// parameter, the whole buffer when backlog is true and otherwise no backlog at all.
+// The caller must hold the lock.
+func (b *API) replayCursor(r *http.Request) uint64 {
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		seq, err := strconv.ParseUint(last, 10, 64)
+		// after a restart the sequence numbers start over
+		if err != nil || seq > b.seq {
+			return 0
+		}
+		return seq
+	}
+	if since := r.URL.Query().Get("since"); since != "" {
+		return b.cursor(since)
+	}
+	if backlog, _ := strconv.ParseBool(r.URL.Query().Get("backlog")); backlog {
+		return 0
+	}
+	return b.seq
+}
+
+type health struct {
+	Status  string          `json:"status"`
+	Bridges []bridge.Status `json:"bridges,omitempty"`
+}
+
+// handleHealthcheck returns the state of all the bridges. The status is "degraded" when
+// one of them isn't connected. The bridges are only listed for tokens that can read
+// all gateways, their errors can tell about the accounts of the other gateways.
 func (b *API) handleHealthcheck(c echo.Context) error {
-	return c.String(http.StatusOK, "OK")
+	res := health{Status: "ok", Bridges: []bridge.Status{}}
+	if b.Statuses != nil {
+		res.Bridges = b.Statuses()
+	}
+	for _, status := range res.Bridges {
+		if status.State != bridge.StateConnected {
+			res.Status = "degraded"
+		}
+	}
+	if !contextToken(c).readsAll() {
+		res.Bridges = nil
+	}
+	return c.JSON(http.StatusOK, res)
 }
 
-func (b *API) handlePostMessage(c echo.Context) error {
-	message := config.Message{}
-	if err := c.Bind(&message); err != nil {
+// inputMessage sets the fixed fields of a new message received from a client and
+// gives it an ID. Messages without a channel go to the default channel, other
+// channels have to be configured, as does the gateway.
+func (b *API) inputMessage(message *config.Message) error {
+	if err := b.checkLength(message); err != nil {
 		return err
 	}
-	// these values are fixed
-	message.Channel = "api"
-	message.Protocol = "api"
+	b.Lock()
+	defer b.Unlock()
+	if message.Channel == "" {
+		message.Channel = defaultChannel
+		// keep configs with a single, differently named, api channel working
+		if len(b.joined) == 1 {
+			for name := range b.joined {
+				message.Channel = name
+			}
+		}
+	}
+	if !b.joined[message.Channel] {
+		return newError(http.StatusBadRequest, "unknown_channel", "unknown channel %s", message.Channel)
+	}
+	if !b.sendsTo(message.Gateway, message.Channel) {
+		return newError(http.StatusBadRequest, "unknown_gateway", "channel %s doesn't send to gateway %q", message.Channel, message.Gateway)
+	}
+	message.Protocol = b.Protocol
 	message.Account = b.Account
-	message.ID = ""
+	message.ID = b.newID()
 	message.Timestamp = time.Now()
-	b.Log.Debugf("Sending message from %s on %s to gateway", message.Username, "api")
+	b.addPosted(*message)
+	return nil
+}
+
+// addPosted remembers msg so it can be edited or deleted later. The caller must hold the lock.
+func (b *API) addPosted(msg config.Message) {
+	msg.Extra = nil
+	b.posted[msg.ID] = msg
+	b.order = append(b.order, msg.ID)
+	if len(b.order) > maxPosted {
+		delete(b.posted, b.order[0])
+		b.order = b.order[1:]
+	}
+}
+
+// changeMessage turns message into a change by token t of the posted message with the
+// given ID. It returns an error if there's no such message or t can't change it.
+func (b *API) changeMessage(r *http.Request, t *apiToken, message *config.Message, id string) error {
+	if err := b.checkLength(message); err != nil {
+		return err
+	}
+	b.Lock()
+	defer b.Unlock()
+	orig, ok := b.posted[id]
+	if !ok {
+		return newError(http.StatusNotFound, "unknown_message", "unknown message %s", id)
+	}
+	if !t.canChange(&orig) {
+		return b.reject(r, t, rejectGateway, http.StatusForbidden)
+	}
+	if message.Username == "" {
+		message.Username = orig.Username
+		message.UserID = orig.UserID
+		message.Avatar = orig.Avatar
+	}
+	message.Gateway = orig.Gateway
+	if reason := t.authorize(message); reason != "" {
+		return b.reject(r, t, reason, http.StatusForbidden)
+	}
+	message.Gateway = orig.Gateway
+	message.Channel = orig.Channel
+	message.Protocol = b.Protocol
+	message.Account = b.Account
+	message.ID = id
+	message.Timestamp = time.Now()
+	if message.Event == config.EventMsgDelete {
+		delete(b.posted, id)
+	}
+	return nil
+}
+
+// bindMessage reads the message of a JSON or a multipart/form-data request. The
+// files of a multipart request are added to the message.
+func (b *API) bindMessage(c echo.Context, message *config.Message) error {
+	form, err := c.MultipartForm()
+	if err != nil {
+		if err == http.ErrNotMultipart {
+			return c.Bind(message)
+		}
+		return newError(http.StatusBadRequest, "invalid_request", "%s", err)
+	}
+	value := func(key string) string {
+		if values := form.Value[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	message.Text = value("text")
+	message.Username = value("username")
+	message.UserID = value("userid")
+	message.Avatar = value("avatar")
+	message.Gateway = value("gateway")
+	message.Channel = value("channel")
+	message.ParentID = value("parent_id")
+	message.Extra = make(map[string][]interface{})
+	for _, fh := range form.File["file"] {
+		if err := b.checkFileSize(message, fh.Filename, fh.Size); err != nil {
+			return err
+		}
+		f, err := fh.Open()
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		helper.HandleDownloadData(b.Log, message, fh.Filename, value("comment"), "", &data, b.General)
+	}
+	return nil
+}
+
+// checkFileSize returns an error if a file can't be added to message.
+func (b *API) checkFileSize(message *config.Message, name string, size int64) error {
+	if err := helper.HandleDownloadSize(b.Log, message, name, size, b.General); err != nil {
+		if message.Event == config.EventFileFailureSize {
+			return newError(http.StatusRequestEntityTooLarge, "file_too_large", "%s", err)
+		}
+		return newError(http.StatusBadRequest, "invalid_file", "%s", err)
+	}
+	return nil
+}
+
+// handlePostMessage sends a new message to the gateway and returns it with its ID,
+// the ID can be used to edit or delete the message later.
+func (b *API) handlePostMessage(c echo.Context) error {
+	message := config.Message{}
+	if err := b.bindMessage(c, &message); err != nil {
+		return err
+	}
+	if reason := contextToken(c).authorize(&message); reason != "" {
+		return b.reject(c.Request(), contextToken(c), reason, http.StatusForbidden)
+	}
+	if err := b.inputMessage(&message); err != nil {
+		return err
+	}
+	b.Log.Debugf("Sending message from %s on %s to gateway", message.Username, message.Channel)
+	b.Remote <- message
+	return c.JSON(http.StatusOK, message)
+}
+
+// handlePutMessage sends an edit of a posted message to the gateway.
+func (b *API) handlePutMessage(c echo.Context) error {
+	message := config.Message{}
+	if err := b.bindMessage(c, &message); err != nil {
+		return err
+	}
+	message.Event = ""
+	if err := b.changeMessage(c.Request(), contextToken(c), &message, c.Param("id")); err != nil {
+		return err
+	}
+	b.Log.Debugf("Sending edit from %s on %s to gateway", message.Username, message.Channel)
 	b.Remote <- message
 	return c.JSON(http.StatusOK, message)
 }
 
+// handleDeleteMessage sends the deletion of a posted message to the gateway.
+func (b *API) handleDeleteMessage(c echo.Context) error {
+	message := config.Message{Event: config.EventMsgDelete, Text: config.EventMsgDelete}
+	if err := b.changeMessage(c.Request(), contextToken(c), &message, c.Param("id")); err != nil {
+		return err
+	}
+	b.Log.Debugf("Sending delete from %s on %s to gateway", message.Username, message.Channel)
+	b.Remote <- message
+	return c.JSON(http.StatusOK, message)
+}
+
+// handleMessages returns the buffered messages the client hasn't seen yet, without
+// removing them from the buffer. Clients pass the ID or timestamp of the last message
+// they've seen in since, or otherwise we keep track of what they've read by their
+// token and client parameter.
 func (b *API) handleMessages(c echo.Context) error {
+	f, err := b.readFilter(c)
+	if err != nil {
+		return err
+	}
 	b.Lock()
 	defer b.Unlock()
-	c.JSONPretty(http.StatusOK, b.Messages.Values(), " ")
-	b.Messages = ring.Ring{}
-	return nil
+	key := cursorKey(c)
+	var after uint64
+	if cursor, ok := b.cursors[key]; ok {
+		after = cursor.seq
+	}
+	if since := c.QueryParam("since"); since != "" {
+		after = b.cursor(since)
+	}
+	backlog, last := b.backlog(after, f)
+	b.saveCursor(key, last)
+	msgs := []config.Message{}
+	for _, m := range backlog {
+		msgs = append(msgs, m.msg)
+	}
+	return c.JSONPretty(http.StatusOK, msgs, " ")
+}
+
+// cursorKey returns the key of the cursor of the /api/messages client of c. Clients
+// are told apart by their token, or by their address when the API has no tokens,
+// and by their client parameter, so they don't read each other's messages.
+func cursorKey(c echo.Context) string {
+	owner := "ip:" + c.RealIP()
+	if t := contextToken(c); t != nil {
+		owner = "token:" + t.Token
+	}
+	return owner + "/" + c.QueryParam("client")
+}
+
+// saveCursor remembers that the client of key has read up to seq. The cursors that
+// haven't been used for cursorTTL are dropped, and the least recently used one when
+// there are more than maxCursors. b must be locked.
+func (b *API) saveCursor(key string, seq uint64) {
+	now := time.Now()
+	b.cursors[key] = &readCursor{seq: seq, used: now}
+	oldest := ""
+	for k, cursor := range b.cursors {
+		if now.Sub(cursor.used) > cursorTTL {
+			delete(b.cursors, k)
+			continue
+		}
+		if k != key && (oldest == "" || cursor.used.Before(b.cursors[oldest].used)) {
+			oldest = k
+		}
+	}
+	if len(b.cursors) > maxCursors {
+		delete(b.cursors, oldest)
+	}
 }
 
 func (b *API) getGreeting() config.Message {
@@ -158,6 +624,10 @@ func (b *API) getGreeting() config.Message {
 }
 
 func (b *API) handleStream(c echo.Context) error {
+	f, err := b.readFilter(c)
+	if err != nil {
+		return err
+	}
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	c.Response().WriteHeader(http.StatusOK)
 	greet := b.getGreeting()
@@ -165,39 +635,157 @@ func (b *API) handleStream(c echo.Context) error {
 		return err
 	}
 	c.Response().Flush()
+	backlog, sub := b.subscribe(c.Request(), f)
+	defer b.hub.unsubscribe(sub)
+	for _, m := range backlog {
+		if err := json.NewEncoder(c.Response()).Encode(m.msg); err != nil {
+			return err
+		}
+	}
+	c.Response().Flush()
 	for {
-		// TODO: this causes issues, messages should be broadcasted to all connected clients
-		msg := b.Messages.Dequeue()
-		if msg != nil {
-			if err := json.NewEncoder(c.Response()).Encode(msg); err != nil {
+		select {
+		case m, ok := <-sub.messages:
+			if !ok {
+				b.Log.Warnf("stream client %s is too slow, disconnecting", c.Request().RemoteAddr)
+				return nil
+			}
+			if _, err := c.Response().Write(m.data); err != nil {
+				return err
+			}
+			if _, err := c.Response().Write([]byte("\n")); err != nil {
 				return err
 			}
 			c.Response().Flush()
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+// handleEvents streams the messages as server-sent events. The event IDs are the
+// sequence numbers of the messages, so browsers resume where they left off with
+// the Last-Event-ID header when they reconnect.
+func (b *API) handleEvents(c echo.Context) error {
+	f, err := b.readFilter(c)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderContentType, "text/event-stream")
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	c.Response().WriteHeader(http.StatusOK)
+	greet, err := json.Marshal(b.getGreeting())
+	if err != nil {
+		return err
+	}
+	// the greeting has no ID, it mustn't change the Last-Event-ID
+	if err := writeEvent(c.Response(), 0, greet); err != nil {
+		return err
+	}
+	backlog, sub := b.subscribe(c.Request(), f)
+	defer b.hub.unsubscribe(sub)
+	for _, m := range backlog {
+		data, err := json.Marshal(m.msg)
+		if err != nil {
+			b.Log.Errorf("failed to encode message '%v'", m.msg)
+			continue
+		}
+		if err := writeEvent(c.Response(), m.seq, data); err != nil {
+			return err
+		}
+	}
+	c.Response().Flush()
+	keepalive := time.NewTicker(eventsKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case m, ok := <-sub.messages:
+			if !ok {
+				b.Log.Warnf("events client %s is too slow, disconnecting", c.Request().RemoteAddr)
+				return nil
+			}
+			if err := writeEvent(c.Response(), m.seq, m.data); err != nil {
+				return err
+			}
+		case <-keepalive.C:
+			if _, err := c.Response().Write([]byte(": keepalive\n\n")); err != nil {
+				return err
+			}
+		case <-c.Request().Context().Done():
+			return nil
 		}
-		time.Sleep(200 * time.Millisecond)
+		c.Response().Flush()
+	}
+}
+
+// writeEvent writes a server-sent event with data, and id when it isn't 0.
+func writeEvent(w io.Writer, id uint64, data []byte) error {
+	if id != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
+
+// handleWebsocketSubscriber writes the messages of sub to the websocket session.
+func (b *API) handleWebsocketSubscriber(session *melody.Session, sub *subscriber) {
+	for m := range sub.messages {
+		if err := session.Write(m.data); err != nil {
+			return
+		}
+	}
+	if sub.dropped {
+		b.Log.Warnf("websocket client %s is too slow, disconnecting", session.Request.RemoteAddr)
+		_ = session.Close()
 	}
 }
 
 func (b *API) handleWebsocketMessage(message config.Message, s *melody.Session) {
-	message.Channel = "api"
-	message.Protocol = "api"
-	message.Account = b.Account
-	message.ID = ""
-	message.Timestamp = time.Now()
+	v, _ := s.Get("token")
+	t := v.(*apiToken)
+	if reason := t.authorize(&message); reason != "" {
+		_ = b.reject(s.Request, t, reason, http.StatusForbidden)
+		return
+	}
+	if _, err := b.checkRate(s.Request, t); err != nil {
+		return
+	}
+	if err := b.inputMessage(&message); err != nil {
+		b.Log.Errorf("dropping websocket message from %s: %s", message.Username, err)
+		return
+	}
 
 	data, err := json.Marshal(message)
 	if err != nil {
 		b.Log.Errorf("failed to encode message for loopback '%v'", message)
 		return
 	}
-	_ = b.mrouter.BroadcastOthers(data, s)
+	var others []*melody.Session
+	b.RLock()
+	for q, sub := range b.clients {
+		if q != s && sub.filter.match(&message) {
+			others = append(others, q)
+		}
+	}
+	b.RUnlock()
+	_ = b.mrouter.BroadcastMultiple(data, others)
 
-	b.Log.Debugf("Sending websocket message from %s on %s to gateway", message.Username, "api")
+	b.Log.Debugf("Sending websocket message from %s on %s to gateway", message.Username, message.Channel)
 	b.Remote <- message
 }
 
 func (b *API) handleWebsocket(c echo.Context) error {
-	err := b.mrouter.HandleRequest(c.Response(), c.Request())
+	f, err := b.readFilter(c)
+	if err != nil {
+		return err
+	}
+	// the keys are only set here, sessions can read them without locking
+	err = b.mrouter.HandleRequestWithKeys(c.Response(), c.Request(), map[string]interface{}{
+		"token":  contextToken(c),
+		"filter": f,
+	})
 	if err != nil {
 		b.Log.Errorf("error in websocket handling  '%v'", err)
 		return err
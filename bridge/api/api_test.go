@@ -0,0 +1,356 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/olahol/melody.v1"
+)
+
+// testGateways are the gateways the channels of the test API send to.
+const testGateways = `
+[[gateway]]
+name="gw1"
+enable=true
+[[gateway.inout]]
+account="api.local"
+[[gateway.inout]]
+account="api.local"
+channel="ops"
+[[gateway.in]]
+account="api.local"
+channel="dev"
+
+[[gateway]]
+name="gw2"
+enable=true
+[[gateway.inout]]
+account="api.local"
+`
+
+func newTestAPI() *API {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	return &API{
+		Config: &bridge.Config{
+			Bridge: &bridge.Bridge{
+				Config:   config.NewConfigFromString(logger, []byte("[api.local]\nToken=\"\"\n"+testGateways)),
+				Log:      logrus.NewEntry(logger),
+				Account:  "api.local",
+				Protocol: "api",
+				Name:     "local",
+				General:  &config.Protocol{MediaDownloadSize: 10},
+			},
+			Remote: make(chan config.Message, 10),
+		},
+		mrouter: melody.New(),
+		hub:     newHub(0),
+		cursors: make(map[string]*readCursor),
+		joined:  make(map[string]bool),
+		posted:  make(map[string]config.Message),
+	}
+}
+
+// request serves a request to the API and returns the response.
+func (b *API) request(method, target, contentType string, body io.Reader) *httptest.ResponseRecorder {
+	e := echo.New()
+	b.addRoutes(e)
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set(echo.HeaderContentType, contentType)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func (b *API) getMessages(t *testing.T, query string) []config.Message {
+	return b.getMessagesFrom(t, "192.0.2.1:1234", query)
+}
+
+func (b *API) getMessagesFrom(t *testing.T, addr, query string) []config.Message {
+	req := httptest.NewRequest(http.MethodGet, "/api/messages?"+query, nil)
+	req.RemoteAddr = addr
+	rec := httptest.NewRecorder()
+	require.NoError(t, b.handleMessages(echo.New().NewContext(req, rec)))
+	var msgs []config.Message
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &msgs))
+	return msgs
+}
+
+func TestMessageCursors(t *testing.T) {
+	b := newTestAPI()
+	start := time.Now()
+	for _, text := range []string{"one", "two", "three"} {
+		id, err := b.Send(config.Message{Text: text, Timestamp: time.Now()})
+		require.NoError(t, err)
+		assert.NotEmpty(t, id)
+	}
+
+	// every client has its own cursor
+	assert.Len(t, b.getMessages(t, "client=a"), 3)
+	assert.Len(t, b.getMessages(t, "client=a"), 0)
+	msgs := b.getMessages(t, "client=b")
+	require.Len(t, msgs, 3)
+
+	// since the ID of the last seen message or a timestamp
+	assert.Equal(t, "three", b.getMessages(t, "since="+msgs[1].ID)[0].Text)
+	assert.Len(t, b.getMessages(t, "since="+start.Format(time.RFC3339Nano)), 3)
+
+	// edits keep the ID of the original message
+	id, err := b.Send(config.Message{Text: "one, edited", ID: msgs[0].ID, Timestamp: time.Now()})
+	require.NoError(t, err)
+	assert.Equal(t, msgs[0].ID, id)
+	assert.Equal(t, "one, edited", b.getMessages(t, "client=a")[0].Text)
+	assert.Empty(t, b.getMessages(t, "since="+msgs[0].ID))
+
+	// clients without a client parameter don't share a cursor
+	assert.Len(t, b.getMessagesFrom(t, "192.0.2.2:1234", ""), 4)
+	assert.Len(t, b.getMessagesFrom(t, "192.0.2.3:1234", ""), 4)
+	assert.Len(t, b.getMessagesFrom(t, "192.0.2.2:1234", ""), 0)
+}
+
+func TestCursorEviction(t *testing.T) {
+	b := newTestAPI()
+	b.cursors["expired"] = &readCursor{seq: 1, used: time.Now().Add(-cursorTTL - time.Minute)}
+	b.cursors["oldest"] = &readCursor{seq: 1, used: time.Now().Add(-time.Hour)}
+	for i := 0; i < maxCursors-1; i++ {
+		b.cursors[strconv.Itoa(i)] = &readCursor{seq: 1, used: time.Now()}
+	}
+
+	b.saveCursor("new", 2)
+	assert.Len(t, b.cursors, maxCursors)
+	assert.NotContains(t, b.cursors, "expired")
+	assert.NotContains(t, b.cursors, "oldest")
+	assert.Equal(t, uint64(2), b.cursors["new"].seq)
+}
+
+func TestChannels(t *testing.T) {
+	b := newTestAPI()
+	post := func(body string) int {
+		return b.request(http.MethodPost, "/api/message", echo.MIMEApplicationJSON, strings.NewReader(body)).Code
+	}
+
+	// a single api channel is the default, whatever its name
+	require.NoError(t, b.JoinChannel(config.ChannelInfo{Name: "ops"}))
+	assert.Equal(t, http.StatusOK, post(`{"text":"one","gateway":"gw1"}`))
+	assert.Equal(t, "ops", (<-b.Remote).Channel)
+
+	require.NoError(t, b.JoinChannel(config.ChannelInfo{Name: "dev"}))
+	assert.Equal(t, http.StatusOK, post(`{"text":"two","gateway":"gw1","channel":"dev"}`))
+	assert.Equal(t, "dev", (<-b.Remote).Channel)
+	assert.Equal(t, http.StatusBadRequest, post(`{"text":"three","gateway":"gw1","channel":"unknown"}`))
+	assert.Equal(t, http.StatusBadRequest, post(`{"text":"four","gateway":"gw1"}`))
+
+	// clients can filter on gateway and channel
+	for _, msg := range []config.Message{
+		{Text: "1", Gateway: "gw1", Channel: "ops"},
+		{Text: "2", Gateway: "gw1", Channel: "dev"},
+		{Text: "3", Gateway: "gw2", Channel: "ops"},
+	} {
+		_, err := b.Send(msg)
+		require.NoError(t, err)
+	}
+	assert.Len(t, b.getMessages(t, "since=0"), 3)
+	assert.Len(t, b.getMessages(t, "since=0&channel=ops"), 2)
+	assert.Len(t, b.getMessages(t, "since=0&gateway=gw1&channel=ops,dev"), 2)
+	assert.Len(t, b.getMessages(t, "since=0&gateway=gw2&gateway=gw1&channel=dev"), 1)
+
+	sub := b.hub.subscribe(filter{channels: map[string]bool{"dev": true}})
+	_, err := b.Send(config.Message{Text: "4", Gateway: "gw1", Channel: "ops"})
+	require.NoError(t, err)
+	_, err = b.Send(config.Message{Text: "5", Gateway: "gw1", Channel: "dev"})
+	require.NoError(t, err)
+	assert.Equal(t, "5", (<-sub.messages).msg.Text)
+}
+
+func TestEditDelete(t *testing.T) {
+	b := newTestAPI()
+	require.NoError(t, b.JoinChannel(config.ChannelInfo{Name: "api"}))
+	send := func(method, target, body string) (int, config.Message) {
+		rec := b.request(method, target, echo.MIMEApplicationJSON, strings.NewReader(body))
+		if rec.Code != http.StatusOK {
+			return rec.Code, config.Message{}
+		}
+		var msg config.Message
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &msg))
+		remote := <-b.Remote
+		assert.True(t, msg.Timestamp.Equal(remote.Timestamp))
+		remote.Timestamp = msg.Timestamp
+		assert.Equal(t, msg, remote)
+		return rec.Code, msg
+	}
+
+	_, msg := send(http.MethodPost, "/api/message", `{"text":"one","username":"joe","gateway":"gw1"}`)
+	require.NotEmpty(t, msg.ID)
+	_, reply := send(http.MethodPost, "/api/message", `{"text":"two","gateway":"gw1","parent_id":"`+msg.ID+`"}`)
+	assert.Equal(t, msg.ID, reply.ParentID)
+	assert.NotEqual(t, msg.ID, reply.ID)
+
+	// edits and deletes keep the ID, gateway and user of the original message
+	code, edit := send(http.MethodPut, "/api/message/"+msg.ID, `{"text":"one, edited","gateway":"other"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, config.Message{
+		Text: "one, edited", Username: "joe", Gateway: "gw1", Channel: "api", Protocol: "api",
+		Account: "api.local", ID: msg.ID, Timestamp: edit.Timestamp,
+	}, edit)
+	code, del := send(http.MethodDelete, "/api/message/"+msg.ID, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, config.EventMsgDelete, del.Event)
+	assert.Equal(t, msg.ID, del.ID)
+
+	code, _ = send(http.MethodDelete, "/api/message/"+msg.ID, "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = send(http.MethodPut, "/api/message/unknown", `{"text":"edit"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestUpload(t *testing.T) {
+	b := newTestAPI()
+	require.NoError(t, b.JoinChannel(config.ChannelInfo{Name: "api"}))
+	upload := func(content string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		require.NoError(t, w.WriteField("text", "a file"))
+		require.NoError(t, w.WriteField("gateway", "gw1"))
+		fw, err := w.CreateFormFile("file", "hello.txt")
+		require.NoError(t, err)
+		_, err = fw.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return b.request(http.MethodPost, "/api/message", w.FormDataContentType(), &body)
+	}
+
+	require.Equal(t, http.StatusOK, upload("hello").Code)
+	msg := <-b.Remote
+	assert.Equal(t, "a file", msg.Text)
+	assert.Equal(t, "gw1", msg.Gateway)
+	require.Len(t, msg.Extra["file"], 1)
+	fi := msg.Extra["file"][0].(config.FileInfo)
+	assert.Equal(t, "hello.txt", fi.Name)
+	assert.Equal(t, "hello", string(*fi.Data))
+
+	// MediaDownloadSize applies to uploads
+	assert.Equal(t, http.StatusRequestEntityTooLarge, upload("hello, world").Code)
+}
+
+func TestStreamFanOut(t *testing.T) {
+	b := newTestAPI()
+	b.hub = newHub(2)
+	e := echo.New()
+	e.GET("/api/stream", b.handleStream)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	// both streams get every message
+	var streams []*json.Decoder
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(srv.URL + "/api/stream")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		dec := json.NewDecoder(resp.Body)
+		var greeting config.Message
+		require.NoError(t, dec.Decode(&greeting))
+		assert.Equal(t, config.EventAPIConnected, greeting.Event)
+		streams = append(streams, dec)
+	}
+	assert.Eventually(t, func() bool {
+		b.hub.Lock()
+		defer b.hub.Unlock()
+		return len(b.hub.subs) == 2
+	}, time.Second, time.Millisecond)
+	_, err := b.Send(config.Message{Text: "hello"})
+	require.NoError(t, err)
+	for _, dec := range streams {
+		var msg config.Message
+		require.NoError(t, dec.Decode(&msg))
+		assert.Equal(t, "hello", msg.Text)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	b := newTestAPI()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := echo.New()
+		b.addRoutes(e)
+		e.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	for _, text := range []string{"one", "two"} {
+		_, err := b.Send(config.Message{Text: text})
+		require.NoError(t, err)
+	}
+
+	// resume after the first message
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := bufio.NewReader(resp.Body)
+	next := func() (string, config.Message) {
+		var id string
+		var msg config.Message
+		for {
+			line, err := events.ReadString('\n')
+			require.NoError(t, err)
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				id = strings.TrimSpace(line[4:])
+			case strings.HasPrefix(line, "data: "):
+				require.NoError(t, json.Unmarshal([]byte(line[6:]), &msg))
+			case line == "\n":
+				return id, msg
+			}
+		}
+	}
+	id, msg := next()
+	assert.Equal(t, "", id)
+	assert.Equal(t, config.EventAPIConnected, msg.Event)
+	id, msg = next()
+	assert.Equal(t, "2", id)
+	assert.Equal(t, "two", msg.Text)
+
+	_, err = b.Send(config.Message{Text: "three"})
+	require.NoError(t, err)
+	id, msg = next()
+	assert.Equal(t, "3", id)
+	assert.Equal(t, "three", msg.Text)
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	h := newHub(2)
+	fast, slow := h.subscribe(filter{}), h.subscribe(filter{})
+	for i := 0; i < 3; i++ {
+		dropped := h.publish(hubMessage{msg: config.Message{Text: "msg"}})
+		<-fast.messages
+		assert.Equal(t, i == 2, dropped == 1)
+	}
+	_, ok := <-slow.messages
+	assert.True(t, ok, "queued messages can still be read")
+	<-slow.messages
+	_, ok = <-slow.messages
+	assert.False(t, ok)
+	assert.True(t, slow.dropped)
+	h.unsubscribe(slow)
+	h.unsubscribe(fast)
+	_, ok = <-fast.messages
+	assert.False(t, ok)
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// apiError is the body of an error response, as {"error": apiError}.
+type apiError struct {
+	Status int `json:"status"`
+	// Code is a short, stable identifier of the error, like "unknown_gateway".
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// newError returns the error of a failed request.
+func newError(status int, code, format string, args ...interface{}) *echo.HTTPError {
+	return echo.NewHTTPError(status, apiError{Status: status, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// errorCode returns the code of errors without one, based on their status.
+func errorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// handleError writes err as an apiError. It's the HTTPErrorHandler of the API, errors
+// of echo itself, like unknown routes, are converted as well.
+func (b *API) handleError(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	he, ok := err.(*echo.HTTPError)
+	if !ok {
+		b.Log.Errorf("%s %s failed: %s", c.Request().Method, c.Request().URL.Path, err)
+		he = echo.NewHTTPError(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+	}
+	res, ok := he.Message.(apiError)
+	if !ok {
+		res = apiError{Status: he.Code, Code: errorCode(he.Code), Message: fmt.Sprint(he.Message)}
+	}
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(he.Code)
+	} else {
+		err = c.JSON(he.Code, map[string]apiError{"error": res})
+	}
+	if err != nil {
+		b.Log.Errorf("failed to write error response: %s", err)
+	}
+}
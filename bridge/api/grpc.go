@@ -0,0 +1,326 @@
+package api
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// The gRPC API serves the Matterbridge service of matterbridge.proto. It implements
+// the gRPC wire protocol on top of net/http, HTTP/2 without TLS (h2c) and the
+// protobuf encoding of grpcpb.go.
+
+const grpcProtocol = "grpc"
+
+const (
+	grpcService = "/matterbridge.Matterbridge/"
+	// grpcMaxMessage is the max size of a received message, on top of the
+	// MediaDownloadSize for its files.
+	grpcMaxMessage = 4 << 20
+)
+
+// Status codes of gRPC.
+const (
+	grpcOK                = 0
+	grpcUnknown           = 2
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnauthenticated   = 16
+)
+
+// grpcError is an error with a gRPC status code.
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e grpcError) Error() string {
+	return e.message
+}
+
+// NewGRPC returns the gRPC API, it has the same configuration as the HTTP API.
+func NewGRPC(cfg *bridge.Config) bridge.Bridger {
+	b := newAPI(cfg)
+	go func() {
+		if b.GetString("BindAddress") == "" {
+			b.Log.Fatalf("No BindAddress configured.")
+		}
+		b.Log.Infof("Listening on %s", b.GetString("BindAddress"))
+		srv := &http.Server{
+			Addr:    b.GetString("BindAddress"),
+			Handler: h2c.NewHandler(b.grpcHandler(), &http2.Server{}),
+		}
+		b.Log.Fatal(srv.ListenAndServe())
+	}()
+	return b
+}
+
+// grpcHandler returns the handler of the gRPC requests.
+func (b *API) grpcHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get(echo.HeaderContentType), "application/grpc") {
+			http.Error(w, "only gRPC requests are supported", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set(echo.HeaderContentType, "application/grpc+proto")
+		var err error
+		switch r.URL.Path {
+		case grpcService + "Stream":
+			err = b.grpcStream(w, r)
+		case grpcService + "Send":
+			err = b.grpcSend(w, r)
+		case grpcService + "ListGateways":
+			err = b.grpcListGateways(w, r)
+		default:
+			err = grpcError{grpcUnimplemented, "unknown method " + r.URL.Path}
+		}
+		code, message := b.grpcStatus(err)
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+		if message != "" {
+			w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcEncodeMessage(message))
+		}
+	})
+}
+
+// grpcStatus returns the gRPC status code and message of err.
+func (b *API) grpcStatus(err error) (int, string) {
+	switch e := err.(type) {
+	case nil:
+		return grpcOK, ""
+	case grpcError:
+		return e.code, e.message
+	case *echo.HTTPError:
+		message := fmt.Sprint(e.Message)
+		if res, ok := e.Message.(apiError); ok {
+			message = res.Message
+		}
+		switch e.Code {
+		case http.StatusBadRequest:
+			return grpcInvalidArgument, message
+		case http.StatusUnauthorized:
+			return grpcUnauthenticated, message
+		case http.StatusForbidden:
+			return grpcPermissionDenied, message
+		case http.StatusNotFound:
+			return grpcNotFound, message
+		case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+			return grpcResourceExhausted, message
+		}
+		return grpcUnknown, message
+	}
+	if err == errInvalidProto {
+		return grpcInvalidArgument, err.Error()
+	}
+	b.Log.Errorf("gRPC request failed: %s", err)
+	return grpcInternal, "internal error"
+}
+
+// grpcEncodeMessage percent-encodes s for the grpc-message trailer.
+func grpcEncodeMessage(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// readGRPC reads a length-prefixed message. It returns io.EOF when the client has
+// no more messages.
+func (b *API) readGRPC(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, grpcError{grpcUnimplemented, "compression isn't supported"}
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if max := grpcMaxMessage + b.General.MediaDownloadSize; int64(size) > int64(max) {
+		return nil, grpcError{grpcResourceExhausted, fmt.Sprintf("message of %d bytes is larger than %d bytes", size, max)}
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// writeGRPC writes a length-prefixed message and flushes it.
+func writeGRPC(w http.ResponseWriter, data []byte) error {
+	var header [5]byte
+	binary.BigEndian.PutUint32(header[1:], uint32(len(data)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
+
+// grpcInput sends a message of a client with token t to the gateway. Messages with
+// an ID edit or delete the posted message with that ID.
+func (b *API) grpcInput(r *http.Request, t *apiToken, data []byte) (config.Message, error) {
+	var message config.Message
+	files, err := unmarshalMessage(data, &message)
+	if err != nil {
+		return message, err
+	}
+	if _, err := b.checkRate(r, t); err != nil {
+		return message, err
+	}
+	if id := message.ID; id != "" {
+		if message.Event == config.EventMsgDelete {
+			message = config.Message{Event: config.EventMsgDelete, Text: config.EventMsgDelete}
+		} else {
+			message.Event = ""
+			message.Extra = nil
+		}
+		if err := b.changeMessage(r, t, &message, id); err != nil {
+			return message, err
+		}
+	} else {
+		if reason := t.authorize(&message); reason != "" {
+			return message, b.reject(r, t, reason, http.StatusForbidden)
+		}
+		message.Extra = make(map[string][]interface{})
+		for _, fi := range files {
+			if fi.Data == nil {
+				return message, newError(http.StatusBadRequest, "invalid_file", "file %s has no data", fi.Name)
+			}
+			if err := b.checkFileSize(&message, fi.Name, int64(len(*fi.Data))); err != nil {
+				return message, err
+			}
+			helper.HandleDownloadData(b.Log, &message, fi.Name, fi.Comment, "", fi.Data, b.General)
+		}
+		if err := b.inputMessage(&message); err != nil {
+			return message, err
+		}
+	}
+	b.Log.Debugf("Sending gRPC message from %s on %s to gateway", message.Username, message.Channel)
+	b.Remote <- message
+	return message, nil
+}
+
+// grpcSend handles the Send RPC.
+func (b *API) grpcSend(w http.ResponseWriter, r *http.Request) error {
+	t, err := b.authenticate(r, accessWrite)
+	if err != nil {
+		return err
+	}
+	data, err := b.readGRPC(r.Body)
+	if err != nil {
+		return err
+	}
+	message, err := b.grpcInput(r, t, data)
+	if err != nil {
+		return err
+	}
+	return writeGRPC(w, marshalMessage(&message))
+}
+
+// grpcListGateways handles the ListGateways RPC.
+func (b *API) grpcListGateways(w http.ResponseWriter, r *http.Request) error {
+	t, err := b.authenticate(r, accessRead)
+	if err != nil {
+		return err
+	}
+	if _, err := b.readGRPC(r.Body); err != nil {
+		return err
+	}
+	var gateways []apiGateway
+	for _, gw := range b.gateways() {
+		if t.allowsGateway(gw.name) {
+			gateways = append(gateways, gw)
+		}
+	}
+	return writeGRPC(w, marshalGateways(gateways))
+}
+
+// grpcStream handles the Stream RPC. The metadata of the request are used like the
+// parameters of /api/stream.
+func (b *API) grpcStream(w http.ResponseWriter, r *http.Request) error {
+	t, err := b.authenticate(r, accessRead)
+	if err != nil {
+		return err
+	}
+	values := url.Values{}
+	for _, key := range []string{"gateway", "channel", "since", "backlog"} {
+		if v := r.Header.Values(key); len(v) > 0 {
+			values[key] = v
+		}
+	}
+	f, err := b.tokenFilter(r, t, values)
+	if err != nil {
+		return err
+	}
+	// subscribe reads the replay parameters from the query
+	replay := r.Clone(r.Context())
+	replay.URL.RawQuery = values.Encode()
+
+	greet := b.getGreeting()
+	if err := writeGRPC(w, marshalMessage(&greet)); err != nil {
+		return err
+	}
+	backlog, sub := b.subscribe(replay, f)
+	defer b.hub.unsubscribe(sub)
+	for _, m := range backlog {
+		if err := writeGRPC(w, marshalMessage(&m.msg)); err != nil {
+			return err
+		}
+	}
+
+	received := make(chan error, 1)
+	go func() {
+		for {
+			data, err := b.readGRPC(r.Body)
+			if err == io.EOF {
+				// the client is done sending, it keeps receiving
+				return
+			}
+			if err == nil {
+				_, err = b.grpcInput(r, t, data)
+			}
+			if err != nil {
+				received <- err
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case m, ok := <-sub.messages:
+			if !ok {
+				b.Log.Warnf("gRPC client %s is too slow, disconnecting", r.RemoteAddr)
+				return grpcError{grpcResourceExhausted, "client too slow"}
+			}
+			if err := writeGRPC(w, marshalMessage(&m.msg)); err != nil {
+				return err
+			}
+		case err := <-received:
+			return err
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestGRPC(t *testing.T) {
+	b := newTestAPI()
+	require.NoError(t, b.JoinChannel(config.ChannelInfo{Name: "api"}))
+	srv := httptest.NewServer(h2c.NewHandler(b.grpcHandler(), &http2.Server{}))
+	defer srv.Close()
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	frame := func(data []byte) []byte {
+		header := make([]byte, 5)
+		binary.BigEndian.PutUint32(header[1:], uint32(len(data)))
+		return append(header, data...)
+	}
+	call := func(method string, body io.Reader, metadata map[string]string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+grpcService+method, body)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/grpc")
+		for k, v := range metadata {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	// unary calls the method and returns its response and status
+	unary := func(method string, data []byte) ([]byte, string) {
+		resp := call(method, bytes.NewReader(frame(data)), nil)
+		defer resp.Body.Close()
+		res, err := b.readGRPC(resp.Body)
+		if err == io.EOF {
+			res = nil
+		} else {
+			require.NoError(t, err)
+		}
+		_, _ = ioutil.ReadAll(resp.Body)
+		return res, resp.Trailer.Get("Grpc-Status")
+	}
+
+	res, status := unary("ListGateways", nil)
+	assert.Equal(t, "0", status)
+	var gateways []string
+	require.NoError(t, consumeFields(res, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		gw, n := consumeBytes(typ, b)
+		return n, consumeFields(gw, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+			if num != 1 {
+				return 0, nil
+			}
+			name, n := consumeBytes(typ, b)
+			gateways = append(gateways, string(name))
+			return n, nil
+		})
+	}))
+	assert.Equal(t, []string{"gw1", "gw2"}, gateways)
+
+	// Send works like POST /api/message
+	data := []byte("file")
+	res, status = unary("Send", marshalMessage(&config.Message{
+		Text: "hi", Username: "joe", Gateway: "gw1", Quote: &config.Quote{Username: "bob", Text: "hello", InText: true},
+		Extra: map[string][]interface{}{"file": {config.FileInfo{Name: "a.txt", Data: &data}}},
+	}))
+	assert.Equal(t, "0", status)
+	var sent config.Message
+	files, err := unmarshalMessage(res, &sent)
+	require.NoError(t, err)
+	assert.NotEmpty(t, sent.ID)
+	assert.Equal(t, "api", sent.Channel)
+	require.Len(t, files, 1)
+	assert.Equal(t, "file", string(*files[0].Data))
+	msg := <-b.Remote
+	assert.Equal(t, sent.ID, msg.ID)
+	assert.Equal(t, "a.txt", msg.Extra["file"][0].(config.FileInfo).Name)
+	assert.Equal(t, &config.Quote{Username: "bob", Text: "hello", InText: true}, msg.Quote)
+
+	_, status = unary("Send", marshalMessage(&config.Message{Text: "hi", Gateway: "gw3"}))
+	assert.Equal(t, "3", status)
+	_, status = unary("Unknown", nil)
+	assert.Equal(t, "12", status)
+
+	// Stream receives the messages of its gateways and sends messages
+	body, w := io.Pipe()
+	resp := call("Stream", body, map[string]string{"gateway": "gw1"})
+	defer resp.Body.Close()
+	greet, err := b.readGRPC(resp.Body)
+	require.NoError(t, err)
+	_, err = unmarshalMessage(greet, &msg)
+	require.NoError(t, err)
+	assert.Equal(t, config.EventAPIConnected, msg.Event)
+	for _, gw := range []string{"gw2", "gw1"} {
+		_, err := b.Send(config.Message{Text: "to " + gw, Gateway: gw, Timestamp: time.Now()})
+		require.NoError(t, err)
+	}
+	res, err = b.readGRPC(resp.Body)
+	require.NoError(t, err)
+	var received config.Message
+	_, err = unmarshalMessage(res, &received)
+	require.NoError(t, err)
+	assert.Equal(t, "to gw1", received.Text)
+	assert.False(t, received.Timestamp.IsZero())
+
+	_, err = w.Write(frame(marshalMessage(&config.Message{Text: "from stream", Gateway: "gw2"})))
+	require.NoError(t, err)
+	assert.Equal(t, "from stream", (<-b.Remote).Text)
+	require.NoError(t, w.Close())
+}
@@ -0,0 +1,343 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The protobuf encoding of the messages of matterbridge.proto. There's no protoc in
+// the build, so they're encoded by hand; TestProtoFieldNumbers checks the field
+// numbers against the .proto file.
+
+// Field numbers of Message.
+const (
+	pbMessageText      = 1
+	pbMessageChannel   = 2
+	pbMessageUsername  = 3
+	pbMessageUserID    = 4
+	pbMessageAvatar    = 5
+	pbMessageAccount   = 6
+	pbMessageEvent     = 7
+	pbMessageProtocol  = 8
+	pbMessageGateway   = 9
+	pbMessageParentID  = 10
+	pbMessageTimestamp = 11
+	pbMessageID        = 12
+	pbMessageFiles     = 13
+	pbMessageReaction  = 14
+	pbMessageQuote     = 15
+)
+
+// Field numbers of Reaction.
+const (
+	pbReactionEmoji    = 1
+	pbReactionTargetID = 2
+	pbReactionRemoved  = 3
+)
+
+// Field numbers of Quote.
+const (
+	pbQuoteUsername = 1
+	pbQuoteText     = 2
+	pbQuoteInText   = 3
+)
+
+// Field numbers of FileInfo.
+const (
+	pbFileName     = 1
+	pbFileData     = 2
+	pbFileComment  = 3
+	pbFileURL      = 4
+	pbFileSize     = 5
+	pbFileAvatar   = 6
+	pbFileSHA      = 7
+	pbFileNativeID = 8
+)
+
+var errInvalidProto = errors.New("invalid protobuf message")
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// marshalMessage returns the Message of msg, with the files of its Extra.
+func marshalMessage(msg *config.Message) []byte {
+	var b []byte
+	b = appendString(b, pbMessageText, msg.Text)
+	b = appendString(b, pbMessageChannel, msg.Channel)
+	b = appendString(b, pbMessageUsername, msg.Username)
+	b = appendString(b, pbMessageUserID, msg.UserID)
+	b = appendString(b, pbMessageAvatar, msg.Avatar)
+	b = appendString(b, pbMessageAccount, msg.Account)
+	b = appendString(b, pbMessageEvent, msg.Event)
+	b = appendString(b, pbMessageProtocol, msg.Protocol)
+	b = appendString(b, pbMessageGateway, msg.Gateway)
+	b = appendString(b, pbMessageParentID, msg.ParentID)
+	if !msg.Timestamp.IsZero() {
+		var ts []byte
+		ts = appendVarint(ts, 1, uint64(msg.Timestamp.Unix()))
+		ts = appendVarint(ts, 2, uint64(msg.Timestamp.Nanosecond()))
+		b = appendMessage(b, pbMessageTimestamp, ts)
+	}
+	b = appendString(b, pbMessageID, msg.ID)
+	for _, v := range msg.Extra["file"] {
+		fi, ok := v.(config.FileInfo)
+		if !ok {
+			continue
+		}
+		var f []byte
+		f = appendString(f, pbFileName, fi.Name)
+		if fi.Data != nil && len(*fi.Data) > 0 {
+			f = protowire.AppendTag(f, pbFileData, protowire.BytesType)
+			f = protowire.AppendBytes(f, *fi.Data)
+		}
+		f = appendString(f, pbFileComment, fi.Comment)
+		f = appendString(f, pbFileURL, fi.URL)
+		f = appendVarint(f, pbFileSize, uint64(fi.Size))
+		if fi.Avatar {
+			f = appendVarint(f, pbFileAvatar, 1)
+		}
+		f = appendString(f, pbFileSHA, fi.SHA)
+		f = appendString(f, pbFileNativeID, fi.NativeID)
+		b = appendMessage(b, pbMessageFiles, f)
+	}
+	if r := msg.Reaction; r != nil {
+		var m []byte
+		m = appendString(m, pbReactionEmoji, r.Emoji)
+		m = appendString(m, pbReactionTargetID, r.TargetID)
+		if r.Removed {
+			m = appendVarint(m, pbReactionRemoved, 1)
+		}
+		b = appendMessage(b, pbMessageReaction, m)
+	}
+	if q := msg.Quote; q != nil {
+		var m []byte
+		m = appendString(m, pbQuoteUsername, q.Username)
+		m = appendString(m, pbQuoteText, q.Text)
+		if q.InText {
+			m = appendVarint(m, pbQuoteInText, 1)
+		}
+		b = appendMessage(b, pbMessageQuote, m)
+	}
+	return b
+}
+
+// consumeFields calls field for every field of the message in b.
+func consumeFields(b []byte, field func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errInvalidProto
+		}
+		b = b[n:]
+		n, err := field(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// unknown field
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return errInvalidProto
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// consumeBytes returns the value of a length-delimited field, and its length, or -1.
+func consumeBytes(typ protowire.Type, b []byte) ([]byte, int) {
+	if typ != protowire.BytesType {
+		return nil, -1
+	}
+	return protowire.ConsumeBytes(b)
+}
+
+func consumeVarint(typ protowire.Type, b []byte) (uint64, int) {
+	if typ != protowire.VarintType {
+		return 0, -1
+	}
+	return protowire.ConsumeVarint(b)
+}
+
+// unmarshalMessage decodes the Message in b into msg and returns its files.
+func unmarshalMessage(b []byte, msg *config.Message) ([]config.FileInfo, error) {
+	var files []config.FileInfo
+	fields := map[protowire.Number]*string{
+		pbMessageText:     &msg.Text,
+		pbMessageChannel:  &msg.Channel,
+		pbMessageUsername: &msg.Username,
+		pbMessageUserID:   &msg.UserID,
+		pbMessageAvatar:   &msg.Avatar,
+		pbMessageAccount:  &msg.Account,
+		pbMessageEvent:    &msg.Event,
+		pbMessageProtocol: &msg.Protocol,
+		pbMessageGateway:  &msg.Gateway,
+		pbMessageParentID: &msg.ParentID,
+		pbMessageID:       &msg.ID,
+	}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if s, ok := fields[num]; ok {
+			v, n := consumeBytes(typ, b)
+			*s = string(v)
+			return n, nil
+		}
+		switch num {
+		case pbMessageTimestamp:
+			v, n := consumeBytes(typ, b)
+			if n < 0 {
+				return n, nil
+			}
+			var sec, nsec uint64
+			err := consumeFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+				var n int
+				switch num {
+				case 1:
+					sec, n = consumeVarint(typ, b)
+				case 2:
+					nsec, n = consumeVarint(typ, b)
+				}
+				return n, nil
+			})
+			msg.Timestamp = time.Unix(int64(sec), int64(nsec))
+			return n, err
+		case pbMessageFiles:
+			v, n := consumeBytes(typ, b)
+			if n < 0 {
+				return n, nil
+			}
+			fi, err := unmarshalFileInfo(v)
+			files = append(files, fi)
+			return n, err
+		case pbMessageReaction:
+			v, n := consumeBytes(typ, b)
+			if n < 0 {
+				return n, nil
+			}
+			msg.Reaction = &config.Reaction{}
+			return n, unmarshalReaction(v, msg.Reaction)
+		case pbMessageQuote:
+			v, n := consumeBytes(typ, b)
+			if n < 0 {
+				return n, nil
+			}
+			msg.Quote = &config.Quote{}
+			return n, unmarshalQuote(v, msg.Quote)
+		}
+		return 0, nil
+	})
+	return files, err
+}
+
+func unmarshalFileInfo(b []byte) (config.FileInfo, error) {
+	var fi config.FileInfo
+	fields := map[protowire.Number]*string{
+		pbFileName:     &fi.Name,
+		pbFileComment:  &fi.Comment,
+		pbFileURL:      &fi.URL,
+		pbFileSHA:      &fi.SHA,
+		pbFileNativeID: &fi.NativeID,
+	}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if s, ok := fields[num]; ok {
+			v, n := consumeBytes(typ, b)
+			*s = string(v)
+			return n, nil
+		}
+		switch num {
+		case pbFileData:
+			v, n := consumeBytes(typ, b)
+			data := append([]byte(nil), v...)
+			fi.Data = &data
+			return n, nil
+		case pbFileSize:
+			v, n := consumeVarint(typ, b)
+			fi.Size = int64(v)
+			return n, nil
+		case pbFileAvatar:
+			v, n := consumeVarint(typ, b)
+			fi.Avatar = v != 0
+			return n, nil
+		}
+		return 0, nil
+	})
+	return fi, err
+}
+
+func unmarshalReaction(b []byte, r *config.Reaction) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case pbReactionEmoji:
+			v, n := consumeBytes(typ, b)
+			r.Emoji = string(v)
+			return n, nil
+		case pbReactionTargetID:
+			v, n := consumeBytes(typ, b)
+			r.TargetID = string(v)
+			return n, nil
+		case pbReactionRemoved:
+			v, n := consumeVarint(typ, b)
+			r.Removed = v != 0
+			return n, nil
+		}
+		return 0, nil
+	})
+}
+
+func unmarshalQuote(b []byte, q *config.Quote) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case pbQuoteUsername:
+			v, n := consumeBytes(typ, b)
+			q.Username = string(v)
+			return n, nil
+		case pbQuoteText:
+			v, n := consumeBytes(typ, b)
+			q.Text = string(v)
+			return n, nil
+		case pbQuoteInText:
+			v, n := consumeVarint(typ, b)
+			q.InText = v != 0
+			return n, nil
+		}
+		return 0, nil
+	})
+}
+
+// marshalGateways returns the ListGatewaysResponse of gateways.
+func marshalGateways(gateways []apiGateway) []byte {
+	var b []byte
+	for _, gw := range gateways {
+		var g []byte
+		g = appendString(g, 1, gw.name)
+		for _, channel := range gw.channels {
+			var c []byte
+			c = appendString(c, 1, channel.Name)
+			c = appendString(c, 2, channel.Direction)
+			g = appendMessage(g, 2, c)
+		}
+		b = appendMessage(b, 1, g)
+	}
+	return b
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type protoField struct {
+	name string
+	typ  string
+}
+
+var (
+	protoMessageRe = regexp.MustCompile(`^message (\w+) \{`)
+	protoFieldRe   = regexp.MustCompile(`^(?:repeated )?([\w.]+) (\w+) = (\d+);`)
+)
+
+// parseProto returns the fields of the messages in the .proto file by message name
+// and field number.
+func parseProto(t *testing.T, path string) map[string]map[protowire.Number]protoField {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	messages := map[string]map[protowire.Number]protoField{
+		"google.protobuf.Timestamp": {1: {"seconds", "int64"}, 2: {"nanos", "int32"}},
+	}
+	var fields map[protowire.Number]protoField
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if m := protoMessageRe.FindStringSubmatch(line); m != nil {
+			fields = make(map[protowire.Number]protoField)
+			messages[m[1]] = fields
+			continue
+		}
+		if m := protoFieldRe.FindStringSubmatch(line); m != nil && fields != nil {
+			num, err := strconv.Atoi(m[3])
+			require.NoError(t, err)
+			fields[protowire.Number(num)] = protoField{name: m[2], typ: m[1]}
+		}
+	}
+	return messages
+}
+
+// checkProto checks that b is a message of the .proto, with every string and bytes
+// field set to the name of the field in the .proto, and adds the fields it has to seen.
+func checkProto(t *testing.T, messages map[string]map[protowire.Number]protoField, message string, b []byte, seen map[string]bool) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.True(t, n > 0, message)
+		b = b[n:]
+		field, ok := messages[message][num]
+		require.True(t, ok, "%s has no field %d", message, num)
+		seen[message+"."+field.name] = true
+		switch field.typ {
+		case "bool", "int32", "int64":
+			require.Equal(t, protowire.VarintType, typ, "%s.%s", message, field.name)
+			v, n := protowire.ConsumeVarint(b)
+			require.True(t, n > 0)
+			assert.NotZero(t, v, "%s.%s", message, field.name)
+			b = b[n:]
+		default:
+			require.Equal(t, protowire.BytesType, typ, "%s.%s", message, field.name)
+			v, n := protowire.ConsumeBytes(b)
+			require.True(t, n > 0)
+			if _, ok := messages[field.typ]; ok {
+				checkProto(t, messages, field.typ, v, seen)
+			} else {
+				assert.Equal(t, field.name, string(v), "%s.%s", message, field.name)
+			}
+			b = b[n:]
+		}
+	}
+}
+
+// TestProtoFieldNumbers checks the hand-written encoding against matterbridge.proto.
+func TestProtoFieldNumbers(t *testing.T) {
+	messages := parseProto(t, "matterbridge.proto")
+	data := []byte("data")
+	file := config.FileInfo{
+		Name: "name", Data: &data, Comment: "comment", URL: "url", Size: 5, Avatar: true,
+		SHA: "sha", NativeID: "native_id",
+	}
+	msg := config.Message{
+		Text: "text", Channel: "channel", Username: "username", UserID: "userid",
+		Avatar: "avatar", Account: "account", Event: "event", Protocol: "protocol",
+		Gateway: "gateway", ParentID: "parent_id", Timestamp: time.Unix(1, 2), ID: "id",
+		Extra:    map[string][]interface{}{"file": {file}},
+		Reaction: &config.Reaction{Emoji: "emoji", TargetID: "target_id", Removed: true},
+		Quote:    &config.Quote{Username: "username", Text: "text", InText: true},
+	}
+	seen := make(map[string]bool)
+	b := marshalMessage(&msg)
+	checkProto(t, messages, "Message", b, seen)
+
+	var decoded config.Message
+	files, err := unmarshalMessage(b, &decoded)
+	require.NoError(t, err)
+	assert.Equal(t, []config.FileInfo{file}, files)
+	decoded.Extra = msg.Extra
+	assert.Equal(t, msg, decoded)
+
+	checkProto(t, messages, "ListGatewaysResponse", marshalGateways([]apiGateway{{
+		name:     "name",
+		channels: []config.ChannelInfo{{Name: "name", Direction: "direction"}},
+	}}), seen)
+
+	// every field of the messages that are sent is encoded
+	for message, fields := range messages {
+		if message == "ListGatewaysRequest" {
+			continue
+		}
+		for _, field := range fields {
+			assert.True(t, seen[message+"."+field.name], "%s.%s isn't encoded", message, field.name)
+		}
+	}
+}
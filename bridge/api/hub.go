@@ -0,0 +1,118 @@
+package api
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// defaultSubscriberBuffer is the amount of messages queued for a subscriber
+// before it's considered too slow and disconnected.
+const defaultSubscriberBuffer = 100
+
+// hubMessage is a message published to the subscribers, with its sequence number
+// and JSON encoding.
+type hubMessage struct {
+	seq  uint64
+	msg  config.Message
+	data []byte
+}
+
+// hub fans out the messages sent to the API to all stream and websocket clients.
+type hub struct {
+	sync.Mutex
+
+	size int
+	subs map[*subscriber]struct{}
+}
+
+// subscriber receives the published messages on messages. The channel is closed
+// when the subscriber is removed, dropped is true if that's because it was too slow.
+type subscriber struct {
+	messages chan hubMessage
+	filter   filter
+	dropped  bool
+}
+
+// filter selects messages by gateway and channel, an empty set matches everything.
+type filter struct {
+	gateways map[string]bool
+	channels map[string]bool
+}
+
+// newFilter returns the filter of the gateway and channel query parameters. They
+// can be repeated or hold comma separated lists.
+func newFilter(query url.Values) filter {
+	return filter{
+		gateways: filterSet(query["gateway"]),
+		channels: filterSet(query["channel"]),
+	}
+}
+
+func filterSet(values []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				set[v] = true
+			}
+		}
+	}
+	return set
+}
+
+func (f filter) match(msg *config.Message) bool {
+	if len(f.gateways) > 0 && !f.gateways[msg.Gateway] {
+		return false
+	}
+	return len(f.channels) == 0 || f.channels[msg.Channel]
+}
+
+func newHub(size int) *hub {
+	if size <= 0 {
+		size = defaultSubscriberBuffer
+	}
+	return &hub{size: size, subs: make(map[*subscriber]struct{})}
+}
+
+func (h *hub) subscribe(f filter) *subscriber {
+	h.Lock()
+	defer h.Unlock()
+	s := &subscriber{messages: make(chan hubMessage, h.size), filter: f}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+// unsubscribe removes s, it's safe to call for subscribers that were dropped already.
+func (h *hub) unsubscribe(s *subscriber) {
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.messages)
+	}
+}
+
+// publish queues m for all subscribers whose filter matches it, subscribers whose
+// queue is full are dropped. Returns the number of dropped subscribers.
+func (h *hub) publish(m hubMessage) int {
+	h.Lock()
+	defer h.Unlock()
+	dropped := 0
+	for s := range h.subs {
+		if !s.filter.match(&m.msg) {
+			continue
+		}
+		select {
+		case s.messages <- m:
+		default:
+			s.dropped = true
+			delete(h.subs, s)
+			close(s.messages)
+			dropped++
+		}
+	}
+	return dropped
+}
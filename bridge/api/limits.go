@@ -0,0 +1,237 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultRateLimitBurst is the burst of the rate limits when RateLimitBurst is unset.
+	defaultRateLimitBurst = 10
+	// defaultMaxTextLength and defaultMaxUsernameLength are used when MaxTextLength
+	// and MaxUsernameLength are unset.
+	defaultMaxTextLength     = 10000
+	defaultMaxUsernameLength = 100
+	// maxBuckets is the amount of buckets a limiter keeps before it removes the idle ones.
+	maxBuckets = 10000
+)
+
+// limiter is a token bucket rate limit per key. A nil *limiter doesn't limit anything.
+type limiter struct {
+	sync.Mutex
+	limit   rate.Limit
+	burst   int
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	*rate.Limiter
+	last time.Time
+}
+
+// newLimiter returns a limiter of perMinute messages, nil when perMinute isn't positive.
+func newLimiter(perMinute, burst int) *limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = defaultRateLimitBurst
+	}
+	return &limiter{
+		limit:   rate.Limit(float64(perMinute) / 60),
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// reserve takes a token from the bucket of key. It returns nil if l is nil.
+func (l *limiter) reserve(key string, now time.Time) *rate.Reservation {
+	if l == nil {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.prune(now)
+		}
+		b = &bucket{Limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.last = now
+	return b.ReserveN(now, 1)
+}
+
+// prune removes the buckets that have been idle long enough to be full again. The
+// caller must hold the lock.
+func (l *limiter) prune(now time.Time) {
+	full := time.Duration(float64(l.burst) / float64(l.limit) * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// allow takes a message from the rate limits of token t and of the address of r. It
+// returns 0 if the message is allowed, or otherwise how long the client has to wait.
+// Without a token the client gets the token limit for its address, so one client
+// can't use up the limit of all the others.
+func (b *API) allow(t *apiToken, r *http.Request) time.Duration {
+	now := time.Now()
+	key := "token:" + t.name()
+	if t == nil {
+		key = "ip:" + remoteHost(r)
+	}
+	reservations := []*rate.Reservation{
+		b.tokenLimiter.reserve(key, now),
+		b.ipLimiter.reserve(remoteHost(r), now),
+	}
+	var wait time.Duration
+	for _, res := range reservations {
+		if res != nil && res.DelayFrom(now) > wait {
+			wait = res.DelayFrom(now)
+		}
+	}
+	if wait > 0 {
+		// rejected messages don't count
+		for _, res := range reservations {
+			if res != nil {
+				res.CancelAt(now)
+			}
+		}
+	}
+	return wait
+}
+
+// checkRate returns an error, and how long to wait, when a message of token t from
+// the client of r is over the rate limits.
+func (b *API) checkRate(r *http.Request, t *apiToken) (time.Duration, error) {
+	if wait := b.allow(t, r); wait > 0 {
+		return wait, b.reject(r, t, rejectRateLimit, http.StatusTooManyRequests)
+	}
+	return 0, nil
+}
+
+// rateLimit is the middleware that rejects the messages over the rate limits.
+func (b *API) rateLimit(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if wait, err := b.checkRate(c.Request(), contextToken(c)); err != nil {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			return err
+		}
+		return next(c)
+	}
+}
+
+// remoteHost returns the address of the client of r, without the port.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkLength returns an error if the text or the username of msg is too long.
+func (b *API) checkLength(msg *config.Message) error {
+	maxText, maxUsername := b.GetInt("MaxTextLength"), b.GetInt("MaxUsernameLength")
+	if maxText <= 0 {
+		maxText = defaultMaxTextLength
+	}
+	if maxUsername <= 0 {
+		maxUsername = defaultMaxUsernameLength
+	}
+	if n := utf8.RuneCountInString(msg.Text); n > maxText {
+		return newError(http.StatusBadRequest, "text_too_long", "text has %d characters, the maximum is %d", n, maxText)
+	}
+	if n := utf8.RuneCountInString(msg.Username); n > maxUsername {
+		return newError(http.StatusBadRequest, "username_too_long", "username has %d characters, the maximum is %d", n, maxUsername)
+	}
+	return nil
+}
+
+// apiGateway is a gateway with the channels of the API in it.
+type apiGateway struct {
+	name     string
+	channels []config.ChannelInfo
+}
+
+// gateways returns the enabled gateways with channels of the API, by name.
+func (b *API) gateways() []apiGateway {
+	values := b.Config.Config.BridgeValues()
+	var gateways []apiGateway
+	for _, gw := range values.Gateway {
+		if !gw.Enable {
+			continue
+		}
+		g := apiGateway{name: gw.Name}
+		for _, dir := range []struct {
+			name string
+			brs  []config.Bridge
+		}{{"in", gw.In}, {"out", gw.Out}, {"inout", gw.InOut}} {
+			for _, br := range dir.brs {
+				if br.Account != b.Account {
+					continue
+				}
+				name := br.Channel
+				if name == "" {
+					name = defaultChannel
+				}
+				g.channels = append(g.channels, config.ChannelInfo{Name: name, Direction: dir.name})
+			}
+		}
+		if len(g.channels) > 0 {
+			gateways = append(gateways, g)
+		}
+	}
+	for _, gw := range values.SameChannelGateway {
+		if !gw.Enable || !contains(gw.Accounts, b.Account) {
+			continue
+		}
+		g := apiGateway{name: gw.Name}
+		for _, name := range gw.Channels {
+			g.channels = append(g.channels, config.ChannelInfo{Name: name, Direction: "inout"})
+		}
+		gateways = append(gateways, g)
+	}
+	sort.Slice(gateways, func(i, j int) bool { return gateways[i].name < gateways[j].name })
+	return gateways
+}
+
+// sendsTo returns true if the channel of the API sends messages to gateway, messages
+// to other gateways would be dropped by the router.
+func (b *API) sendsTo(gateway, channel string) bool {
+	for _, gw := range b.gateways() {
+		if gw.name != gateway {
+			continue
+		}
+		for _, c := range gw.channels {
+			if c.Name == channel && strings.Contains(c.Direction, "in") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimits(t *testing.T) {
+	b := newTestAPI()
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	b.Config.Config = config.NewConfigFromString(logger, []byte("[api.local]\nMaxTextLength=5\nMaxUsernameLength=3\n"+testGateways))
+	require.NoError(t, b.JoinChannel(config.ChannelInfo{Name: "api"}))
+	require.NoError(t, b.JoinChannel(config.ChannelInfo{Name: "dev"}))
+
+	post := func(addr, body string) (*httptest.ResponseRecorder, apiError) {
+		e := echo.New()
+		b.addRoutes(e)
+		req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		var res map[string]apiError
+		if rec.Code != http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		} else {
+			<-b.Remote
+		}
+		return rec, res["error"]
+	}
+
+	// messages are validated before they're sent to the gateway
+	_, res := post("192.0.2.1:1", `{"text":"hi","gateway":"gw3"}`)
+	assert.Equal(t, apiError{Status: http.StatusBadRequest, Code: "unknown_gateway", Message: `channel api doesn't send to gateway "gw3"`}, res)
+	_, res = post("192.0.2.1:1", `{"text":"hi","gateway":"gw2","channel":"dev"}`)
+	assert.Equal(t, "unknown_gateway", res.Code)
+	_, res = post("192.0.2.1:1", `{"text":"hello!","gateway":"gw1"}`)
+	assert.Equal(t, "text_too_long", res.Code)
+	_, res = post("192.0.2.1:1", `{"text":"hi","username":"joe2","gateway":"gw1"}`)
+	assert.Equal(t, "username_too_long", res.Code)
+	rec, _ := post("192.0.2.1:1", `{"text":"hi","username":"joe","gateway":"gw1","channel":"dev"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// the limits are per token and per address
+	b.tokenLimiter = newLimiter(60, 3)
+	b.ipLimiter = newLimiter(60, 2)
+	for i := 0; i < 2; i++ {
+		rec, _ = post("192.0.2.1:1", `{"text":"hi","gateway":"gw1"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	rec, res = post("192.0.2.1:2", `{"text":"hi","gateway":"gw1"}`)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Equal(t, rejectRateLimit, res.Code)
+	rec, _ = post("192.0.2.2:1", `{"text":"hi","gateway":"gw1"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// without tokens one client doesn't use up the token limit of the others
+	b.tokenLimiter = newLimiter(60, 3)
+	b.ipLimiter = nil
+	for i := 0; i < 3; i++ {
+		rec, _ = post("192.0.2.3:1", `{"text":"hi","gateway":"gw1"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	rec, _ = post("192.0.2.3:1", `{"text":"hi","gateway":"gw1"}`)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	rec, _ = post("192.0.2.4:1", `{"text":"hi","gateway":"gw1"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// errors of echo itself are structured as well
+	rec = b.request(http.MethodGet, "/api/unknown", "", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.JSONEq(t, `{"error":{"status":404,"code":"not_found","message":"Not Found"}}`, rec.Body.String())
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/labstack/echo/v4"
+)
+
+// routeDoc documents a route in the OpenAPI document.
+type routeDoc struct {
+	summary     string
+	description string
+	params      []paramDoc
+	// body is an example of the request body, nil without body.
+	body interface{}
+	// multipart is true when the body can be sent as multipart/form-data with files.
+	multipart bool
+	// status of a successful response, 200 if 0.
+	status int
+	// contentType of the response, application/json if empty.
+	contentType string
+	// response is an example of the response, nil without a documented body.
+	response interface{}
+	// errors are the documented error responses.
+	errors map[int]string
+}
+
+type paramDoc struct {
+	name        string
+	description string
+	typ         string
+}
+
+var (
+	filterParams = []paramDoc{
+		{"gateway", "Only these gateways, can be repeated or a comma separated list.", "string"},
+		{"channel", "Only these channels, can be repeated or a comma separated list.", "string"},
+	}
+	replayParams = append([]paramDoc{
+		{"backlog", "Replay all buffered messages first.", "boolean"},
+		{"since", "Replay the buffered messages after this message ID or RFC3339 timestamp first.", "string"},
+	}, filterParams...)
+)
+
+// routeDocs documents the routes of the API by method and path.
+var routeDocs = map[string]routeDoc{
+	"GET /api/health": {
+		summary:     "Connection state of all bridges",
+		description: "The bridges are only listed for tokens with read access to all gateways.",
+		response:    health{},
+	},
+	"GET /api/messages": {
+		summary: "List new messages",
+		description: "Returns the buffered messages the client hasn't read yet, without removing them " +
+			"from the buffer.",
+		params: append([]paramDoc{
+			{"client", "Name of the client, the messages it has read with its token are remembered for a day.", "string"},
+			{"since", "ID or RFC3339 timestamp of the last message the client has seen.", "string"},
+		}, filterParams...),
+		response: []config.Message{},
+	},
+	"GET /api/stream": {
+		summary:     "Stream realtime messages as JSON objects separated by newlines",
+		params:      replayParams,
+		contentType: "application/x-json-stream",
+		response:    config.Message{},
+	},
+	"GET /api/events": {
+		summary: "Stream realtime messages as server-sent events",
+		description: "The event IDs are sequence numbers, reconnecting clients get the messages they " +
+			"missed with the Last-Event-ID header.",
+		params:      replayParams,
+		contentType: "text/event-stream",
+		response:    config.Message{},
+	},
+	"GET /api/websocket": {
+		summary: "Send and receive messages over a websocket",
+		description: "Messages are sent and received as JSON objects, the messages sent over the " +
+			"websocket are also received by the other websocket clients.",
+		params: replayParams,
+		status: http.StatusSwitchingProtocols,
+	},
+	"POST /api/message": {
+		summary: "Create a message",
+		description: "Messages go to the channel \"api\" (or the only channel of the API) unless " +
+			"they name another configured channel. The response has the ID to edit or delete it. " +
+			"Files are uploaded with a multipart/form-data request.",
+		body:      config.Message{},
+		multipart: true,
+		response:  config.Message{},
+		errors: map[int]string{
+			http.StatusBadRequest: "The channel isn't configured for the API, it doesn't send to the gateway " +
+				"or the text or username is too long",
+			http.StatusRequestEntityTooLarge: "An uploaded file is larger than MediaDownloadSize",
+			http.StatusTooManyRequests:       "Over the rate limit, retry after the Retry-After header",
+		},
+	},
+	"PUT /api/message/:id": {
+		summary:     "Edit a message",
+		description: "The gateway and channel of the original message are kept, as is its user when username is empty.",
+		body:        config.Message{},
+		response:    config.Message{},
+		errors: map[int]string{
+			http.StatusBadRequest:      "The text or username is too long",
+			http.StatusNotFound:        "Unknown message",
+			http.StatusTooManyRequests: "Over the rate limit, retry after the Retry-After header",
+		},
+	},
+	"DELETE /api/message/:id": {
+		summary:  "Delete a message",
+		response: config.Message{},
+		errors: map[int]string{
+			http.StatusNotFound:        "Unknown message",
+			http.StatusTooManyRequests: "Over the rate limit, retry after the Retry-After header",
+		},
+	},
+	"GET /api/openapi.json": {
+		summary: "This OpenAPI document",
+	},
+}
+
+const apiDescription = "A read/write API for the Matterbridge chat bridge. Tokens can be limited " +
+	"to reading or writing and to some gateways, other requests are rejected with 403."
+
+// errorBody is the body of error responses.
+type errorBody struct {
+	Error apiError `json:"error"`
+}
+
+var pathParamRe = regexp.MustCompile(`:([^/]+)`)
+
+// openAPI returns the OpenAPI 3 document of the routes.
+func (b *API) openAPI(routes []*echo.Route) map[string]interface{} {
+	paths := make(map[string]map[string]interface{})
+	for _, route := range routes {
+		path := pathParamRe.ReplaceAllString(route.Path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		paths[path][strings.ToLower(route.Method)] = openAPIOperation(route)
+	}
+	doc := map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":       "Matterbridge API",
+			"description": apiDescription,
+			"version":     "0.2.0",
+			"license": map[string]string{
+				"name": "Apache 2.0",
+				"url":  "https://github.com/42wim/matterbridge/blob/master/LICENSE",
+			},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Message": jsonSchema(reflect.TypeOf(config.Message{})),
+				"Error":   jsonSchema(reflect.TypeOf(errorBody{})),
+			},
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]string{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+	if len(b.tokens) > 0 {
+		doc["security"] = []map[string][]string{{"bearerAuth": {}}}
+	}
+	return doc
+}
+
+func openAPIOperation(route *echo.Route) map[string]interface{} {
+	doc, ok := routeDocs[route.Method+" "+route.Path]
+	if !ok {
+		doc.summary = route.Method + " " + route.Path
+	}
+	op := map[string]interface{}{"summary": doc.summary}
+	if doc.description != "" {
+		op["description"] = doc.description
+	}
+	var params []map[string]interface{}
+	for _, match := range pathParamRe.FindAllStringSubmatch(route.Path, -1) {
+		params = append(params, map[string]interface{}{
+			"name": match[1], "in": "path", "required": true,
+			"schema": map[string]string{"type": "string"},
+		})
+	}
+	for _, p := range doc.params {
+		params = append(params, map[string]interface{}{
+			"name": p.name, "in": "query", "description": p.description,
+			"schema": map[string]string{"type": p.typ},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if doc.body != nil {
+		schema := schemaOf(doc.body)
+		content := map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+		if doc.multipart {
+			content["multipart/form-data"] = map[string]interface{}{
+				"schema": map[string]interface{}{"allOf": []interface{}{schema, map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"comment": map[string]string{"type": "string", "description": "Comment of the uploaded files"},
+						"file": map[string]interface{}{
+							"type": "array", "description": "Files to upload",
+							"items": map[string]string{"type": "string", "format": "binary"},
+						},
+					},
+				}}},
+			}
+		}
+		op["requestBody"] = map[string]interface{}{"required": true, "content": content}
+	}
+	if doc.status == 0 {
+		doc.status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(doc.status)}
+	if doc.response != nil {
+		contentType := doc.contentType
+		if contentType == "" {
+			contentType = echo.MIMEApplicationJSON
+		}
+		success["content"] = map[string]interface{}{contentType: map[string]interface{}{"schema": schemaOf(doc.response)}}
+	}
+	responses := map[string]interface{}{strconv.Itoa(doc.status): success}
+	for code, description := range doc.errors {
+		responses[strconv.Itoa(code)] = map[string]interface{}{
+			"description": description,
+			"content": map[string]interface{}{echo.MIMEApplicationJSON: map[string]interface{}{
+				"schema": map[string]string{"$ref": "#/components/schemas/Error"},
+			}},
+		}
+	}
+	op["responses"] = responses
+	return op
+}
+
+// schemaOf returns the schema of v, messages refer to the Message component.
+func schemaOf(v interface{}) map[string]interface{} {
+	switch v.(type) {
+	case config.Message:
+		return map[string]interface{}{"$ref": "#/components/schemas/Message"}
+	case []config.Message:
+		return map[string]interface{}{"type": "array", "items": schemaOf(config.Message{})}
+	}
+	return jsonSchema(reflect.TypeOf(v))
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchema returns the JSON schema of the JSON encoding of t.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := field.Name
+			if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+			properties[name] = jsonSchema(field.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+	// interface{} can be anything
+	return map[string]interface{}{}
+}
+
+func (b *API) handleOpenAPI(c echo.Context) error {
+	return c.JSON(http.StatusOK, b.openAPI(c.Echo().Routes()))
+}
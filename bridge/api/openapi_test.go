@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPI(t *testing.T) {
+	b := newTestAPI()
+	e := echo.New()
+	b.addRoutes(e)
+	for _, route := range e.Routes() {
+		assert.Contains(t, routeDocs, route.Method+" "+route.Path, "route isn't documented")
+	}
+
+	rec := b.request(http.MethodGet, "/api/openapi.json", "", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var doc struct {
+		Paths map[string]map[string]struct {
+			Parameters []struct {
+				Name string
+				In   string
+			}
+			Responses map[string]interface{}
+		}
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{}
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+
+	assert.Contains(t, doc.Paths["/api/message/{id}"], "put")
+	assert.Contains(t, doc.Paths["/api/message/{id}"]["delete"].Responses, "404")
+	assert.Equal(t, "id", doc.Paths["/api/message/{id}"]["delete"].Parameters[0].Name)
+	assert.Equal(t, "path", doc.Paths["/api/message/{id}"]["delete"].Parameters[0].In)
+	assert.Contains(t, doc.Paths["/api/websocket"]["get"].Responses, "101")
+
+	message := doc.Components.Schemas["Message"].Properties
+	assert.Equal(t, "string", message["parent_id"]["type"])
+	assert.Equal(t, "date-time", message["timestamp"]["format"])
+	assert.Equal(t, "object", message["Extra"]["type"])
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/internal/metrics"
+	"github.com/labstack/echo/v4"
+)
+
+// Access a route needs.
+const (
+	accessRead  = "read"
+	accessWrite = "write"
+	// accessAny is for routes every token can use.
+	accessAny       = "any"
+	accessReadWrite = "readwrite"
+)
+
+// Reasons for metrics.APIRejections.
+const (
+	rejectMissing = "missing_token"
+	rejectInvalid = "invalid_token"
+	rejectAccess  = "access"
+	rejectGateway = "gateway"
+	// rejectRateLimit is for messages over the RateLimit or RateLimitIP.
+	rejectRateLimit = "rate_limit"
+)
+
+// apiToken is a token clients authenticate with. A nil *apiToken is used when no
+// tokens are configured and can do everything.
+type apiToken struct {
+	config.APIToken
+	read     bool
+	write    bool
+	gateways map[string]bool
+}
+
+// newTokens returns the tokens of the API: the Token, with access to everything,
+// and the Tokens with their own permissions.
+func (b *API) newTokens() []*apiToken {
+	var tokens []*apiToken
+	if token := b.GetString("Token"); token != "" {
+		tokens = append(tokens, &apiToken{
+			APIToken: config.APIToken{Name: "Token", Token: token},
+			read:     true,
+			write:    true,
+		})
+	}
+	for _, cfg := range accountConfig(b.Config.Config, b.Account).Tokens {
+		if cfg.Token == "" {
+			b.Log.Errorf("token %s of %s has no Token, ignoring it", cfg.Name, b.Account)
+			continue
+		}
+		t := &apiToken{APIToken: cfg, gateways: make(map[string]bool)}
+		switch cfg.Access {
+		case "", accessRead:
+			t.read = true
+		case accessWrite:
+			t.write = true
+		case accessReadWrite:
+			t.read, t.write = true, true
+		default:
+			b.Log.Errorf("token %s of %s has an unknown Access %s, ignoring it", cfg.Name, b.Account, cfg.Access)
+			continue
+		}
+		for _, gw := range cfg.Gateways {
+			t.gateways[gw] = true
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens
+}
+
+func (t *apiToken) name() string {
+	if t == nil {
+		return ""
+	}
+	return t.Name
+}
+
+func (t *apiToken) allows(access string) bool {
+	switch {
+	case t == nil || access == accessAny:
+		return true
+	case access == accessRead:
+		return t.read
+	default:
+		return t.write
+	}
+}
+
+// readsAll returns true if the token can read the messages of all gateways.
+func (t *apiToken) readsAll() bool {
+	return t == nil || (t.read && len(t.gateways) == 0)
+}
+
+func (t *apiToken) allowsGateway(gateway string) bool {
+	return t == nil || len(t.gateways) == 0 || t.gateways[gateway]
+}
+
+// restrict limits f to the gateways of the token. It returns false when f only has
+// gateways the token can't read.
+func (t *apiToken) restrict(f filter) (filter, bool) {
+	if t == nil || len(t.gateways) == 0 {
+		return f, true
+	}
+	if len(f.gateways) == 0 {
+		f.gateways = t.gateways
+		return f, true
+	}
+	gateways := make(map[string]bool)
+	for gw := range f.gateways {
+		if t.gateways[gw] {
+			gateways[gw] = true
+		}
+	}
+	f.gateways = gateways
+	return f, len(gateways) > 0
+}
+
+// authorize checks that msg can be posted with the token and prefixes its username.
+// It returns the reason of the rejection, or "" if it's allowed.
+func (t *apiToken) authorize(msg *config.Message) string {
+	switch {
+	case !t.allows(accessWrite):
+		return rejectAccess
+	case !t.allowsGateway(msg.Gateway):
+		return rejectGateway
+	}
+	if t != nil && !strings.HasPrefix(msg.Username, t.UsernamePrefix) {
+		msg.Username = t.UsernamePrefix + msg.Username
+	}
+	return ""
+}
+
+// canChange returns true if the token can edit or delete msg: it has to be posted
+// in one of its gateways, by one of its usernames.
+func (t *apiToken) canChange(msg *config.Message) bool {
+	return t.allows(accessWrite) && t.allowsGateway(msg.Gateway) &&
+		(t == nil || strings.HasPrefix(msg.Username, t.UsernamePrefix))
+}
+
+// lookupToken returns the token with the bearer token of r.
+func (b *API) lookupToken(r *http.Request) (*apiToken, bool) {
+	auth := r.Header.Get(echo.HeaderAuthorization)
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, false
+	}
+	key := []byte(strings.TrimPrefix(auth, "Bearer "))
+	for _, t := range b.tokens {
+		if subtle.ConstantTimeCompare(key, []byte(t.Token)) == 1 {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// authenticate returns the token of r, or an error if it doesn't have access. The
+// token is nil when no tokens are configured.
+func (b *API) authenticate(r *http.Request, access string) (*apiToken, error) {
+	if len(b.tokens) == 0 {
+		return nil, nil
+	}
+	if r.Header.Get(echo.HeaderAuthorization) == "" {
+		return nil, b.reject(r, nil, rejectMissing, http.StatusUnauthorized)
+	}
+	t, ok := b.lookupToken(r)
+	if !ok {
+		return nil, b.reject(r, nil, rejectInvalid, http.StatusUnauthorized)
+	}
+	if !t.allows(access) {
+		return nil, b.reject(r, t, rejectAccess, http.StatusForbidden)
+	}
+	return t, nil
+}
+
+// require returns the middleware that checks the token of a request for access.
+// The token is stored as "token" in the context.
+func (b *API) require(access string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			t, err := b.authenticate(c.Request(), access)
+			if err != nil {
+				return err
+			}
+			c.Set("token", t)
+			return next(c)
+		}
+	}
+}
+
+// reject logs and counts a rejected request and returns the error for the client.
+func (b *API) reject(r *http.Request, t *apiToken, reason string, code int) error {
+	b.Log.Warnf("rejected %s %s from %s with token %q: %s", r.Method, r.URL.Path, r.RemoteAddr, t.name(), reason)
+	metrics.APIRejections.Inc(b.Account, reason)
+	return newError(code, reason, "request rejected: %s", reason)
+}
+
+// contextToken returns the token of the request of c.
+func contextToken(c echo.Context) *apiToken {
+	t, _ := c.Get("token").(*apiToken)
+	return t
+}
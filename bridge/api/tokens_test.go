@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/internal/metrics"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokens(t *testing.T) {
+	b := newTestAPI()
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	b.Config.Config = config.NewConfigFromString(logger, []byte(`
+[api.local]
+Token="admin"
+[[api.local.Tokens]]
+Name="reader"
+Token="read"
+Gateways=["gw1"]
+[[api.local.Tokens]]
+Name="bot"
+Token="write"
+Access="write"
+Gateways=["gw1"]
+UsernamePrefix="bot-"
+`+testGateways))
+	b.tokens = b.newTokens()
+	require.Len(t, b.tokens, 3)
+	require.NoError(t, b.JoinChannel(config.ChannelInfo{Name: "api"}))
+
+	do := func(method, target, token, body string) *httptest.ResponseRecorder {
+		e := echo.New()
+		b.addRoutes(e)
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	request := func(method, target, token, body string) int {
+		return do(method, target, token, body).Code
+	}
+	rejected := metrics.APIRejections.Value("api.local", rejectInvalid)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/api/messages", "", ""))
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/api/messages", "wrong", ""))
+	assert.Equal(t, rejected+1, metrics.APIRejections.Value("api.local", rejectInvalid))
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/api/health", "write", ""))
+
+	// only tokens that read all gateways see the bridges of the health check
+	b.Statuses = func() []bridge.Status {
+		return []bridge.Status{{Account: "irc.other", State: bridge.StateFailed, LastError: "login failed"}}
+	}
+	assert.JSONEq(t, `{"status":"degraded"}`, do(http.MethodGet, "/api/health", "read", "").Body.String())
+	assert.JSONEq(t, `{"status":"degraded"}`, do(http.MethodGet, "/api/health", "write", "").Body.String())
+	assert.Contains(t, do(http.MethodGet, "/api/health", "admin", "").Body.String(), "login failed")
+	b.Statuses = nil
+
+	// writes need write access to the gateway
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/api/message", "read", `{"text":"hi","gateway":"gw1"}`))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPost, "/api/message", "write", `{"text":"hi","gateway":"gw2"}`))
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/api/message", "write", `{"text":"hi","username":"joe","gateway":"gw1"}`))
+	msg := <-b.Remote
+	assert.Equal(t, "bot-joe", msg.Username)
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/api/message", "admin", `{"text":"hi","username":"joe","gateway":"gw2"}`))
+	other := <-b.Remote
+	assert.Equal(t, "joe", other.Username)
+
+	// only messages of the token's gateways and usernames can be changed
+	assert.Equal(t, http.StatusForbidden, request(http.MethodDelete, "/api/message/"+other.ID, "write", ""))
+	assert.Equal(t, http.StatusOK, request(http.MethodPut, "/api/message/"+msg.ID, "write", `{"text":"edit"}`))
+	assert.Equal(t, "bot-joe", (<-b.Remote).Username)
+
+	// reads are limited to the token's gateways
+	for _, gw := range []string{"gw1", "gw2"} {
+		_, err := b.Send(config.Message{Text: "hello", Gateway: gw})
+		require.NoError(t, err)
+	}
+	get := func(query string) []config.Message {
+		rec := do(http.MethodGet, "/api/messages?"+query, "read", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var msgs []config.Message
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &msgs))
+		return msgs
+	}
+	msgs := get("since=0")
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "gw1", msgs[0].Gateway)
+	assert.Len(t, get("since=0&gateway=gw1,gw2"), 1)
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/api/messages?gateway=gw2", "read", ""))
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/jpillora/backoff"
+	"github.com/rs/xid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// webhookQueue is the amount of messages queued per webhook, when the queue
+	// is full new messages for the webhook are dropped.
+	webhookQueue             = 100
+	defaultWebhookMaxRetries = 5
+	webhookTimeout           = 10 * time.Second
+	// webhookEventMessage matches messages without an event in the Events filter.
+	webhookEventMessage = "message"
+)
+
+var webhookBackoff = backoff.Backoff{
+	Min:    time.Second,
+	Max:    time.Minute,
+	Factor: 2,
+	Jitter: true,
+}
+
+// webhook pushes the messages of the API to an URL. Messages are delivered in
+// order, one at a time.
+type webhook struct {
+	config.Webhook
+	log      *logrus.Entry
+	client   *http.Client
+	gateways map[string]bool
+	events   map[string]bool
+	queue    chan config.Message
+	stop     chan struct{}
+}
+
+func newWebhook(cfg config.Webhook, log *logrus.Entry) *webhook {
+	w := &webhook{
+		Webhook:  cfg,
+		log:      log,
+		client:   &http.Client{Timeout: webhookTimeout},
+		gateways: make(map[string]bool),
+		events:   make(map[string]bool),
+		queue:    make(chan config.Message, webhookQueue),
+		stop:     make(chan struct{}),
+	}
+	for _, gw := range cfg.Gateways {
+		w.gateways[gw] = true
+	}
+	for _, event := range cfg.Events {
+		w.events[event] = true
+	}
+	if w.MaxRetries <= 0 {
+		w.MaxRetries = defaultWebhookMaxRetries
+	}
+	go w.run()
+	return w
+}
+
+// newWebhooks returns the webhooks configured for account.
+func newWebhooks(cfg config.Config, account string, log *logrus.Entry) []*webhook {
+	var webhooks []*webhook
+	for _, wh := range accountConfig(cfg, account).Webhooks {
+		if wh.URL == "" {
+			log.Errorf("webhook without URL configured for %s", account)
+			continue
+		}
+		webhooks = append(webhooks, newWebhook(wh, log))
+	}
+	return webhooks
+}
+
+func (w *webhook) match(msg *config.Message) bool {
+	if len(w.gateways) > 0 && !w.gateways[msg.Gateway] {
+		return false
+	}
+	event := msg.Event
+	if event == "" {
+		event = webhookEventMessage
+	}
+	return len(w.events) == 0 || w.events[event]
+}
+
+// push queues msg when it matches the filters of the webhook.
+func (w *webhook) push(msg config.Message) {
+	if !w.match(&msg) {
+		return
+	}
+	select {
+	case w.queue <- msg:
+	default:
+		w.log.Warnf("webhook %s queue is full, dropping message %s", w.URL, msg.ID)
+	}
+}
+
+// close stops the delivery of the messages, queued messages are dropped.
+func (w *webhook) close() {
+	close(w.stop)
+}
+
+func (w *webhook) run() {
+	for {
+		select {
+		case msg := <-w.queue:
+			w.deliver(msg)
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// deliver posts msg, retrying with backoff until it succeeds, fails permanently
+// or MaxRetries is reached. All the attempts have the same delivery ID, so the
+// receiver can tell retries apart from new deliveries of the same message.
+func (w *webhook) deliver(msg config.Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		w.log.Errorf("failed to encode message '%v'", msg)
+		return
+	}
+	delivery := xid.New().String()
+	bo := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(delivery, data)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= w.MaxRetries {
+			w.log.Errorf("webhook %s failed for message %s: %s", w.URL, msg.ID, err)
+			return
+		}
+		delay := bo.Duration()
+		w.log.Warnf("webhook %s failed for message %s: %s, retrying in %s", w.URL, msg.ID, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// post posts data to the URL, it returns whether a failure is worth retrying.
+func (w *webhook) post(delivery string, data []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(data))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Matterbridge-Delivery", delivery)
+	req.Header.Set("X-Matterbridge-Timestamp", timestamp)
+	if w.Secret != "" {
+		req.Header.Set("X-Matterbridge-Signature", "sha256="+webhookSignature(w.Secret, timestamp, data))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("status %s", resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// webhookSignature returns the hex encoded HMAC-SHA256 of "timestamp.data".
+func webhookSignature(secret, timestamp string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhook(t *testing.T) {
+	webhookBackoff.Min = time.Millisecond
+	webhookBackoff.Max = time.Millisecond
+	defer func() { webhookBackoff.Min, webhookBackoff.Max = time.Second, time.Minute }()
+
+	type request struct {
+		delivery, timestamp, signature string
+		data                           []byte
+	}
+	// the handler only records the requests, they're checked by the test
+	requests := make(chan request, 10)
+	fail := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		requests <- request{
+			delivery:  r.Header.Get("X-Matterbridge-Delivery"),
+			timestamp: r.Header.Get("X-Matterbridge-Timestamp"),
+			signature: r.Header.Get("X-Matterbridge-Signature"),
+			data:      data,
+		}
+		// the first delivery fails and is retried
+		if fail > 0 {
+			fail--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	w := newWebhook(config.Webhook{
+		URL:      srv.URL,
+		Secret:   "secret",
+		Gateways: []string{"gw1"},
+		Events:   []string{"message", config.EventMsgDelete},
+	}, logrus.NewEntry(logger))
+	defer w.close()
+
+	w.push(config.Message{ID: "1", Text: "one", Gateway: "gw1"})
+	w.push(config.Message{ID: "2", Text: "other gateway", Gateway: "gw2"})
+	w.push(config.Message{ID: "3", Event: config.EventJoinLeave, Gateway: "gw1"})
+	w.push(config.Message{ID: "4", Event: config.EventMsgDelete, Gateway: "gw1"})
+	var deliveries []string
+	for _, id := range []string{"1", "1", "4"} {
+		select {
+		case req := <-requests:
+			assert.Equal(t, "sha256="+webhookSignature("secret", req.timestamp, req.data), req.signature)
+			var msg config.Message
+			require.NoError(t, json.Unmarshal(req.data, &msg))
+			assert.Equal(t, id, msg.ID)
+			deliveries = append(deliveries, req.delivery)
+		case <-time.After(time.Second):
+			t.Fatalf("message %s not delivered", id)
+		}
+	}
+	assert.Empty(t, requests)
+	// retries keep the delivery ID, every delivery gets a new one
+	assert.NotEmpty(t, deliveries[0])
+	assert.Equal(t, deliveries[0], deliveries[1])
+	assert.NotEqual(t, deliveries[0], deliveries[2])
+}
@@ -17,6 +17,45 @@ type Bridger interface {
 	Disconnect() error
 }
 
+// Reloader is implemented by bridges that want to act on a configuration reload
+// while they stay connected. The returned string is logged when it's not empty.
+type Reloader interface {
+	Reload(cfg *Config) (string, error)
+}
+
+// Capabler is implemented by bridges that declare their Capabilities, the gateway
+// uses DefaultCapabilities for the other bridges.
+type Capabler interface {
+	Capabilities() Capabilities
+}
+
+// Capabilities tells the gateway what a bridge can send. Messages that the bridge
+// can't send at all aren't sent to it, the others are changed to what it can send.
+type Capabilities struct {
+	Edits      bool // messages with the ID of a sent message change it, else they're sent as new messages
+	Deletes    bool // EventMsgDelete
+	Threads    bool // messages with a ParentID are replies, else they're sent with the ReplyFallbackFormat
+	Reactions  bool // EventReaction, else reactions are sent as text
+	Files      bool // the files in Extra["file"], else their URLs are added to the text
+	UserTyping bool // EventUserTyping
+	Avatars    bool // EventAvatarDownload
+	Notices    bool // EventNoticeIRC
+	Members    bool // EventGetChannelMembers
+	Formatting bool // markdown in the text, else the text is sent as plain text
+	MaxLength  int  // max length of the text in bytes, longer texts are clipped. 0 is no limit
+	// EditSuffix is added to edits sent as new messages when the EditSuffix setting is empty
+	EditSuffix string
+}
+
+// DefaultCapabilities are the capabilities of bridges that don't declare them.
+var DefaultCapabilities = Capabilities{
+	Edits:      true,
+	Deletes:    true,
+	Threads:    true,
+	Files:      true,
+	Formatting: true,
+}
+
 type Bridge struct {
 	Bridger
 	*sync.RWMutex
@@ -30,12 +69,16 @@ type Bridge struct {
 	Log            *logrus.Entry
 	Config         config.Config
 	General        *config.Protocol
+
+	state *stateTracker
 }
 
 type Config struct {
 	*Bridge
 
 	Remote chan config.Message
+	// Statuses returns the status of all the bridges of the router, can be nil.
+	Statuses func() []Status
 }
 
 // Factory is the factory function to create a bridge
@@ -57,6 +100,7 @@ func New(bridge *config.Bridge) *Bridge {
 		Protocol: protocol,
 		Account:  bridge.Account,
 		Joined:   make(map[string]bool),
+		state:    &stateTracker{status: Status{State: StateConnecting, Since: time.Now()}},
 	}
 }
 
@@ -64,6 +108,14 @@ func (b *Bridge) JoinChannels() error {
 	return b.joinChannels(b.Channels, b.Joined)
 }
 
+// Capabilities returns the capabilities of the bridge.
+func (b *Bridge) Capabilities() Capabilities {
+	if c, ok := b.Bridger.(Capabler); ok {
+		return c.Capabilities()
+	}
+	return DefaultCapabilities
+}
+
 // SetChannelMembers sets the newMembers to the bridge ChannelMembers
 func (b *Bridge) SetChannelMembers(newMembers *config.ChannelMembers) {
 	b.Lock()
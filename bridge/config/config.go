@@ -2,6 +2,7 @@ package config
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -9,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -28,26 +30,60 @@ const (
 	EventUserTyping        = "user_typing"
 	EventGetChannelMembers = "get_channel_members"
 	EventNoticeIRC         = "notice_irc"
+	EventBridgeState       = "bridge_state"
+	EventReaction          = "reaction"
 )
 
 const ParentIDNotFound = "msg-parent-not-found"
 
 type Message struct {
-	Text      string    `json:"text"`
-	Channel   string    `json:"channel"`
-	Username  string    `json:"username"`
-	UserID    string    `json:"userid"` // userid on the bridge
-	Avatar    string    `json:"avatar"`
-	Account   string    `json:"account"`
-	Event     string    `json:"event"`
-	Protocol  string    `json:"protocol"`
-	Gateway   string    `json:"gateway"`
-	ParentID  string    `json:"parent_id"`
-	Timestamp time.Time `json:"timestamp"`
-	ID        string    `json:"id"`
+	Text      string            `json:"text"`
+	Channel   string            `json:"channel"`
+	Username  string            `json:"username"`
+	UserID    string            `json:"userid"` // userid on the bridge
+	Avatar    string            `json:"avatar"`
+	Account   string            `json:"account"`
+	Event     string            `json:"event"`
+	Protocol  string            `json:"protocol"`
+	Gateway   string            `json:"gateway"`
+	ParentID  string            `json:"parent_id"`
+	Timestamp time.Time         `json:"timestamp"`
+	ID        string            `json:"id"`
+	Reaction  *Reaction         `json:"reaction,omitempty"` // set for EventReaction
+	Quote     *Quote            `json:"quote,omitempty"`    // the parent message of a reply, if it's known
+	Document  richtext.Document `json:"-"`                  // formatting of Text, see RichText
 	Extra     map[string][]interface{}
 }
 
+// Quote is an excerpt of a message that is replied to.
+type Quote struct {
+	Username string `json:"username"`
+	Text     string `json:"text"`              // the text of the message, shortened
+	InText   bool   `json:"in_text,omitempty"` // the bridge already quoted it in the text of the reply
+}
+
+// Reaction is an emoji reaction added to or removed from a message.
+type Reaction struct {
+	Emoji    string `json:"emoji"`     // unicode emoji, or :name: for custom emoji
+	TargetID string `json:"target_id"` // ID of the message reacted to
+	Removed  bool   `json:"removed"`
+}
+
+// RichText returns the formatting of the message: its Document if that's still what
+// the text says, or else the text parsed as markdown.
+func (m Message) RichText() richtext.Document {
+	if m.Document != nil && richtext.Markdown(m.Document) == m.Text {
+		return m.Document
+	}
+	return richtext.ParseMarkdown(m.Text)
+}
+
+// SetRichText sets the formatting of the message, and its text to the markdown of doc.
+func (m *Message) SetRichText(doc richtext.Document) {
+	m.Document = doc
+	m.Text = richtext.Markdown(doc)
+}
+
 func (m Message) ParentNotFound() bool {
 	return m.ParentID == ParentIDNotFound
 }
@@ -87,6 +123,8 @@ type ChannelMember struct {
 type ChannelMembers []ChannelMember
 
 type Protocol struct {
+	AdminBindAddress       string   // general, address to serve the admin API on
+	AdminToken             string   // general, bearer token of the admin API
 	AllowMention           []string // discord
 	AuthCode               string   // steam
 	BindAddress            string   // mattermost, slack // DEPRECATED
@@ -95,10 +133,11 @@ type Protocol struct {
 	ClientID               string   // msteams
 	ColorNicks             bool     // only irc for now
 	Debug                  bool     // general
+	DeadLetterFile         string   // general
 	DebugLevel             int      // only for irc now
 	DisableWebPagePreview  bool     // telegram
-	EditSuffix             string   // mattermost, slack, discord, telegram, gitter
-	EditDisable            bool     // mattermost, slack, discord, telegram, gitter
+	EditSuffix             string   // mattermost, slack, discord, telegram, gitter, bridges that can't edit
+	EditDisable            bool     // mattermost, slack, discord, telegram, gitter, bridges that can't edit
 	HTMLDisable            bool     // matrix
 	IconURL                string   // mattermost, slack
 	IgnoreFailureOnStart   bool     // general
@@ -109,6 +148,8 @@ type Protocol struct {
 	Label                  string   // all protocols
 	Login                  string   // mattermost, matrix
 	LogFile                string   // general
+	MaxTextLength          int      // api, max characters of the text of a message
+	MaxUsernameLength      int      // api, max characters of a username
 	MediaDownloadBlackList []string
 	MediaDownloadPath      string // Basically MediaServerUpload, but instead of uploading it, just write it to a file on the same server.
 	MediaDownloadSize      int    // all protocols
@@ -116,11 +157,15 @@ type Protocol struct {
 	MediaServerUpload      string
 	MediaConvertTgs        string     // telegram
 	MediaConvertWebPToPNG  bool       // telegram
+	MentionAliases         [][]string // discord, matrix, slack, telegram
 	MessageDelay           int        // IRC, time in millisecond to wait between messages
-	MessageFormat          string     // telegram
+	MessageFormat          string     // telegram, irc
 	MessageLength          int        // IRC, max length of a message allowed
 	MessageQueue           int        // IRC, size of message queue for flood control
 	MessageSplit           bool       // IRC, split long messages with newlines on MessageLength instead of clipping
+	MessageStorePath       string     // general, directory to persist the message ID mappings in
+	MessageStoreTTL        int        // general, hours to keep message ID mappings in the MessageStorePath
+	MetricsBindAddress     string     // general, address to serve prometheus metrics on
 	Muc                    string     // xmpp
 	MxID                   string     // matrix
 	Name                   string     // all protocols
@@ -140,11 +185,19 @@ type Protocol struct {
 	QuoteDisable           bool       // telegram
 	QuoteFormat            string     // telegram
 	QuoteLengthLimit       int        // telegram
+	RateLimit              int        // api, messages per minute per token
+	RateLimitBurst         int        // api, messages a token or address can send at once
+	RateLimitIP            int        // api, messages per minute per client address
 	RealName               string     // IRC
+	ReconnectMaxDelay      int        // all protocols, max seconds to wait between reconnect attempts
 	RejoinDelay            int        // IRC
 	ReplaceMessages        [][]string // all protocols
 	ReplaceNicks           [][]string // all protocols
+	ReplyFallbackFormat    string     // all protocols
 	RemoteNickFormat       string     // all protocols
+	RetryMaxAge            int        // all protocols, seconds to keep retrying a failed message
+	RetryMaxAttempts       int        // all protocols, attempts to send a message before giving up
+	RetryQueuePath         string     // general
 	RunCommands            []string   // IRC
 	Server                 string     // IRC,mattermost,XMPP,discord,matrix
 	SessionFile            string     // msteams,whatsapp
@@ -152,16 +205,19 @@ type Protocol struct {
 	ShowTopicChange        bool       // slack
 	ShowUserTyping         bool       // slack
 	ShowEmbeds             bool       // discord
+	ShutdownTimeout        int        // general, seconds to wait for queued messages and disconnects on shutdown
 	SkipTLSVerify          bool       // IRC, mattermost
 	SkipVersionCheck       bool       // mattermost
 	StripNick              bool       // all protocols
 	StripMarkdown          bool       // irc
+	SubscriberBuffer       int        // api, messages queued per stream/websocket client before it's disconnected
 	SyncTopic              bool       // slack
 	TengoModifyMessage     string     // general
 	Team                   string     // mattermost, keybase
 	TeamID                 string     // msteams
 	TenantID               string     // msteams
 	Token                  string     // gitter, slack, discord, api, matrix
+	Tokens                 []APIToken // api, named tokens with their own permissions
 	Topic                  string     // zulip
 	URL                    string     // mattermost, slack // DEPRECATED
 	UseAPI                 bool       // mattermost, slack
@@ -176,6 +232,25 @@ type Protocol struct {
 	VerboseJoinPart        bool       // IRC
 	WebhookBindAddress     string     // mattermost, slack
 	WebhookURL             string     // mattermost, slack
+	Webhooks               []Webhook  // api, URLs the messages are pushed to
+}
+
+// APIToken is a token of the api bridge, scoped to gateways and read or write access.
+type APIToken struct {
+	Name           string
+	Token          string
+	Gateways       []string // gateways the token can use, all if empty
+	Access         string   // "read", "write" or "readwrite", default "read"
+	UsernamePrefix string   // prepended to the usernames of the messages posted with the token
+}
+
+// Webhook is an URL the api bridge pushes its messages to.
+type Webhook struct {
+	URL        string
+	Secret     string   // key of the HMAC-SHA256 signature, no signature if empty
+	Gateways   []string // only push messages of these gateways
+	Events     []string // only push these events, "message" are messages without an event
+	MaxRetries int      // retries when the URL can't be reached or fails, default 5
 }
 
 type ChannelOptions struct {
@@ -204,6 +279,7 @@ type Tengo struct {
 	Message          string
 	RemoteNickFormat string
 	OutMessage       string
+	Route            string
 }
 
 type SameChannelGateway struct {
@@ -215,6 +291,7 @@ type SameChannelGateway struct {
 
 type BridgeValues struct {
 	API                map[string]Protocol
+	GRPC               map[string]Protocol
 	IRC                map[string]Protocol
 	Mattermost         map[string]Protocol
 	Matrix             map[string]Protocol
@@ -246,14 +323,18 @@ type Config interface {
 	GetString(key string) (string, bool)
 	GetStringSlice(key string) ([]string, bool)
 	GetStringSlice2D(key string) ([][]string, bool)
+	Reload() error
+	OnReload(fn func())
 }
 
 type config struct {
 	sync.RWMutex
 
-	logger *logrus.Entry
-	v      *viper.Viper
-	cv     *BridgeValues
+	logger   *logrus.Entry
+	v        *viper.Viper
+	cv       *BridgeValues
+	file     string
+	handlers []func()
 }
 
 // NewConfig instantiates a new configuration based on the specified configuration file path.
@@ -277,16 +358,24 @@ func NewConfig(rootLogger *logrus.Logger, cfgfile string) Config {
 			logger.Warn("Failed to open ", mycfg.cv.General.LogFile)
 		}
 	}
-	if mycfg.cv.General.MediaDownloadSize == 0 {
-		mycfg.cv.General.MediaDownloadSize = 1000000
-	}
+	mycfg.file = cfgfile
+	mycfg.cv.setDefaults()
 	viper.WatchConfig()
 	viper.OnConfigChange(func(e fsnotify.Event) {
 		logger.Println("Config file changed:", e.Name)
+		if err := mycfg.Reload(); err != nil {
+			logger.Errorf("Failed to reload the configuration: %s", err)
+		}
 	})
 	return mycfg
 }
 
+func (cv *BridgeValues) setDefaults() {
+	if cv.General.MediaDownloadSize == 0 {
+		cv.General.MediaDownloadSize = 1000000
+	}
+}
+
 // detectConfigType detects JSON and YAML formats, defaults to TOML.
 func detectConfigType(cfgfile string) string {
 	fileExt := filepath.Ext(cfgfile)
@@ -326,10 +415,51 @@ func newConfigFromString(logger *logrus.Entry, input []byte, cfgtype string) *co
 	}
 }
 
+// BridgeValues returns the values of the configuration. A reload replaces them,
+// the values that were returned before stay as they are.
 func (c *config) BridgeValues() *BridgeValues {
+	c.RLock()
+	defer c.RUnlock()
 	return c.cv
 }
 
+// Reload reads the configuration file again, replaces the BridgeValues and calls
+// the handlers registered with OnReload.
+func (c *config) Reload() error {
+	if c.file == "" {
+		return errors.New("configuration wasn't loaded from a file")
+	}
+
+	c.Lock()
+	if err := c.v.ReadInConfig(); err != nil {
+		c.Unlock()
+		return err
+	}
+	cv := &BridgeValues{}
+	if err := c.v.Unmarshal(cv); err != nil {
+		c.Unlock()
+		return err
+	}
+	cv.setDefaults()
+	// debug is set on the commandline
+	cv.General.Debug = c.cv.General.Debug
+	c.cv = cv
+	handlers := c.handlers
+	c.Unlock()
+
+	for _, fn := range handlers {
+		fn()
+	}
+	return nil
+}
+
+// OnReload registers fn to be called after every configuration reload.
+func (c *config) OnReload(fn func()) {
+	c.Lock()
+	defer c.Unlock()
+	c.handlers = append(c.handlers, fn)
+}
+
 func (c *config) Viper() *viper.Viper {
 	return c.v
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/discord/transmitter"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/bwmarrin/discordgo"
 	lru "github.com/hashicorp/golang-lru"
 )
@@ -86,6 +87,8 @@ func (b *Bdiscord) Connect() error {
 	b.c.AddHandler(b.messageUpdate)
 	b.c.AddHandler(b.messageDelete)
 	b.c.AddHandler(b.messageDeleteBulk)
+	b.c.AddHandler(b.messageReactionAdd)
+	b.c.AddHandler(b.messageReactionRemove)
 	b.c.AddHandler(b.memberAdd)
 	b.c.AddHandler(b.memberRemove)
 	b.c.AddHandler(b.memberUpdate)
@@ -248,9 +251,29 @@ func (b *Bdiscord) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// Capabilities returns what discord supports, texts are clipped to MessageLength.
+func (b *Bdiscord) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{
+		Edits:      true,
+		Deletes:    true,
+		Threads:    true,
+		Reactions:  true,
+		Files:      true,
+		UserTyping: true,
+		Members:    true,
+		Formatting: true,
+		MaxLength:  MessageLength,
+	}
+}
+
 func (b *Bdiscord) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
+	if msg.Event == config.EventGetChannelMembers {
+		b.Remote <- helper.ChannelMembersMessage(b.Account, b.getChannelMembers())
+		return "", nil
+	}
+
 	channelID := b.getChannelID(msg.Channel)
 	if channelID == "" {
 		return "", fmt.Errorf("Could not find channelID for %v", msg.Channel)
@@ -264,6 +287,14 @@ func (b *Bdiscord) Send(msg config.Message) (string, error) {
 		return "", nil
 	}
 
+	if msg.Event == config.EventReaction {
+		return "", b.sendReaction(&msg, channelID)
+	}
+
+	if msg.Event == "" || msg.Event == config.EventUserAction {
+		msg.Text = richtext.Discord(msg.RichText())
+	}
+
 	// Make a action /me of the message
 	if msg.Event == config.EventUserAction {
 		msg.Text = "_" + msg.Text + "_"
@@ -283,6 +314,19 @@ func (b *Bdiscord) Send(msg config.Message) (string, error) {
 	return b.handleEventBotUser(&msg, channelID)
 }
 
+// sendReaction adds or removes the reaction of the bot user.
+func (b *Bdiscord) sendReaction(msg *config.Message, channelID string) error {
+	emoji := msg.Reaction.Emoji
+	if strings.HasPrefix(emoji, ":") {
+		b.Log.Debugf("Can't react with custom emoji %s", emoji)
+		return nil
+	}
+	if msg.Reaction.Removed {
+		return b.c.MessageReactionRemove(channelID, msg.Reaction.TargetID, emoji, "@me")
+	}
+	return b.c.MessageReactionAdd(channelID, msg.Reaction.TargetID, emoji)
+}
+
 // handleEventDirect handles events via the bot user
 func (b *Bdiscord) handleEventBotUser(msg *config.Message, channelID string) (string, error) {
 	b.Log.Debugf("Broadcasting using token (API)")
@@ -2,6 +2,7 @@ package bdiscord
 
 import (
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/bwmarrin/discordgo"
 	"github.com/davecgh/go-spew/spew"
 )
@@ -40,6 +41,59 @@ func (b *Bdiscord) messageDeleteBulk(s *discordgo.Session, m *discordgo.MessageD
 	}
 }
 
+func (b *Bdiscord) messageReactionAdd(s *discordgo.Session, m *discordgo.MessageReactionAdd) { //nolint:unparam
+	b.handleReaction(m.MessageReaction, false)
+}
+
+func (b *Bdiscord) messageReactionRemove(s *discordgo.Session, m *discordgo.MessageReactionRemove) { //nolint:unparam
+	b.handleReaction(m.MessageReaction, true)
+}
+
+func (b *Bdiscord) handleReaction(m *discordgo.MessageReaction, removed bool) {
+	if m.GuildID != b.guildID {
+		b.Log.Debugf("Ignoring reaction because it originates from a different guild")
+		return
+	}
+	// not relay our own reactions
+	if m.UserID == b.userID {
+		return
+	}
+	b.membersMutex.RLock()
+	member, ok := b.userMemberMap[m.UserID]
+	b.membersMutex.RUnlock()
+	var user *discordgo.User
+	if ok {
+		user = member.User
+	} else {
+		var err error
+		user, err = b.c.User(m.UserID)
+		if err != nil {
+			b.Log.Errorf("Could not get user %s of reaction: %s", m.UserID, err)
+			return
+		}
+	}
+	emoji := m.Emoji.Name
+	if m.Emoji.ID != "" {
+		// custom emoji only exist on this server
+		emoji = ":" + m.Emoji.Name + ":"
+	}
+	rmsg := config.Message{
+		Account:  b.Account,
+		Event:    config.EventReaction,
+		UserID:   m.UserID,
+		Username: user.Username,
+		Channel:  b.getChannelName(m.ChannelID),
+		Reaction: &config.Reaction{Emoji: emoji, TargetID: m.MessageID, Removed: removed},
+	}
+	if !b.GetBool("UseUserName") {
+		rmsg.Username = b.getNick(user, m.GuildID)
+	}
+
+	b.Log.Debugf("<= Sending reaction from %s to gateway", b.Account)
+	b.Log.Debugf("<= Message is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
 func (b *Bdiscord) messageEvent(s *discordgo.Session, m *discordgo.Event) {
 	b.Log.Debug(spew.Sdump(m.Struct))
 }
@@ -111,10 +165,13 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 
 	if m.Content != "" {
 		m.Message.Content = b.replaceChannelMentions(m.Message.Content)
-		rmsg.Text, err = m.ContentWithMoreMentionsReplaced(b.c)
+		// keep the mentions of users, we parse them with the formatting
+		content := *m.Message
+		content.Mentions = nil
+		rmsg.Text, err = content.ContentWithMoreMentionsReplaced(b.c)
 		if err != nil {
 			b.Log.Errorf("ContentWithMoreMentionsReplaced failed: %s", err)
-			rmsg.Text = m.ContentWithMentionsReplaced()
+			rmsg.Text = content.ContentWithMentionsReplaced()
 		}
 	}
 
@@ -153,6 +210,9 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 	// Replace emotes
 	rmsg.Text = replaceEmotes(rmsg.Text)
 
+	// Convert formatting and mentions
+	rmsg.SetRichText(richtext.ParseDiscord(rmsg.Text, b.mentionedUsername(m.Mentions)))
+
 	// Add our parent id if it exists, and if it's not referring to a message in another channel
 	if ref := m.MessageReference; ref != nil && ref.ChannelID == m.ChannelID {
 		rmsg.ParentID = ref.MessageID
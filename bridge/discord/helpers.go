@@ -6,6 +6,7 @@ import (
 	"strings"
 	"unicode"
 
+	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/bwmarrin/discordgo"
 )
 
@@ -64,6 +65,39 @@ func (b *Bdiscord) getNick(user *discordgo.User, guildID string) string {
 	return user.Username
 }
 
+// mentionedUsername returns the name of the mentioned users by their ID.
+func (b *Bdiscord) mentionedUsername(mentions []*discordgo.User) func(id string) string {
+	return func(id string) string {
+		for _, user := range mentions {
+			if user.ID != id {
+				continue
+			}
+			if b.GetBool("UseUserName") {
+				return user.Username
+			}
+			return b.getNick(user, b.guildID)
+		}
+		return ""
+	}
+}
+
+// getChannelMembers returns the members of the guild, who are members of all its
+// channels.
+func (b *Bdiscord) getChannelMembers() config.ChannelMembers {
+	b.membersMutex.RLock()
+	defer b.membersMutex.RUnlock()
+
+	members := config.ChannelMembers{}
+	for id, member := range b.userMemberMap {
+		members = append(members, config.ChannelMember{
+			Username: member.User.Username,
+			Nick:     member.Nick,
+			UserID:   id,
+		})
+	}
+	return members
+}
+
 func (b *Bdiscord) getGuildMemberByNick(nick string) (*discordgo.Member, error) {
 	b.membersMutex.RLock()
 	defer b.membersMutex.RUnlock()
@@ -91,6 +91,11 @@ func (b *Bgitter) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// Capabilities returns what gitter supports, replies aren't threaded.
+func (b *Bgitter) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Edits: true, Deletes: true, Files: true, Formatting: true}
+}
+
 func (b *Bgitter) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 	roomID := b.getRoomID(msg.Channel)
@@ -230,6 +230,12 @@ func (b *Bharmony) typing(msg config.Message) (id string, err error) {
 	return "", err
 }
 
+// Capabilities returns what harmony supports, messages can't be edited and files
+// aren't uploaded.
+func (b *Bharmony) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Deletes: true, UserTyping: true, Formatting: true}
+}
+
 func (b *Bharmony) Send(msg config.Message) (id string, err error) {
 	switch msg.Event {
 	case "":
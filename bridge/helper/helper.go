@@ -14,9 +14,6 @@ import (
 	"golang.org/x/image/webp"
 
 	"github.com/42wim/matterbridge/bridge/config"
-	"github.com/gomarkdown/markdown"
-	"github.com/gomarkdown/markdown/html"
-	"github.com/gomarkdown/markdown/parser"
 	"github.com/sirupsen/logrus"
 )
 
@@ -135,6 +132,16 @@ func HandleExtra(msg *config.Message, general *config.Protocol) []config.Message
 	return rmsg
 }
 
+// ChannelMembersMessage returns the message answering an EventGetChannelMembers event
+// with the channel members of account.
+func ChannelMembersMessage(account string, members config.ChannelMembers) config.Message {
+	return config.Message{
+		Extra:   map[string][]interface{}{config.EventGetChannelMembers: {members}},
+		Event:   config.EventGetChannelMembers,
+		Account: account,
+	}
+}
+
 // GetAvatar constructs a URL for a given user-avatar if it is available in the cache.
 func GetAvatar(av map[string]string, userid string, general *config.Protocol) string {
 	if sha, ok := av[userid]; ok {
@@ -219,20 +226,6 @@ func ClipMessage(text string, length int, clippingMessage string) string {
 	return text
 }
 
-// ParseMarkdown takes in an input string as markdown and parses it to html
-func ParseMarkdown(input string) string {
-	extensions := parser.HardLineBreak | parser.NoIntraEmphasis | parser.FencedCode
-	markdownParser := parser.NewWithExtensions(extensions)
-	renderer := html.NewRenderer(html.RendererOptions{
-		Flags: 0,
-	})
-	parsedMarkdown := markdown.ToHTML([]byte(input), markdownParser, renderer)
-	res := string(parsedMarkdown)
-	res = strings.TrimPrefix(res, "<p>")
-	res = strings.TrimSuffix(res, "</p>\n")
-	return res
-}
-
 // ConvertWebPToPNG converts input data (which should be WebP format) to PNG format
 func ConvertWebPToPNG(data *[]byte) error {
 	r := bytes.NewReader(*data)
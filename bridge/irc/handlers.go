@@ -10,6 +10,7 @@ import (
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/lrstanley/girc"
 	"github.com/paulrosania/go-charset/charset"
 	"github.com/saintfish/chardet"
@@ -45,7 +46,7 @@ func (b *Birc) handleFiles(msg *config.Message) bool {
 		return false
 	}
 	for _, rmsg := range helper.HandleExtra(msg, b.General) {
-		b.Local <- rmsg
+		b.enqueue(rmsg)
 	}
 	if len(msg.Extra["file"]) == 0 {
 		return false
@@ -61,7 +62,7 @@ func (b *Birc) handleFiles(msg *config.Message) bool {
 				msg.Text = fi.Comment + " : " + fi.URL
 			}
 		}
-		b.Local <- config.Message{Text: msg.Text, Username: msg.Username, Channel: msg.Channel, Event: msg.Event}
+		b.enqueue(config.Message{Text: msg.Text, Username: msg.Username, Channel: msg.Channel, Event: msg.Event})
 	}
 	return true
 }
@@ -237,6 +238,11 @@ func (b *Birc) handlePrivMsg(client *girc.Client, event girc.Event) {
 		rmsg.Text = string(output)
 	}
 
+	// convert formatting control codes
+	if richtext.HasIRCCodes(rmsg.Text) {
+		rmsg.SetRichText(richtext.ParseIRC(rmsg.Text))
+	}
+
 	b.Log.Debugf("<= Sending message from %s on %s to gateway", event.Params[0], b.Account)
 	b.Remote <- rmsg
 }
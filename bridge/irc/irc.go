@@ -10,25 +10,36 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
+	"github.com/42wim/matterbridge/internal/metrics"
 	"github.com/lrstanley/girc"
-	stripmd "github.com/writeas/go-strip-markdown"
 
 	// We need to import the 'data' package as an implicit dependency.
 	// See: https://godoc.org/github.com/paulrosania/go-charset/charset
 	_ "github.com/paulrosania/go-charset/data"
 )
 
+// controlCodesFormat is the MessageFormat that sends formatting as IRC control codes.
+const controlCodesFormat = "controlcodes"
+
+// drainTimeout is how long a disconnect waits for the messages still waiting for flood control.
+const drainTimeout = 10 * time.Second
+
 type Birc struct {
 	i                                         *girc.Client
 	Nick                                      string
 	names                                     map[string][]string
 	connected                                 chan error
 	Local                                     chan config.Message // local queue for flood control
+	sent                                      chan struct{}       // closed when Local is drained after a disconnect
+	localMu                                   sync.Mutex          // guards writing to and closing Local
+	localClosed                               bool
 	FirstConnection, authDone                 bool
 	MessageDelay, MessageQueue, MessageLength int
 	channels                                  map[string]bool
@@ -77,7 +88,10 @@ func (b *Birc) Connect() error {
 		return errors.New("you can't enable SASL and TLSClientCertificate at the same time")
 	}
 
+	b.localMu.Lock()
 	b.Local = make(chan config.Message, b.MessageQueue+10)
+	b.localClosed = false
+	b.localMu.Unlock()
 	b.Log.Infof("Connecting %s", b.GetString("Server"))
 
 	i, err := b.getClient()
@@ -109,13 +123,25 @@ func (b *Birc) Connect() error {
 	if b.GetInt("DebugLevel") == 0 {
 		i.Handlers.Clear(girc.ALL_EVENTS)
 	}
+	b.sent = make(chan struct{})
 	go b.doSend()
 	return nil
 }
 
 func (b *Birc) Disconnect() error {
-	b.i.Close()
-	close(b.Local)
+	b.closeLocal()
+	if !b.i.IsConnected() {
+		b.i.Close()
+		return nil
+	}
+	// send the messages still waiting for flood control before quitting,
+	// girc closes the connection after sending the QUIT
+	select {
+	case <-b.sent:
+	case <-time.After(drainTimeout):
+		b.Log.Warnf("Dropping %d messages that weren't sent before disconnecting", len(b.Local))
+	}
+	b.i.Quit("")
 	return nil
 }
 
@@ -137,12 +163,13 @@ func (b *Birc) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
-func (b *Birc) Send(msg config.Message) (string, error) {
-	// ignore delete messages
-	if msg.Event == config.EventMsgDelete {
-		return "", nil
-	}
+// Capabilities returns what IRC supports. Messages can't be edited or deleted, the
+// markdown is turned into IRC formatting codes by Send.
+func (b *Birc) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Files: true, Notices: true, Formatting: true}
+}
 
+func (b *Birc) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
 	// we can be in between reconnects #385
@@ -156,6 +183,14 @@ func (b *Birc) Send(msg config.Message) (string, error) {
 		b.Command(&msg)
 	}
 
+	// convert markdown to the specified message format
+	switch {
+	case b.GetBool("StripMarkdown"):
+		msg.Text = richtext.Plain(msg.RichText())
+	case strings.EqualFold(b.GetString("MessageFormat"), controlCodesFormat):
+		msg.Text = richtext.IRC(msg.RichText())
+	}
+
 	// convert to specified charset
 	if err := b.handleCharset(&msg); err != nil {
 		return "", err
@@ -167,9 +202,6 @@ func (b *Birc) Send(msg config.Message) (string, error) {
 	}
 
 	var msgLines []string
-	if b.GetBool("StripMarkdown") {
-		msg.Text = stripmd.Strip(msg.Text)
-	}
 
 	if b.GetBool("MessageSplit") {
 		msgLines = helper.GetSubLines(msg.Text, b.MessageLength, b.GetString("MessageClipped"))
@@ -179,15 +211,45 @@ func (b *Birc) Send(msg config.Message) (string, error) {
 	for i := range msgLines {
 		if len(b.Local) >= b.MessageQueue {
 			b.Log.Debugf("flooding, dropping message (queue at %d)", len(b.Local))
+			metrics.MessagesDropped.Inc(b.Account, metrics.DropFlood)
 			return "", nil
 		}
 
 		msg.Text = msgLines[i]
-		b.Local <- msg
+		b.enqueue(msg)
 	}
 	return "", nil
 }
 
+// enqueue puts msg in the flood control queue. It returns false if the message is
+// dropped, because the queue is full or the bridge is disconnecting.
+func (b *Birc) enqueue(msg config.Message) bool {
+	b.localMu.Lock()
+	defer b.localMu.Unlock()
+	if b.localClosed {
+		b.Log.Debugf("disconnecting, dropping message")
+		return false
+	}
+	select {
+	case b.Local <- msg:
+		return true
+	default:
+		b.Log.Debugf("flooding, dropping message (queue at %d)", len(b.Local))
+		metrics.MessagesDropped.Inc(b.Account, metrics.DropFlood)
+		return false
+	}
+}
+
+// closeLocal closes the flood control queue, once no Send can write to it anymore.
+func (b *Birc) closeLocal() {
+	b.localMu.Lock()
+	defer b.localMu.Unlock()
+	if !b.localClosed {
+		b.localClosed = true
+		close(b.Local)
+	}
+}
+
 func (b *Birc) doConnect() {
 	for {
 		if err := b.i.Connect(); err != nil {
@@ -222,6 +284,7 @@ func sanitizeNick(nick string) string {
 }
 
 func (b *Birc) doSend() {
+	defer close(b.sent)
 	rate := time.Millisecond * time.Duration(b.MessageDelay)
 	throttle := time.NewTicker(rate)
 	for msg := range b.Local {
@@ -0,0 +1,40 @@
+package birc
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/lrstanley/girc"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisconnectWhileSending(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	b := &Birc{
+		Config:       &bridge.Config{Bridge: &bridge.Bridge{Log: logrus.NewEntry(logger), Account: "irc.test"}},
+		i:            girc.New(girc.Config{Server: "localhost", Nick: "test", User: "test"}),
+		MessageQueue: 30,
+		Local:        make(chan config.Message, 40),
+	}
+
+	// sending while disconnecting drops the messages instead of writing to a closed queue
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				b.enqueue(config.Message{Text: "hi", Channel: "#test"})
+			}
+		}()
+	}
+	assert.NoError(t, b.Disconnect())
+	wg.Wait()
+	assert.False(t, b.enqueue(config.Message{Text: "hi", Channel: "#test"}))
+	assert.NoError(t, b.Disconnect())
+}
@@ -57,6 +57,11 @@ func (b *Bkeybase) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// Capabilities returns what keybase supports.
+func (b *Bkeybase) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Files: true, Formatting: true}
+}
+
 // Send receives bridge messages and sends them to Keybase chat room
 func (b *Bkeybase) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
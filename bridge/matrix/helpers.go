@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/42wim/matterbridge/bridge/config"
 	matrix "github.com/matterbridge/gomatrix"
 )
 
@@ -40,6 +41,42 @@ func (b *Bmatrix) getRoomID(channel string) string {
 	return ""
 }
 
+// getChannelMembers returns the joined members of the rooms.
+func (b *Bmatrix) getChannelMembers() config.ChannelMembers {
+	b.RLock()
+	rooms := make(map[string]string, len(b.RoomMap))
+	for ID, name := range b.RoomMap {
+		rooms[ID] = name
+	}
+	b.RUnlock()
+
+	members := config.ChannelMembers{}
+	for roomID, channel := range rooms {
+		resp, err := b.mc.JoinedMembers(roomID)
+		if err != nil {
+			b.Log.Errorf("getting the members of %s failed: %s", channel, err)
+			continue
+		}
+		for mxid, member := range resp.Joined {
+			if mxid == b.UserID {
+				continue
+			}
+			nick := ""
+			if member.DisplayName != nil {
+				nick = *member.DisplayName
+			}
+			members = append(members, config.ChannelMember{
+				Username:    strings.SplitN(strings.TrimPrefix(mxid, "@"), ":", 2)[0],
+				Nick:        nick,
+				UserID:      mxid,
+				ChannelID:   roomID,
+				ChannelName: channel,
+			})
+		}
+	}
+	return members
+}
+
 // interface2Struct marshals and immediately unmarshals an interface.
 // Useful for converting map[string]interface{} to a struct.
 func interface2Struct(in interface{}, out interface{}) error {
@@ -12,9 +12,14 @@ import (
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
+	lru "github.com/hashicorp/golang-lru"
 	matrix "github.com/matterbridge/gomatrix"
 )
 
+// htmlFormat is the format of the HTML formatted body of messages.
+const htmlFormat = "org.matrix.custom.html"
+
 var (
 	htmlTag            = regexp.MustCompile("</.*?>")
 	htmlReplacementTag = regexp.MustCompile("<[^>]*>")
@@ -31,6 +36,12 @@ type Bmatrix struct {
 	NicknameMap map[string]NicknameCacheEntry
 	RoomMap     map[string]string
 	rateMutex   sync.RWMutex
+
+	// reactions are the received reactions by event ID, so that their redactions
+	// can be sent as removed reactions.
+	reactions *lru.Cache
+	// sentReactions are the event IDs of the sent reactions by room, message and emoji.
+	sentReactions *lru.Cache
 	sync.RWMutex
 	*bridge.Config
 }
@@ -80,10 +91,23 @@ type ReplyMessage struct {
 	matrix.TextMessage
 }
 
+// AnnotationRelation relates a reaction to the message it reacts to.
+type AnnotationRelation struct {
+	EventID string `json:"event_id"`
+	Type    string `json:"rel_type"`
+	Key     string `json:"key"`
+}
+
+type ReactionMessage struct {
+	RelatedTo AnnotationRelation `json:"m.relates_to"`
+}
+
 func New(cfg *bridge.Config) bridge.Bridger {
 	b := &Bmatrix{Config: cfg}
 	b.RoomMap = make(map[string]string)
 	b.NicknameMap = make(map[string]NicknameCacheEntry)
+	b.reactions, _ = lru.New(5000)
+	b.sentReactions, _ = lru.New(5000)
 	return b
 }
 
@@ -140,16 +164,38 @@ func (b *Bmatrix) JoinChannel(channel config.ChannelInfo) error {
 	})
 }
 
+// Capabilities returns what matrix supports.
+func (b *Bmatrix) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{
+		Edits:      true,
+		Deletes:    true,
+		Threads:    true,
+		Reactions:  true,
+		Files:      true,
+		Members:    true,
+		Formatting: true,
+	}
+}
+
 func (b *Bmatrix) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
+	if msg.Event == config.EventGetChannelMembers {
+		b.Remote <- helper.ChannelMembersMessage(b.Account, b.getChannelMembers())
+		return "", nil
+	}
+
 	channel := b.getRoomID(msg.Channel)
 	b.Log.Debugf("Channel %s maps to channel id %s", msg.Channel, channel)
 
+	if msg.Event == config.EventReaction {
+		return b.sendReaction(&msg, channel)
+	}
+
 	username := newMatrixUsername(msg.Username)
 
 	body := username.plain + msg.Text
-	formattedBody := username.formatted + helper.ParseMarkdown(msg.Text)
+	formattedBody := username.formatted + richtext.HTML(msg.RichText())
 
 	if b.GetBool("SpoofUsername") {
 		// https://spec.matrix.org/v1.3/client-server-api/#mroommember
@@ -169,7 +215,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 		_, err := b.mc.SendStateEvent(channel, "m.room.member", b.UserID, m)
 		if err == nil {
 			body = msg.Text
-			formattedBody = helper.ParseMarkdown(msg.Text)
+			formattedBody = richtext.HTML(msg.RichText())
 		}
 	}
 
@@ -179,7 +225,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 			MsgType:       "m.emote",
 			Body:          body,
 			FormattedBody: formattedBody,
-			Format:        "org.matrix.custom.html",
+			Format:        htmlFormat,
 		}
 
 		if b.GetBool("HTMLDisable") {
@@ -251,7 +297,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 			TextMessage: matrix.TextMessage{
 				Body:          body,
 				MsgType:       "m.text",
-				Format:        "org.matrix.custom.html",
+				Format:        htmlFormat,
 				FormattedBody: formattedBody,
 			},
 		}
@@ -293,7 +339,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 			MsgType:       "m.notice",
 			Body:          body,
 			FormattedBody: formattedBody,
-			Format:        "org.matrix.custom.html",
+			Format:        htmlFormat,
 		}
 
 		if b.GetBool("HTMLDisable") {
@@ -324,7 +370,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 				MsgType:       "m.text",
 				Body:          body,
 				FormattedBody: formattedBody,
-				Format:        "org.matrix.custom.html",
+				Format:        htmlFormat,
 			},
 		}
 
@@ -392,10 +438,60 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 	return resp.EventID, err
 }
 
+// sendReaction sends, or redacts, the reaction of msg.
+func (b *Bmatrix) sendReaction(msg *config.Message, channel string) (string, error) {
+	key := channel + " " + msg.Reaction.TargetID + " " + msg.Reaction.Emoji
+	if msg.Reaction.Removed {
+		eventID, ok := b.sentReactions.Get(key)
+		if !ok {
+			return "", nil
+		}
+		b.sentReactions.Remove(key)
+
+		return "", b.retry(func() error {
+			_, err := b.mc.RedactEvent(channel, eventID.(string), &matrix.ReqRedact{})
+
+			return err
+		})
+	}
+
+	// we can react only once with an emoji
+	if _, ok := b.sentReactions.Get(key); ok {
+		return "", nil
+	}
+
+	m := ReactionMessage{
+		RelatedTo: AnnotationRelation{
+			EventID: msg.Reaction.TargetID,
+			Type:    "m.annotation",
+			Key:     msg.Reaction.Emoji,
+		},
+	}
+
+	var (
+		resp *matrix.RespSendEvent
+		err  error
+	)
+
+	err = b.retry(func() error {
+		resp, err = b.mc.SendMessageEvent(channel, "m.reaction", m)
+
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	b.sentReactions.Add(key, resp.EventID)
+
+	return resp.EventID, nil
+}
+
 func (b *Bmatrix) handlematrix() {
 	syncer := b.mc.Syncer.(*matrix.DefaultSyncer)
 	syncer.OnEventType("m.room.redaction", b.handleEvent)
 	syncer.OnEventType("m.room.message", b.handleEvent)
+	syncer.OnEventType("m.reaction", b.handleEvent)
 	syncer.OnEventType("m.room.member", b.handleMemberChange)
 	go func() {
 		for {
@@ -434,6 +530,10 @@ func (b *Bmatrix) handleEdit(ev *matrix.Event, rmsg config.Message) bool {
 
 	rmsg.ID = relation.EventID
 	rmsg.Text = newContent.Body
+	rmsg.Document = nil
+	if newContent.Format == htmlFormat && newContent.FormattedBody != "" {
+		rmsg.SetRichText(richtext.ParseHTML(newContent.FormattedBody))
+	}
 	b.Remote <- rmsg
 
 	return true
@@ -453,7 +553,10 @@ func (b *Bmatrix) handleReply(ev *matrix.Event, rmsg config.Message) bool {
 
 	body := rmsg.Text
 
-	if !b.GetBool("keepquotedreply") {
+	if b.GetBool("keepquotedreply") {
+		// the formatted body leaves out the quoted reply
+		rmsg.Document = nil
+	} else if rmsg.Document == nil {
 		for strings.HasPrefix(body, "> ") {
 			lineIdx := strings.IndexRune(body, '\n')
 			if lineIdx == -1 {
@@ -471,6 +574,21 @@ func (b *Bmatrix) handleReply(ev *matrix.Event, rmsg config.Message) bool {
 	return true
 }
 
+func (b *Bmatrix) handleReaction(ev *matrix.Event, rmsg config.Message) {
+	var content ReactionMessage
+	if err := interface2Struct(ev.Content, &content); err != nil || content.RelatedTo.Type != "m.annotation" {
+		b.Log.Debugf("Ignoring reaction with content %#v", ev.Content)
+		return
+	}
+
+	rmsg.Event = config.EventReaction
+	rmsg.Reaction = &config.Reaction{Emoji: content.RelatedTo.Key, TargetID: content.RelatedTo.EventID}
+	b.reactions.Add(ev.ID, *rmsg.Reaction)
+
+	b.Log.Debugf("<= Sending reaction from %s on %s to gateway", ev.Sender, b.Account)
+	b.Remote <- rmsg
+}
+
 func (b *Bmatrix) handleMemberChange(ev *matrix.Event) {
 	// Update the displayname on join messages, according to https://matrix.org/docs/spec/client_server/r0.6.1#events-on-change-of-profile-information
 	if ev.Content["membership"] == "join" {
@@ -507,8 +625,24 @@ func (b *Bmatrix) handleEvent(ev *matrix.Event) {
 			rmsg.Username = re.ReplaceAllString(rmsg.Username, `$1`)
 		}
 
+		if ev.Type == "m.reaction" {
+			b.handleReaction(ev, rmsg)
+			return
+		}
+
 		// Delete event
 		if ev.Type == "m.room.redaction" {
+			// redacted reactions are removed reactions
+			if reaction, ok := b.reactions.Get(ev.Redacts); ok {
+				b.reactions.Remove(ev.Redacts)
+				removed := reaction.(config.Reaction)
+				removed.Removed = true
+				rmsg.Event = config.EventReaction
+				rmsg.ID = ev.Redacts
+				rmsg.Reaction = &removed
+				b.Remote <- rmsg
+				return
+			}
 			rmsg.Event = config.EventMsgDelete
 			rmsg.ID = ev.Redacts
 			rmsg.Text = config.EventMsgDelete
@@ -523,6 +657,12 @@ func (b *Bmatrix) handleEvent(ev *matrix.Event) {
 			return
 		}
 
+		if format, _ := ev.Content["format"].(string); format == htmlFormat {
+			if formattedBody, ok := ev.Content["formatted_body"].(string); ok {
+				rmsg.SetRichText(richtext.ParseHTML(formattedBody))
+			}
+		}
+
 		// Do we have a /me action
 		if ev.Content["msgtype"].(string) == "m.emote" {
 			rmsg.Event = config.EventUserAction
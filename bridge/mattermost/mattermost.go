@@ -142,6 +142,18 @@ func (b *Bmattermost) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// Capabilities returns what mattermost supports.
+func (b *Bmattermost) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{
+		Edits:      true,
+		Deletes:    true,
+		Threads:    true,
+		Files:      true,
+		Avatars:    true,
+		Formatting: true,
+	}
+}
+
 func (b *Bmattermost) Send(msg config.Message) (string, error) {
 	if b.Account == mattermostPlugin {
 		return "", nil
@@ -5,14 +5,13 @@ import (
 	"fmt"
 	"os"
 	"regexp"
-	"strings"
 	"time"
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/davecgh/go-spew/spew"
 
-	"github.com/mattn/godown"
 	msgraph "github.com/yaegashi/msgraph.go/beta"
 	"github.com/yaegashi/msgraph.go/msauth"
 
@@ -86,6 +85,11 @@ func (b *Bmsteams) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// Capabilities returns what msteams supports, only replies are sent to it.
+func (b *Bmsteams) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Threads: true, Formatting: true}
+}
+
 func (b *Bmsteams) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 	if msg.ParentValid() {
@@ -185,10 +189,9 @@ func (b *Bmsteams) poll(channelName string) error {
 				msgmap[*msg.ID] = *msg.LastModifiedDateTime
 			}
 			b.Log.Debugf("<= Sending message from %s on %s to gateway", *msg.From.User.DisplayName, b.Account)
-			text := b.convertToMD(*msg.Body.Content)
 			rmsg := config.Message{
 				Username: *msg.From.User.DisplayName,
-				Text:     text,
+				Text:     *msg.Body.Content,
 				Channel:  channelName,
 				Account:  b.Account,
 				Avatar:   "",
@@ -196,6 +199,9 @@ func (b *Bmsteams) poll(channelName string) error {
 				ID:       *msg.ID,
 				Extra:    make(map[string][]interface{}),
 			}
+			if msg.Body.ContentType != nil && *msg.Body.ContentType == msgraph.BodyTypeVHTML {
+				rmsg.SetRichText(richtext.ParseHTML(rmsg.Text))
+			}
 
 			b.handleAttachments(&rmsg, msg)
 			b.Log.Debugf("<= Message is %#v", rmsg)
@@ -214,16 +220,3 @@ func (b *Bmsteams) setBotID() error {
 	b.botID = *r.ID
 	return nil
 }
-
-func (b *Bmsteams) convertToMD(text string) string {
-	if !strings.Contains(text, "<div>") {
-		return text
-	}
-	var sb strings.Builder
-	err := godown.Convert(&sb, strings.NewReader(text), nil)
-	if err != nil {
-		b.Log.Errorf("Couldn't convert message to markdown %s", text)
-		return text
-	}
-	return sb.String()
-}
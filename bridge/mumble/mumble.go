@@ -17,7 +17,7 @@ import (
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
-	stripmd "github.com/writeas/go-strip-markdown"
+	"github.com/42wim/matterbridge/bridge/richtext"
 
 	// We need to import the 'data' package as an implicit dependency.
 	// See: https://godoc.org/github.com/paulrosania/go-charset/charset
@@ -90,6 +90,12 @@ func (b *Bmumble) JoinChannel(channel config.ChannelInfo) error {
 	return b.doJoin(b.client, channelID)
 }
 
+// Capabilities returns what mumble supports. The max length of messages is set by
+// the server, Send clips them.
+func (b *Bmumble) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Files: true, Formatting: true}
+}
+
 func (b *Bmumble) Send(msg config.Message) (string, error) {
 	// Only process text messages
 	b.Log.Debugf("=> Received local message %#v", msg)
@@ -242,9 +248,9 @@ func (b *Bmumble) processMessage(msg *config.Message) {
 	}
 	// If HTML is allowed, convert markdown into HTML, otherwise strip markdown
 	if allowHTML {
-		msg.Text = helper.ParseMarkdown(msg.Text)
+		msg.Text = richtext.HTML(msg.RichText())
 	} else {
-		msg.Text = stripmd.Strip(msg.Text)
+		msg.Text = richtext.Plain(msg.RichText())
 	}
 
 	// If there is a maximum message length, split and truncate the lines
@@ -106,6 +106,11 @@ func (b *Btalk) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// Capabilities returns what nextcloud talk supports.
+func (b *Btalk) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Deletes: true, Files: true, Formatting: true}
+}
+
 func (b *Btalk) Send(msg config.Message) (string, error) {
 	r := b.getRoom(msg.Channel)
 	if r == nil {
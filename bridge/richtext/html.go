@@ -0,0 +1,268 @@
+package richtext
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const matrixToPrefix = "https://matrix.to/#/"
+
+// ParseHTML parses the HTML of Matrix and MS Teams messages. Matrix pills become
+// mentions, the reply fallback in <mx-reply> is skipped.
+func ParseHTML(text string) Document {
+	context := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	fragment, err := html.ParseFragment(strings.NewReader(text), context)
+	if err != nil {
+		return Document{{Kind: Text, Text: text}}
+	}
+	var nodes []Node
+	for _, n := range fragment {
+		nodes = appendHTML(nodes, n)
+	}
+	return separateBlocks(nodes)
+}
+
+func appendHTMLChildren(nodes []Node, n *html.Node) []Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		nodes = appendHTML(nodes, c)
+	}
+	return nodes
+}
+
+func appendHTML(nodes []Node, n *html.Node) []Node {
+	if n.Type == html.TextNode {
+		// newlines in HTML are spaces, between blocks they're nothing
+		if strings.TrimSpace(n.Data) == "" && strings.Contains(n.Data, "\n") {
+			return nodes
+		}
+		return appendNode(nodes, Node{Kind: Text, Text: strings.ReplaceAll(n.Data, "\n", " ")})
+	}
+	if n.Type != html.ElementNode {
+		return appendHTMLChildren(nodes, n)
+	}
+	switch n.DataAtom {
+	case atom.Br:
+		return appendNode(nodes, Node{Kind: Text, Text: "\n"})
+	case atom.P, atom.Div, atom.Ul, atom.Ol, atom.Hr:
+		nodes = appendHTMLChildren(appendNewline(nodes), n)
+		return appendNewline(nodes)
+	case atom.Li:
+		nodes = appendNode(appendNewline(nodes), Node{Kind: Text, Text: listMarker(n)})
+		return appendNewline(appendHTMLChildren(nodes, n))
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		nodes = appendNode(appendNewline(nodes), Node{Kind: Bold, Children: appendHTMLChildren(nil, n)})
+		return appendNewline(nodes)
+	case atom.B, atom.Strong:
+		return appendFormatted(nodes, n, Node{Kind: Bold})
+	case atom.I, atom.Em:
+		return appendFormatted(nodes, n, Node{Kind: Italic})
+	case atom.S, atom.Del, atom.Strike:
+		return appendFormatted(nodes, n, Node{Kind: Strike})
+	case atom.Blockquote:
+		return appendNode(nodes, Node{Kind: Quote, Children: trimNewlines(appendHTMLChildren(nil, n))})
+	case atom.Code:
+		return appendNode(nodes, Node{Kind: Code, Text: textContent(n)})
+	case atom.Pre:
+		pre := Node{Kind: Pre, Text: strings.TrimSuffix(textContent(n), "\n")}
+		if code := n.FirstChild; code != nil && code.DataAtom == atom.Code {
+			for _, class := range strings.Fields(attr(code, "class")) {
+				if strings.HasPrefix(class, "language-") {
+					pre.Lang = strings.TrimPrefix(class, "language-")
+				}
+			}
+		}
+		return append(nodes, pre)
+	case atom.A:
+		href := attr(n, "href")
+		if strings.HasPrefix(href, matrixToPrefix+"@") || strings.HasPrefix(href, matrixToPrefix+"%40") {
+			id, err := url.PathUnescape(strings.TrimPrefix(href, matrixToPrefix))
+			if err == nil {
+				id = strings.SplitN(id, "?", 2)[0]
+				return appendNode(nodes, Node{Kind: Mention, UserID: id, Text: strings.TrimPrefix(textContent(n), "@")})
+			}
+		}
+		if href == "" {
+			return appendHTMLChildren(nodes, n)
+		}
+		return appendFormatted(nodes, n, Node{Kind: Link, URL: href})
+	case atom.Span:
+		if _, ok := attrOk(n, "data-mx-spoiler"); ok {
+			return appendFormatted(nodes, n, Node{Kind: Spoiler})
+		}
+	case atom.Img:
+		return appendNode(nodes, Node{Kind: Text, Text: attr(n, "alt")})
+	case atom.Script, atom.Style, atom.Head:
+		return nodes
+	}
+	switch n.Data {
+	case "mx-reply":
+		return nodes
+	case "tg-spoiler":
+		return appendFormatted(nodes, n, Node{Kind: Spoiler})
+	}
+	return appendHTMLChildren(nodes, n)
+}
+
+func appendFormatted(nodes []Node, n *html.Node, format Node) []Node {
+	format.Children = appendHTMLChildren(nil, n)
+	if len(format.Children) == 0 {
+		return nodes
+	}
+	return appendNode(nodes, format)
+}
+
+// listMarker returns the marker of the list item n.
+func listMarker(n *html.Node) string {
+	if n.Parent == nil || n.Parent.DataAtom != atom.Ol {
+		return "- "
+	}
+	i := 1
+	for s := n.PrevSibling; s != nil; s = s.PrevSibling {
+		if s.DataAtom == atom.Li {
+			i++
+		}
+	}
+	return strconv.Itoa(i) + ". "
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.DataAtom == atom.Br {
+			sb.WriteString("\n")
+			continue
+		}
+		sb.WriteString(textContent(c))
+	}
+	return sb.String()
+}
+
+func attr(n *html.Node, key string) string {
+	val, _ := attrOk(n, key)
+	return val
+}
+
+func attrOk(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// htmlFlavor is the HTML of a protocol.
+type htmlFlavor struct {
+	bold, italic, strike string
+	newline              string
+	spoiler              string
+	spoilerAttr          string
+	escape               func(string) string
+	mention              func(Node) string // returns the href of a mention, or ""
+}
+
+var matrixHTML = htmlFlavor{
+	bold:        "strong",
+	italic:      "em",
+	strike:      "del",
+	newline:     "<br>",
+	spoiler:     "span",
+	spoilerAttr: " data-mx-spoiler",
+	escape:      html.EscapeString,
+	mention: func(n Node) string {
+		if strings.HasPrefix(n.UserID, "@") && strings.Contains(n.UserID, ":") {
+			return matrixToPrefix + n.UserID
+		}
+		return ""
+	},
+}
+
+// telegramEscaper escapes the only characters the HTML of Telegram allows to be
+// escaped.
+// @see https://core.telegram.org/bots/api#html-style
+var telegramEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+var telegramHTML = htmlFlavor{
+	bold:    "b",
+	italic:  "i",
+	strike:  "s",
+	newline: "\n",
+	spoiler: "tg-spoiler",
+	escape:  telegramEscaper.Replace,
+	mention: telegramMention,
+}
+
+// telegramMention returns the link to the telegram user of n, if it's one.
+func telegramMention(n Node) string {
+	if _, err := strconv.ParseInt(n.UserID, 10, 64); err == nil {
+		return "tg://user?id=" + n.UserID
+	}
+	return ""
+}
+
+// HTML renders doc as the HTML of Matrix messages, which Mumble also understands.
+func HTML(doc Document) string {
+	var sb strings.Builder
+	matrixHTML.write(&sb, doc)
+	return sb.String()
+}
+
+// TelegramHTML renders doc as the HTML of Telegram messages.
+func TelegramHTML(doc Document) string {
+	var sb strings.Builder
+	telegramHTML.write(&sb, doc)
+	return sb.String()
+}
+
+func (f *htmlFlavor) write(sb *strings.Builder, nodes []Node) {
+	for i, n := range nodes {
+		switch n.Kind {
+		case Text:
+			text := n.Text
+			if i > 0 && (nodes[i-1].Kind == Quote || nodes[i-1].Kind == Pre) {
+				// the block already ends the line
+				text = strings.TrimPrefix(text, "\n")
+			}
+			sb.WriteString(strings.ReplaceAll(f.escape(text), "\n", f.newline))
+		case Bold:
+			f.writeElement(sb, f.bold, "", n.Children)
+		case Italic:
+			f.writeElement(sb, f.italic, "", n.Children)
+		case Strike:
+			f.writeElement(sb, f.strike, "", n.Children)
+		case Spoiler:
+			f.writeElement(sb, f.spoiler, f.spoilerAttr, n.Children)
+		case Link:
+			f.writeElement(sb, "a", ` href="`+f.escape(n.URL)+`"`, n.Children)
+		case Quote:
+			f.writeElement(sb, "blockquote", "", n.Children)
+		case Code:
+			sb.WriteString("<code>" + f.escape(n.Text) + "</code>")
+		case Pre:
+			class := ""
+			if n.Lang != "" {
+				class = ` class="language-` + f.escape(n.Lang) + `"`
+			}
+			sb.WriteString("<pre><code" + class + ">" + f.escape(n.Text) + "</code></pre>")
+		case Mention:
+			if href := f.mention(n); href != "" {
+				sb.WriteString(`<a href="` + f.escape(href) + `">` + f.escape(n.Text) + "</a>")
+				continue
+			}
+			sb.WriteString(f.escape("@" + n.Text))
+		}
+	}
+}
+
+func (f *htmlFlavor) writeElement(sb *strings.Builder, tag, attrs string, children []Node) {
+	sb.WriteString("<" + tag + attrs + ">")
+	f.write(sb, children)
+	sb.WriteString("</" + tag + ">")
+}
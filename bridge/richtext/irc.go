@@ -0,0 +1,222 @@
+package richtext
+
+import (
+	"strings"
+)
+
+// IRC formatting control codes.
+// @see https://modern.ircdocs.horse/formatting.html
+const (
+	ircBold      = '\x02'
+	ircColor     = '\x03'
+	ircHexColor  = '\x04'
+	ircReset     = '\x0f'
+	ircMonospace = '\x11'
+	ircReverse   = '\x16'
+	ircItalic    = '\x1d'
+	ircStrike    = '\x1e'
+	ircUnderline = '\x1f'
+)
+
+// ircStyle is the formatting of IRC text at a point.
+type ircStyle struct {
+	bold, italic, strike, monospace, spoiler bool
+}
+
+// ParseIRC parses IRC formatting control codes. Colors are dropped, except the same
+// foreground and background color, which is a spoiler. The text in between is parsed
+// as markdown, which IRC users write by hand.
+func ParseIRC(text string) Document {
+	if !HasIRCCodes(text) {
+		return ParseMarkdown(text)
+	}
+	var (
+		doc   []Node
+		style ircStyle
+		start int
+	)
+	flush := func(end int) {
+		if end > start {
+			for _, n := range style.format(text[start:end]) {
+				doc = appendNode(doc, n)
+			}
+		}
+	}
+	for i := 0; i < len(text); {
+		if !isIRCCode(rune(text[i])) {
+			i++
+			continue
+		}
+		flush(i)
+		code := text[i]
+		i++
+		switch code {
+		case ircBold:
+			style.bold = !style.bold
+		case ircItalic:
+			style.italic = !style.italic
+		case ircStrike:
+			style.strike = !style.strike
+		case ircMonospace:
+			style.monospace = !style.monospace
+		case ircReset:
+			style = ircStyle{}
+		case ircColor:
+			var fg, bg string
+			fg, i = ircDigits(text, i)
+			if fg != "" && strings.HasPrefix(text[i:], ",") {
+				if bg, i = ircDigits(text, i+1); bg == "" {
+					// the comma isn't part of the color
+					i--
+				}
+			}
+			style.spoiler = fg != "" && bg != "" && trimZero(fg) == trimZero(bg)
+		case ircHexColor:
+			i = skipHex(text, i)
+			if strings.HasPrefix(text[i:], ",") && skipHex(text, i+1) > i+1 {
+				i = skipHex(text, i+1)
+			}
+		}
+		start = i
+	}
+	flush(len(text))
+	return doc
+}
+
+// HasIRCCodes returns true if text has IRC formatting control codes.
+func HasIRCCodes(text string) bool {
+	return strings.IndexFunc(text, isIRCCode) >= 0
+}
+
+func isIRCCode(r rune) bool {
+	switch r {
+	case ircBold, ircColor, ircHexColor, ircReset, ircMonospace, ircReverse, ircItalic, ircStrike, ircUnderline:
+		return true
+	}
+	return false
+}
+
+// ircDigits returns the up to 2 digits of a color at text[i], and the index after.
+func ircDigits(text string, i int) (string, int) {
+	start := i
+	for i < len(text) && i-start < 2 && text[i] >= '0' && text[i] <= '9' {
+		i++
+	}
+	return text[start:i], i
+}
+
+func skipHex(text string, i int) int {
+	if i+6 > len(text) {
+		return i
+	}
+	for _, c := range text[i : i+6] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return i
+		}
+	}
+	return i + 6
+}
+
+func trimZero(s string) string {
+	if len(s) == 2 && s[0] == '0' {
+		return s[1:]
+	}
+	return s
+}
+
+// format returns the nodes of text in style s.
+func (s ircStyle) format(text string) []Node {
+	nodes := []Node{{Kind: Code, Text: text}}
+	if !s.monospace {
+		nodes = (&parser{}).parseInline(text)
+	}
+	wraps := []struct {
+		on   bool
+		kind Kind
+	}{{s.strike, Strike}, {s.italic, Italic}, {s.spoiler, Spoiler}, {s.bold, Bold}}
+	for _, w := range wraps {
+		if w.on {
+			nodes = []Node{{Kind: w.kind, Children: nodes}}
+		}
+	}
+	return nodes
+}
+
+// IRC renders doc with IRC formatting control codes.
+func IRC(doc Document) string {
+	var sb strings.Builder
+	writePlain(&sb, doc, true)
+	return sb.String()
+}
+
+// Plain renders doc as plain text.
+func Plain(doc Document) string {
+	var sb strings.Builder
+	writePlain(&sb, doc, false)
+	return sb.String()
+}
+
+func writePlain(sb *strings.Builder, nodes []Node, codes bool) {
+	wrap := func(open, close string, children []Node) {
+		var inner strings.Builder
+		writePlain(&inner, children, codes)
+		if !codes {
+			sb.WriteString(inner.String())
+			return
+		}
+		sb.WriteString(wrapLines(inner.String(), open, close))
+	}
+	for _, n := range nodes {
+		switch n.Kind {
+		case Text:
+			sb.WriteString(n.Text)
+		case Bold:
+			wrap(string(ircBold), string(ircBold), n.Children)
+		case Italic:
+			wrap(string(ircItalic), string(ircItalic), n.Children)
+		case Strike:
+			wrap(string(ircStrike), string(ircStrike), n.Children)
+		case Spoiler:
+			if !codes {
+				sb.WriteString("||")
+				writePlain(sb, n.Children, codes)
+				sb.WriteString("||")
+				continue
+			}
+			// black on black. The bold toggles after the color reset keep digits
+			// after the spoiler from being read as a color.
+			wrap("\x0301,01", "\x03\x02\x02", n.Children)
+		case Code, Pre:
+			if !codes {
+				sb.WriteString(n.Text)
+				continue
+			}
+			sb.WriteString(wrapLines(n.Text, string(ircMonospace), string(ircMonospace)))
+		case Link:
+			if isBareLink(n) {
+				sb.WriteString(n.URL)
+				continue
+			}
+			writePlain(sb, n.Children, codes)
+			sb.WriteString(" (" + n.URL + ")")
+		case Mention:
+			sb.WriteString("@" + n.Text)
+		case Quote:
+			var inner strings.Builder
+			writePlain(&inner, n.Children, codes)
+			sb.WriteString(prefixLines(inner.String(), "> "))
+		}
+	}
+}
+
+// wrapLines wraps every line of s in open and close, because IRC formatting ends
+// with the line.
+func wrapLines(s, open, close string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = open + line + close
+		}
+	}
+	return strings.Join(lines, "\n")
+}
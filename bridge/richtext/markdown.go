@@ -0,0 +1,656 @@
+package richtext
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// parser parses markdown, or the mrkdwn of Slack.
+type parser struct {
+	slack    bool
+	discord  bool
+	username func(id string) string
+}
+
+// asciiPunct are the characters that can be escaped with a backslash in markdown.
+const asciiPunct = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// ParseMarkdown parses the markdown of Message.Text, which is also Discord's: **bold**,
+// _italic_ or *italic*, ~~strike~~, `code`, ```code blocks``` or ~~~code blocks~~~,
+// [links](url), > quotes and ||spoilers||. @name is a mention of a user without an ID
+// and a backslash escapes punctuation.
+func ParseMarkdown(text string) Document {
+	p := &parser{}
+	return p.parse(text)
+}
+
+// ParseDiscord parses the markdown of Discord messages, with <@id> mentions of users.
+// username returns the name of a user ID, mentions of unknown users are kept as is.
+func ParseDiscord(text string, username func(id string) string) Document {
+	p := &parser{discord: true, username: username}
+	return p.parse(text)
+}
+
+// ParseSlack parses the mrkdwn of Slack messages. username returns the name of a user
+// ID, for mentions that don't have one.
+func ParseSlack(text string, username func(id string) string) Document {
+	p := &parser{slack: true, username: username}
+	return p.parse(text)
+}
+
+// parse splits text into quotes and other lines, outside of code blocks.
+func (p *parser) parse(text string) Document {
+	var (
+		doc     []Node
+		block   []string
+		quote   bool
+		fence   string // the fence of the open code block
+		flushed bool
+	)
+	flush := func() {
+		if len(block) == 0 {
+			return
+		}
+		if flushed {
+			doc = appendNode(doc, Node{Kind: Text, Text: "\n"})
+		}
+		flushed = true
+		nodes := p.parseInline(strings.Join(block, "\n"))
+		if quote {
+			doc = append(doc, Node{Kind: Quote, Children: nodes})
+		} else {
+			for _, n := range nodes {
+				doc = appendNode(doc, n)
+			}
+		}
+		block = nil
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		content, isQuote := p.quoteLine(line)
+		switch {
+		case fence != "" && !quote:
+			content, isQuote = line, false
+		case fence != "" && !isQuote:
+			// a code block in a quote ends with the quote
+			fence = ""
+		}
+		if isQuote != quote {
+			flush()
+			quote = isQuote
+		}
+		fence = p.fenceAfter(fence, content, lines[i+1:], quote)
+		block = append(block, content)
+	}
+	flush()
+	return doc
+}
+
+// fenceAfter returns the fence of the code block that's open after line, fence is the
+// one that's open before it. A code block is only opened when it's closed in the next
+// lines, in the quote if it's quoted.
+func (p *parser) fenceAfter(fence, line string, next []string, quote bool) string {
+	if fence != "" {
+		if strings.Count(line, fence)%2 == 1 {
+			return ""
+		}
+		return fence
+	}
+	fences := []string{"```", "~~~"}
+	if p.slack {
+		fences = fences[:1]
+	}
+	for _, f := range fences {
+		if strings.Count(line, f)%2 == 0 {
+			continue
+		}
+		for _, l := range next {
+			if quote {
+				var isQuote bool
+				if l, isQuote = p.quoteLine(l); !isQuote {
+					break
+				}
+			}
+			if strings.Contains(l, f) {
+				return f
+			}
+		}
+	}
+	return ""
+}
+
+// quoteLine returns the content of line without its quote marker, if it's quoted.
+func (p *parser) quoteLine(line string) (string, bool) {
+	markers := []string{"> ", ">"}
+	if p.slack {
+		markers = []string{"&gt; ", "&gt;", "> "}
+	}
+	for _, m := range markers {
+		if strings.HasPrefix(line, m) && (strings.HasSuffix(m, " ") || len(line) == len(m)) {
+			return line[len(m):], true
+		}
+	}
+	return line, false
+}
+
+func (p *parser) parseInline(s string) []Node {
+	var nodes []Node
+	start := 0
+	for i := 0; i < len(s); {
+		n, end, ok := p.parseSpan(s, i)
+		if !ok {
+			switch {
+			case !p.slack && s[i] == '\\' && i+1 < len(s) && strings.IndexByte(asciiPunct, s[i+1]) >= 0:
+				nodes = appendNode(nodes, p.text(s[start:i]))
+				start = i + 1
+				i += 2
+			case s[i] == '`':
+				// backticks without a closing run are text
+				i += backticks(s[i:])
+			default:
+				i++
+			}
+			continue
+		}
+		nodes = appendNode(nodes, p.text(s[start:i]))
+		nodes = appendNode(nodes, n)
+		i, start = end, end
+	}
+	return appendNode(nodes, p.text(s[start:]))
+}
+
+// text returns a text node of s, which Slack has HTML escaped.
+func (p *parser) text(s string) Node {
+	if p.slack {
+		s = html.UnescapeString(s)
+	}
+	return Node{Kind: Text, Text: s}
+}
+
+// parseSpan parses the formatting starting at s[i], and returns it with the index
+// after it.
+func (p *parser) parseSpan(s string, i int) (Node, int, bool) {
+	rest := s[i:]
+	switch {
+	case strings.HasPrefix(rest, "```") && backticks(rest) == 3 || !p.slack && strings.HasPrefix(rest, "~~~"):
+		end := strings.Index(rest[3:], rest[:3])
+		if end < 0 {
+			return Node{}, 0, false
+		}
+		code, lang := rest[3:3+end], ""
+		if nl := strings.IndexByte(code, '\n'); nl >= 0 && isLang(code[:nl]) {
+			lang, code = code[:nl], code[nl+1:]
+		}
+		code = strings.TrimSuffix(code, "\n")
+		if p.slack {
+			lang, code = "", html.UnescapeString(code)
+		}
+		return Node{Kind: Pre, Text: code, Lang: lang}, i + end + 6, true
+	case rest[0] == '`':
+		return p.parseCode(s, i)
+	case p.slack && rest[0] == '<':
+		return p.parseSlackTag(s, i)
+	case p.discord && strings.HasPrefix(rest, "<@"):
+		return p.parseDiscordMention(s, i)
+	case p.slack:
+		kinds := map[byte]Kind{'*': Bold, '_': Italic, '~': Strike}
+		if kind, ok := kinds[rest[0]]; ok {
+			return p.parseDelimited(s, i, rest[:1], kind, true)
+		}
+	case strings.HasPrefix(rest, "||"):
+		return p.parseDelimited(s, i, "||", Spoiler, false)
+	case strings.HasPrefix(rest, "~~"):
+		return p.parseDelimited(s, i, "~~", Strike, false)
+	case strings.HasPrefix(rest, "**"):
+		return p.parseDelimited(s, i, "**", Bold, false)
+	case strings.HasPrefix(rest, "__"):
+		return p.parseDelimited(s, i, "__", Bold, true)
+	case rest[0] == '*':
+		return p.parseDelimited(s, i, "*", Italic, false)
+	case rest[0] == '_':
+		return p.parseDelimited(s, i, "_", Italic, true)
+	case rest[0] == '[':
+		return p.parseLink(s, i)
+	case rest[0] == '@':
+		return parseMention(s, i)
+	case strings.HasPrefix(rest, "http://") || strings.HasPrefix(rest, "https://"):
+		if isWordBefore(s, i) {
+			return Node{}, 0, false
+		}
+		url := trimURL(rest[:strings.IndexFunc(rest+" ", isURLEnd)])
+		return Node{Kind: Link, URL: url, Children: []Node{{Kind: Text, Text: url}}}, i + len(url), true
+	}
+	return Node{}, 0, false
+}
+
+// parseCode parses a code span at s[i], it ends at a run of as many backticks as it
+// starts with. One space on both sides of the code is removed, so code can start or
+// end with a backtick.
+func (p *parser) parseCode(s string, i int) (Node, int, bool) {
+	n := backticks(s[i:])
+	for j := i + n; j < len(s); {
+		k := strings.IndexByte(s[j:], '`')
+		if k < 0 {
+			break
+		}
+		k += j
+		run := backticks(s[k:])
+		if run != n {
+			j = k + run
+			continue
+		}
+		code := s[i+n : k]
+		if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+			code = code[1 : len(code)-1]
+		}
+		if code == "" {
+			return Node{}, 0, false
+		}
+		return Node{Kind: Code, Text: p.text(code).Text}, k + n, true
+	}
+	return Node{}, 0, false
+}
+
+// backticks returns the amount of backticks s starts with.
+func backticks(s string) int {
+	n := 0
+	for n < len(s) && s[n] == '`' {
+		n++
+	}
+	return n
+}
+
+// parseDelimited parses s[i:] as text between two delim, formatted as kind. Word
+// delimiters can't be inside words.
+func (p *parser) parseDelimited(s string, i int, delim string, kind Kind, word bool) (Node, int, bool) {
+	if word && isWordBefore(s, i) {
+		return Node{}, 0, false
+	}
+	from := i + len(delim)
+	end := findCloser(s, from, delim, word)
+	if end < 0 {
+		return Node{}, 0, false
+	}
+	return Node{Kind: kind, Children: p.parseInline(s[from:end])}, end + len(delim), true
+}
+
+// findCloser returns the index of the delim closing the text starting at from, or -1.
+func findCloser(s string, from int, delim string, word bool) int {
+	if r, _ := utf8.DecodeRuneInString(s[from:]); from >= len(s) || unicode.IsSpace(r) {
+		return -1
+	}
+	for j := from; j < len(s); {
+		k := strings.Index(s[j:], delim)
+		if k < 0 {
+			return -1
+		}
+		k += j
+		before, _ := utf8.DecodeLastRuneInString(s[:k])
+		switch {
+		case k == from || unicode.IsSpace(before) || isEscaped(s, k):
+			j = k + 1
+		case strings.HasPrefix(s[k+len(delim):], delim[:1]) && len(delim) == 1:
+			// a single delimiter doesn't close at a double one
+			j = k + 2
+		case word && isWordAfter(s, k+len(delim)):
+			j = k + 1
+		default:
+			return k
+		}
+	}
+	return -1
+}
+
+// parseLink parses a [text](url) link at s[i].
+func (p *parser) parseLink(s string, i int) (Node, int, bool) {
+	closeText := i
+	for {
+		k := strings.Index(s[closeText+1:], "](")
+		if k < 0 {
+			return Node{}, 0, false
+		}
+		closeText += k + 1
+		if !isEscaped(s, closeText) {
+			break
+		}
+	}
+	closeURL := strings.IndexByte(s[closeText:], ')')
+	if closeURL < 0 {
+		return Node{}, 0, false
+	}
+	closeURL += closeText
+	url := s[closeText+2 : closeURL]
+	if url == "" || strings.ContainsAny(url, " \n") || strings.Contains(s[i+1:closeText], "\n") {
+		return Node{}, 0, false
+	}
+	return Node{Kind: Link, URL: url, Children: p.parseInline(s[i+1 : closeText])}, closeURL + 1, true
+}
+
+// parseSlackTag parses the <...> of Slack: links, mentions of users, channels and
+// special mentions like <!here>.
+// @see https://api.slack.com/reference/surfaces/formatting#retrieving-messages
+func (p *parser) parseSlackTag(s string, i int) (Node, int, bool) {
+	end := strings.IndexByte(s[i:], '>')
+	if end < 0 {
+		return Node{}, 0, false
+	}
+	tag := s[i+1 : i+end]
+	end += i + 1
+	target, label := tag, ""
+	if sep := strings.IndexByte(tag, '|'); sep >= 0 {
+		target, label = tag[:sep], tag[sep+1:]
+	}
+	if target == "" || strings.ContainsAny(target, " \n") {
+		return Node{}, 0, false
+	}
+	label = html.UnescapeString(label)
+	switch target[0] {
+	case '@':
+		id := target[1:]
+		name := label
+		if name == "" && p.username != nil {
+			name = p.username(id)
+		}
+		if name == "" {
+			name = id
+		}
+		return Node{Kind: Mention, UserID: id, Text: strings.TrimPrefix(name, "@")}, end, true
+	case '#':
+		if label == "" {
+			label = target[1:]
+		}
+		return Node{Kind: Text, Text: "#" + label}, end, true
+	case '!':
+		if label == "" {
+			label = target[1:]
+		}
+		return Node{Kind: Text, Text: "@" + strings.TrimPrefix(label, "@")}, end, true
+	}
+	url := html.UnescapeString(target)
+	if label == "" {
+		label = url
+	}
+	return Node{Kind: Link, URL: url, Children: []Node{{Kind: Text, Text: label}}}, end, true
+}
+
+// parseDiscordMention parses a <@id> or <@!id> mention at s[i].
+func (p *parser) parseDiscordMention(s string, i int) (Node, int, bool) {
+	end := strings.IndexByte(s[i:], '>')
+	if end < 0 {
+		return Node{}, 0, false
+	}
+	id := strings.TrimPrefix(s[i+2:i+end], "!")
+	if id == "" || strings.Trim(id, "0123456789") != "" {
+		return Node{}, 0, false
+	}
+	name := p.username(id)
+	if name == "" {
+		return Node{}, 0, false
+	}
+	return Node{Kind: Mention, UserID: id, Text: name}, i + end + 1, true
+}
+
+// parseMention parses a @name mention at s[i], but not of an email address or a
+// <@id> that wasn't parsed.
+func parseMention(s string, i int) (Node, int, bool) {
+	if isWordBefore(s, i) || strings.HasSuffix(s[:i], "<") {
+		return Node{}, 0, false
+	}
+	end := i + 1
+	for end < len(s) {
+		r, size := utf8.DecodeRuneInString(s[end:])
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_-.", r) {
+			break
+		}
+		end += size
+	}
+	name := strings.TrimRight(s[i+1:end], ".-")
+	if name == "" {
+		return Node{}, 0, false
+	}
+	return Node{Kind: Mention, Text: name}, i + 1 + len(name), true
+}
+
+// isEscaped returns true if s[i] is escaped by a backslash.
+func isEscaped(s string, i int) bool {
+	n := 0
+	for i-n > 0 && s[i-n-1] == '\\' {
+		n++
+	}
+	return n%2 == 1
+}
+
+func isWordBefore(s string, i int) bool {
+	r, _ := utf8.DecodeLastRuneInString(s[:i])
+	return i > 0 && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+func isWordAfter(s string, i int) bool {
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	return i < len(s) && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+func isURLEnd(r rune) bool {
+	return unicode.IsSpace(r) || r == '<' || r == '>' || r == '|'
+}
+
+// trimURL removes punctuation that ends the sentence around an URL.
+func trimURL(url string) string {
+	for {
+		trimmed := strings.TrimRight(url, ".,:;!?'\"")
+		if strings.HasSuffix(trimmed, ")") && strings.Count(trimmed, "(") < strings.Count(trimmed, ")") {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+		if trimmed == url {
+			return url
+		}
+		url = trimmed
+	}
+}
+
+func isLang(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("+-#._", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Markdown renders doc as the markdown of Message.Text.
+func Markdown(doc Document) string {
+	return renderMarkdown(doc, false)
+}
+
+// Discord renders doc as the markdown of Discord messages, with <@id> mentions of
+// Discord users.
+func Discord(doc Document) string {
+	return renderMarkdown(doc, true)
+}
+
+func renderMarkdown(nodes []Node, discord bool) string {
+	var (
+		sb    strings.Builder
+		texts [][2]int
+	)
+	writeMarkdown(&sb, nodes, discord, &texts)
+	return escapeMarkdown(sb.String(), texts)
+}
+
+// writeMarkdown writes the markdown of nodes to sb, and adds where the text nodes
+// are in it to texts.
+func writeMarkdown(sb *strings.Builder, nodes []Node, discord bool, texts *[][2]int) {
+	wrap := func(delim string, children []Node) {
+		sb.WriteString(delim)
+		writeMarkdown(sb, children, discord, texts)
+		sb.WriteString(delim)
+	}
+	for i, n := range nodes {
+		switch n.Kind {
+		case Text:
+			*texts = append(*texts, [2]int{sb.Len(), sb.Len() + len(n.Text)})
+			sb.WriteString(n.Text)
+		case Bold:
+			wrap("**", n.Children)
+		case Italic:
+			// _ doesn't format inside words
+			if isWordBefore(sb.String(), sb.Len()) || i+1 < len(nodes) && nodes[i+1].Kind == Text && isWordAfter(nodes[i+1].Text, 0) {
+				wrap("*", n.Children)
+				continue
+			}
+			wrap("_", n.Children)
+		case Strike:
+			wrap("~~", n.Children)
+		case Spoiler:
+			wrap("||", n.Children)
+		case Code:
+			sb.WriteString(codeSpan(n.Text))
+		case Pre:
+			fence := "```"
+			if strings.Contains(n.Text, fence) {
+				fence = "~~~"
+			}
+			sb.WriteString(fence + n.Lang + "\n" + n.Text + "\n" + fence)
+		case Link:
+			if isBareLink(n) {
+				sb.WriteString(n.URL)
+				continue
+			}
+			sb.WriteString("[")
+			writeMarkdown(sb, n.Children, discord, texts)
+			sb.WriteString("](" + n.URL + ")")
+		case Mention:
+			if discord && n.UserID != "" && strings.Trim(n.UserID, "0123456789") == "" {
+				sb.WriteString("<@" + n.UserID + ">")
+				continue
+			}
+			sb.WriteString("@" + n.Text)
+		case Quote:
+			sb.WriteString(prefixLines(renderMarkdown(n.Children, discord), "> "))
+		}
+	}
+}
+
+// escapeMarkdown escapes the characters in the texts of md that would be parsed as
+// markdown. The backslashes that would escape the next character are escaped first,
+// so the formatting is found as it would be parsed.
+func escapeMarkdown(md string, texts [][2]int) string {
+	md, texts = escapeAt(md, texts, func(md string, i int, text [2]int) int {
+		if md[i] == '\\' && i+1 < len(md) && strings.IndexByte(asciiPunct, md[i+1]) >= 0 {
+			return 1
+		}
+		return 0
+	})
+	p := &parser{}
+	md, _ = escapeAt(md, texts, func(md string, i int, text [2]int) int {
+		switch c := md[i]; {
+		case c == '`':
+			// a run of backticks is escaped when it starts code or touches other backticks
+			n := backticks(md[i:])
+			if i+n > text[1] {
+				n = text[1] - i
+			}
+			if _, _, ok := p.parseSpan(md, i); ok || n < backticks(md[i:]) || i > 0 && md[i-1] == '`' {
+				return n
+			}
+			return -n
+		case c == '>':
+			if i == 0 || md[i-1] == '\n' {
+				return 1
+			}
+		case strings.IndexByte("*_~|[", c) >= 0:
+			if _, _, ok := p.parseSpan(md, i); ok && !isEscaped(md, i) {
+				return 1
+			}
+			// delimiters touching the same delimiters of the formatting around them
+			if c != '[' && (i == text[0] && i > 0 && md[i-1] == c || i == text[1]-1 && i+1 < len(md) && md[i+1] == c) {
+				return 1
+			}
+		}
+		return 0
+	})
+	return md
+}
+
+// EscapeMarkdown escapes all the punctuation of text, so it's parsed as the same
+// text wherever it's put in markdown.
+func EscapeMarkdown(text string) string {
+	text, _ = escapeAt(text, [][2]int{{0, len(text)}}, func(md string, i int, text [2]int) int {
+		if strings.IndexByte(asciiPunct, md[i]) >= 0 {
+			return 1
+		}
+		return 0
+	})
+	return text
+}
+
+// escapeAt escapes the characters in the texts of md for which escape returns the
+// amount of characters to escape, or minus the amount to leave as is. It returns the
+// escaped md and where its texts are.
+func escapeAt(md string, texts [][2]int, escape func(md string, i int, text [2]int) int) (string, [][2]int) {
+	var sb strings.Builder
+	escaped := make([][2]int, 0, len(texts))
+	last := 0
+	for _, text := range texts {
+		sb.WriteString(md[last:text[0]])
+		start := sb.Len()
+		for i := text[0]; i < text[1]; {
+			n := escape(md, i, text)
+			switch {
+			case n > 0:
+				for ; n > 0; n-- {
+					sb.WriteByte('\\')
+					sb.WriteByte(md[i])
+					i++
+				}
+			case n < 0:
+				sb.WriteString(md[i : i-n])
+				i -= n
+			default:
+				sb.WriteByte(md[i])
+				i++
+			}
+		}
+		escaped = append(escaped, [2]int{start, sb.Len()})
+		last = text[1]
+	}
+	sb.WriteString(md[last:])
+	return sb.String(), escaped
+}
+
+// codeSpan returns the markdown of a code span of code, delimited by a run of backticks
+// that isn't in code.
+func codeSpan(code string) string {
+	runs := make(map[int]bool)
+	for i := 0; i < len(code); {
+		n := backticks(code[i:])
+		if n == 0 {
+			i++
+			continue
+		}
+		runs[n] = true
+		i += n
+	}
+	n := 1
+	// three backticks start a code block
+	for runs[n] || n == 3 {
+		n++
+	}
+	delim := strings.Repeat("`", n)
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") ||
+		len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+		code = " " + code + " "
+	}
+	return delim + code + delim
+}
+
+// isBareLink returns true if the text of the link n is its URL.
+func isBareLink(n Node) bool {
+	return len(n.Children) == 1 && n.Children[0].Kind == Text && n.Children[0].Text == n.URL
+}
+
+func prefixLines(s, prefix string) string {
+	return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
@@ -0,0 +1,221 @@
+// Package richtext is the formatting of messages shared by the bridges. Parsers turn
+// the markup of a protocol into a Document, renderers turn a Document into the markup
+// of another protocol, so formatting survives every pair of bridges.
+package richtext
+
+import (
+	"sort"
+	"strings"
+)
+
+// Kind is the kind of formatting of a Node.
+type Kind int
+
+const (
+	Text    Kind = iota // plain Text
+	Bold                // Children in bold
+	Italic              // Children in italic
+	Strike              // Children struck through
+	Code                // inline code in Text
+	Pre                 // code block in Text, in the language Lang
+	Link                // Children linking to URL
+	Mention             // mention of the user UserID, named Text
+	Quote               // Children quoted, as a block
+	Spoiler             // Children hidden until clicked
+)
+
+// Node is a run of text, or formatting of its children.
+type Node struct {
+	Kind     Kind
+	Text     string
+	URL      string
+	UserID   string
+	Lang     string
+	Children []Node
+}
+
+// Document is a formatted message. Newlines are in the Text of nodes, Quote and Pre
+// nodes are blocks that are followed by a newline if the document continues.
+type Document []Node
+
+// MapText returns doc with f applied to the plain text, but not code and URLs.
+func (doc Document) MapText(f func(string) string) Document {
+	return mapNodes(doc, func(n Node) Node {
+		if n.Kind == Text {
+			n.Text = f(n.Text)
+		}
+		return n
+	})
+}
+
+// MapMentions returns doc with f applied to its mentions.
+func (doc Document) MapMentions(f func(Node) Node) Document {
+	return mapNodes(doc, func(n Node) Node {
+		if n.Kind == Mention {
+			return f(n)
+		}
+		return n
+	})
+}
+
+func mapNodes(nodes []Node, f func(Node) Node) []Node {
+	if nodes == nil {
+		return nil
+	}
+	res := make([]Node, 0, len(nodes))
+	for _, n := range nodes {
+		n.Children = mapNodes(n.Children, f)
+		res = append(res, f(n))
+	}
+	return res
+}
+
+// appendNode appends n to nodes, merging it into the last node if they have the same
+// formatting.
+func appendNode(nodes []Node, n Node) []Node {
+	if n.Kind == Text && n.Text == "" {
+		return nodes
+	}
+	if len(nodes) > 0 {
+		last := &nodes[len(nodes)-1]
+		if last.Kind == n.Kind && last.URL == n.URL && last.UserID == n.UserID && last.Lang == n.Lang {
+			switch n.Kind {
+			case Text, Code:
+				last.Text += n.Text
+				return nodes
+			case Bold, Italic, Strike, Spoiler, Link:
+				children := last.Children[:len(last.Children):len(last.Children)]
+				for _, c := range n.Children {
+					children = appendNode(children, c)
+				}
+				last.Children = children
+				return nodes
+			}
+		}
+	}
+	return append(nodes, n)
+}
+
+// appendNewline appends a newline to nodes, unless they're empty or end with one.
+func appendNewline(nodes []Node) []Node {
+	if len(nodes) == 0 {
+		return nodes
+	}
+	if last := nodes[len(nodes)-1]; last.Kind == Text && strings.HasSuffix(last.Text, "\n") {
+		return nodes
+	}
+	return appendNode(nodes, Node{Kind: Text, Text: "\n"})
+}
+
+// trimNewlines removes the newlines at the start and the end of nodes.
+func trimNewlines(nodes []Node) []Node {
+	if len(nodes) > 0 && nodes[0].Kind == Text {
+		nodes[0].Text = strings.TrimLeft(nodes[0].Text, "\n")
+		if nodes[0].Text == "" {
+			nodes = nodes[1:]
+		}
+	}
+	if len(nodes) > 0 && nodes[len(nodes)-1].Kind == Text {
+		last := &nodes[len(nodes)-1]
+		last.Text = strings.TrimRight(last.Text, "\n")
+		if last.Text == "" {
+			nodes = nodes[:len(nodes)-1]
+		}
+	}
+	return nodes
+}
+
+// Span is the formatting of Node for the runes Start to End of a text, like the
+// entities of Telegram messages.
+type Span struct {
+	Start, End int
+	Node       Node
+}
+
+// FromSpans returns the document of text formatted with spans. The text of Code, Pre
+// and Mention spans is filled in, other spans get it as children. Overlapping spans
+// are nested, longer spans outside.
+func FromSpans(text string, spans []Span) Document {
+	runes := []rune(text)
+	bounds := []int{0, len(runes)}
+	valid := spans[:0:0]
+	for _, s := range spans {
+		if s.Start < 0 || s.End > len(runes) || s.Start >= s.End {
+			continue
+		}
+		valid = append(valid, s)
+		bounds = append(bounds, s.Start, s.End)
+	}
+	sort.SliceStable(valid, func(i, j int) bool {
+		return valid[i].End-valid[i].Start > valid[j].End-valid[j].Start
+	})
+	sort.Ints(bounds)
+
+	var doc []Node
+	for i := 0; i+1 < len(bounds); i++ {
+		start, end := bounds[i], bounds[i+1]
+		if start == end {
+			continue
+		}
+		segment := string(runes[start:end])
+		var active []Node
+		for _, s := range valid {
+			if s.Start <= start && s.End >= end {
+				active = append(active, s.Node)
+			}
+		}
+		node := Node{Kind: Text, Text: segment}
+		for j := len(active) - 1; j >= 0; j-- {
+			outer := active[j]
+			switch outer.Kind {
+			case Code, Pre, Mention:
+				if node.Kind != Text {
+					// text nodes can't be formatted inside
+					continue
+				}
+				outer.Text = segment
+				if outer.Kind == Mention {
+					outer.Text = strings.TrimPrefix(segment, "@")
+				}
+				outer.Children = nil
+			case Text:
+				continue
+			default:
+				outer.Children = []Node{node}
+			}
+			node = outer
+		}
+		doc = appendNode(doc, node)
+	}
+	return separateBlocks(doc)
+}
+
+// separateBlocks puts newlines around the Quote and Pre nodes of nodes.
+func separateBlocks(nodes []Node) Document {
+	var res []Node
+	for i, n := range nodes {
+		if n.Kind == Quote || n.Kind == Pre {
+			if len(res) > 0 {
+				res[len(res)-1] = trimTrailingNewline(res[len(res)-1])
+				res = appendNode(res, Node{Kind: Text, Text: "\n"})
+			}
+			if n.Kind == Quote {
+				n.Children = trimNewlines(n.Children)
+			}
+			res = append(res, n)
+			if i+1 < len(nodes) && !(nodes[i+1].Kind == Text && strings.HasPrefix(nodes[i+1].Text, "\n")) {
+				res = append(res, Node{Kind: Text, Text: "\n"})
+			}
+			continue
+		}
+		res = appendNode(res, n)
+	}
+	return trimNewlines(res)
+}
+
+func trimTrailingNewline(n Node) Node {
+	if n.Kind == Text {
+		n.Text = strings.TrimSuffix(n.Text, "\n")
+	}
+	return n
+}
@@ -0,0 +1,272 @@
+package richtext
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func text(s string) Node {
+	return Node{Kind: Text, Text: s}
+}
+
+func format(kind Kind, children ...Node) Node {
+	return Node{Kind: kind, Children: children}
+}
+
+var markdownTestCases = map[string]struct {
+	input    string
+	doc      Document
+	markdown string
+}{
+	"plain": {
+		input: "hello world",
+		doc:   Document{text("hello world")},
+	},
+	"emphasis": {
+		input: "**bold** _italic_ *italic* ~~strike~~ ||spoiler||",
+		doc: Document{
+			format(Bold, text("bold")), text(" "), format(Italic, text("italic")), text(" "),
+			format(Italic, text("italic")), text(" "), format(Strike, text("strike")), text(" "),
+			format(Spoiler, text("spoiler")),
+		},
+		markdown: "**bold** _italic_ _italic_ ~~strike~~ ||spoiler||",
+	},
+	"nested": {
+		input: "**bold _and italic_**",
+		doc:   Document{format(Bold, text("bold "), format(Italic, text("and italic")))},
+	},
+	"not intraword": {
+		input: "snake_case_name and 2 * 3 * 4",
+		doc:   Document{text("snake_case_name and 2 * 3 * 4")},
+	},
+	"unclosed": {
+		input: "**not bold",
+		doc:   Document{text("**not bold")},
+	},
+	"code": {
+		input: "run `go *test*` now",
+		doc:   Document{text("run "), {Kind: Code, Text: "go *test*"}, text(" now")},
+	},
+	"code block": {
+		input: "look:\n```go\nfunc main() {}\n```\ndone",
+		doc:   Document{text("look:\n"), {Kind: Pre, Text: "func main() {}", Lang: "go"}, text("\ndone")},
+	},
+	"links": {
+		input: "see [the _docs_](https://example.com/docs) or https://example.com/faq.",
+		doc: Document{
+			text("see "),
+			{Kind: Link, URL: "https://example.com/docs", Children: []Node{text("the "), format(Italic, text("docs"))}},
+			text(" or "),
+			{Kind: Link, URL: "https://example.com/faq", Children: []Node{text("https://example.com/faq")}},
+			text("."),
+		},
+	},
+	"quote": {
+		input: "> quoted\n> **twice**\nanswer",
+		doc: Document{
+			format(Quote, text("quoted\n"), format(Bold, text("twice"))),
+			text("\nanswer"),
+		},
+	},
+	"mentions": {
+		input: "hi @alice.b, mail bob@example.com @",
+		doc:   Document{text("hi "), {Kind: Mention, Text: "alice.b"}, text(", mail bob@example.com @")},
+	},
+	"not a quote in code": {
+		input: "```\n> x\n```",
+		doc:   Document{{Kind: Pre, Text: "> x"}},
+	},
+	"tilde code block": {
+		input:    "~~~sh\necho ~~hi~~\n~~~",
+		doc:      Document{{Kind: Pre, Text: "echo ~~hi~~", Lang: "sh"}},
+		markdown: "```sh\necho ~~hi~~\n```",
+	},
+	"code block with a fence": {
+		input: "~~~\n```\n~~~",
+		doc:   Document{{Kind: Pre, Text: "```"}},
+	},
+	"escapes": {
+		input: `\*not bold\* \_nor italic\_ a\\b \d`,
+		doc:   Document{text(`*not bold* _nor italic_ a\b \d`)},
+		// only what would be formatting is escaped
+		markdown: `\*not bold* \_nor italic_ a\b \d`,
+	},
+	"backticks in code": {
+		input: "`a``b` and `` `c` ``",
+		doc:   Document{{Kind: Code, Text: "a``b"}, text(" and "), {Kind: Code, Text: "`c`"}},
+	},
+	"unclosed backticks": {
+		input: "``a` b",
+		doc:   Document{text("``a` b")},
+	},
+	"unclosed code block in a quote": {
+		input: "> ```\n",
+		doc:   Document{format(Quote, text("```")), text("\n")},
+	},
+	"code block in a quote": {
+		input: "> ```\n> code\n> ```\nafter",
+		doc:   Document{format(Quote, Node{Kind: Pre, Text: "code"}), text("\nafter")},
+	},
+}
+
+func TestMarkdown(t *testing.T) {
+	for name, tc := range markdownTestCases {
+		doc := ParseMarkdown(tc.input)
+		assert.Equalf(t, tc.doc, doc, "Testcase %s", name)
+		want := tc.markdown
+		if want == "" {
+			want = tc.input
+		}
+		assert.Equalf(t, want, Markdown(doc), "Testcase %s", name)
+		assert.Equalf(t, doc, ParseMarkdown(Markdown(doc)), "Testcase %s", name)
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	for _, text := range []string{"foo_bar_", "*a* `b` [c](d)", "> @e ~~f~~ ||g||", "\\_h\\"} {
+		assert.Equal(t, Document{{Kind: Text, Text: text}}, ParseMarkdown(EscapeMarkdown(text)), text)
+		assert.Equal(t, Document{{Kind: Bold, Children: []Node{{Kind: Text, Text: text}}}},
+			ParseMarkdown("**"+EscapeMarkdown(text)+"**"), text)
+	}
+}
+
+func TestParseHTML(t *testing.T) {
+	testcases := map[string]struct {
+		input string
+		doc   Document
+	}{
+		"formatting": {
+			input: `<strong>bold</strong> <em>it</em><br>next &amp; <del>old</del> <code>x</code>`,
+			doc: Document{
+				format(Bold, text("bold")), text(" "), format(Italic, text("it")),
+				text("\nnext & "), format(Strike, text("old")), text(" "), {Kind: Code, Text: "x"},
+			},
+		},
+		"matrix": {
+			input: `<mx-reply><blockquote>original</blockquote></mx-reply>` +
+				`<a href="https://matrix.to/#/@alice:example.org">Alice</a>: <span data-mx-spoiler>secret</span>`,
+			doc: Document{
+				{Kind: Mention, UserID: "@alice:example.org", Text: "Alice"},
+				text(": "), format(Spoiler, text("secret")),
+			},
+		},
+		"blocks": {
+			input: "<p>one</p>\n<blockquote><p>quoted</p></blockquote>\n<pre><code class=\"language-go\">a\n</code></pre><ul><li>item</li></ul>",
+			doc: Document{
+				text("one\n"), format(Quote, text("quoted")), text("\n"),
+				{Kind: Pre, Text: "a", Lang: "go"}, text("\n- item"),
+			},
+		},
+	}
+	for name, tc := range testcases {
+		assert.Equalf(t, tc.doc, ParseHTML(tc.input), "Testcase %s", name)
+	}
+}
+
+func TestParseSlack(t *testing.T) {
+	username := func(id string) string {
+		if id == "U123" {
+			return "alice"
+		}
+		return ""
+	}
+	doc := ParseSlack("*bold* _it_ ~gone~ <@U123> <#C1|general> <!here> <https://example.com|site> 1 &lt; 2\n&gt; quote", username)
+	assert.Equal(t, Document{
+		format(Bold, text("bold")), text(" "), format(Italic, text("it")), text(" "), format(Strike, text("gone")), text(" "),
+		{Kind: Mention, UserID: "U123", Text: "alice"},
+		text(" #general @here "),
+		{Kind: Link, URL: "https://example.com", Children: []Node{text("site")}},
+		text(" 1 < 2\n"), format(Quote, text("quote")),
+	}, doc)
+	assert.Equal(t, "**bold** _it_ ~~gone~~ @alice #general @here [site](https://example.com) 1 < 2\n> quote", Markdown(doc))
+}
+
+func TestDiscord(t *testing.T) {
+	username := func(id string) string {
+		if id == "123" {
+			return "alice"
+		}
+		return ""
+	}
+	doc := ParseDiscord("<@123> and <@!123>, not <@456> or <@&789>", username)
+	mention := Node{Kind: Mention, UserID: "123", Text: "alice"}
+	assert.Equal(t, Document{mention, text(" and "), mention, text(", not <@456> or <@&789>")}, doc)
+	assert.Equal(t, "<@123> and <@123>, not <@456> or <@&789>", Discord(doc))
+	assert.Equal(t, "@alice and @alice, not <@456> or <@&789>", Markdown(doc))
+	assert.Equal(t, "@alice", Discord(Document{{Kind: Mention, UserID: "U123", Text: "alice"}}))
+}
+
+func TestParseIRC(t *testing.T) {
+	assert.Equal(t, ParseMarkdown("**already** markdown"), ParseIRC("**already** markdown"))
+	assert.Equal(t, Document{
+		format(Bold, text("bold "), format(Italic, text("both"))), text(" "),
+		{Kind: Code, Text: "mono"}, text(" red "), format(Spoiler, text("hidden")),
+	}, ParseIRC("\x02bold \x1dboth\x0f \x11mono\x11 \x0304red\x03 \x0301,01hidden\x03"))
+	doc := ParseIRC("\x02bold\x02 and _md_")
+	assert.Equal(t, Document{format(Bold, text("bold")), text(" and "), format(Italic, text("md"))}, doc)
+}
+
+func TestRender(t *testing.T) {
+	doc := Document{
+		format(Bold, text("bold")), text(" <&> "), format(Italic, text("it")), text(" "),
+		{Kind: Code, Text: "x<y"}, text(" "),
+		{Kind: Link, URL: "https://example.com", Children: []Node{text("site")}}, text(" "),
+		{Kind: Mention, UserID: "@alice:example.org", Text: "alice"}, text(" "),
+		format(Spoiler, text("secret")), text("\n"),
+		format(Quote, text("a\nb")), text("\n"),
+		{Kind: Pre, Text: "code", Lang: "go"},
+	}
+	testcases := map[string]struct {
+		render func(Document) string
+		want   string
+	}{
+		"markdown": {Markdown, "**bold** <&> _it_ `x<y` [site](https://example.com) @alice ||secret||\n> a\n> b\n```go\ncode\n```"},
+		"html": {HTML, `<strong>bold</strong> &lt;&amp;&gt; <em>it</em> <code>x&lt;y</code> <a href="https://example.com">site</a> ` +
+			`<a href="https://matrix.to/#/@alice:example.org">alice</a> <span data-mx-spoiler>secret</span><br>` +
+			`<blockquote>a<br>b</blockquote><pre><code class="language-go">code</code></pre>`},
+		"telegram html": {TelegramHTML, "<b>bold</b> &lt;&amp;&gt; <i>it</i> <code>x&lt;y</code> <a href=\"https://example.com\">site</a> " +
+			"@alice <tg-spoiler>secret</tg-spoiler>\n<blockquote>a\nb</blockquote><pre><code class=\"language-go\">code</code></pre>"},
+		"telegram markdownv2": {TelegramMarkdownV2, "*bold* <&\\> _it_ `x<y` [site](https://example.com) @alice ||secret||\n>a\n>b\n```go\ncode\n```"},
+		"irc":                 {IRC, "\x02bold\x02 <&> \x1dit\x1d \x11x<y\x11 site (https://example.com) @alice \x0301,01secret\x03\x02\x02\n> a\n> b\n\x11code\x11"},
+		"plain":               {Plain, "bold <&> it x<y site (https://example.com) @alice ||secret||\n> a\n> b\ncode"},
+		"slack":               {Slack, "*bold* &lt;&amp;&gt; _it_ `x&lt;y` <https://example.com|site> @alice secret\n> a\n> b\n```\ncode\n```"},
+	}
+	for name, tc := range testcases {
+		assert.Equalf(t, tc.want, tc.render(doc), "Testcase %s", name)
+	}
+	assert.Equal(t, "<@U123>", Slack(Document{{Kind: Mention, UserID: "U123", Text: "alice"}}))
+	assert.Equal(t, `<a href="tg://user?id=42">bob</a>`, TelegramHTML(Document{{Kind: Mention, UserID: "42", Text: "bob"}}))
+}
+
+func TestFromSpans(t *testing.T) {
+	// "bold and link" with "bold and" in bold, "and link" linked, and a code block
+	doc := FromSpans("bold and link\ncode", []Span{
+		{Start: 0, End: 8, Node: Node{Kind: Bold}},
+		{Start: 5, End: 13, Node: Node{Kind: Link, URL: "https://example.com"}},
+		{Start: 14, End: 18, Node: Node{Kind: Pre}},
+	})
+	assert.Equal(t, Document{
+		format(Bold, text("bold "), Node{Kind: Link, URL: "https://example.com", Children: []Node{text("and")}}),
+		{Kind: Link, URL: "https://example.com", Children: []Node{text(" link")}},
+		text("\n"),
+		{Kind: Pre, Text: "code"},
+	}, doc)
+	assert.Equal(t, "**bold [and](https://example.com)**[ link](https://example.com)\n```\ncode\n```", Markdown(doc))
+}
+
+func TestMapText(t *testing.T) {
+	doc := Document{format(Bold, text(":smile:")), {Kind: Code, Text: ":smile:"}}
+	mapped := doc.MapText(func(s string) string { return "😄" })
+	assert.Equal(t, Document{format(Bold, text("😄")), {Kind: Code, Text: ":smile:"}}, mapped)
+	assert.Equal(t, ":smile:", doc[0].Children[0].Text)
+}
+
+func TestMapMentions(t *testing.T) {
+	doc := Document{format(Bold, Node{Kind: Mention, Text: "alice"}), text(" alice")}
+	mapped := doc.MapMentions(func(n Node) Node {
+		n.UserID = "123"
+		return n
+	})
+	assert.Equal(t, Document{format(Bold, Node{Kind: Mention, UserID: "123", Text: "alice"}), text(" alice")}, mapped)
+}
@@ -0,0 +1,65 @@
+package richtext
+
+import (
+	"regexp"
+	"strings"
+)
+
+// slackEscaper escapes the characters Slack uses for its markup.
+// @see https://api.slack.com/reference/surfaces/formatting#escaping
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackUserID matches the IDs of Slack users.
+var slackUserID = regexp.MustCompile(`^[UW][A-Z0-9]+$`)
+
+// Slack renders doc as the mrkdwn of Slack messages. Slack has no spoilers, they're
+// shown as is.
+func Slack(doc Document) string {
+	var sb strings.Builder
+	writeSlack(&sb, doc)
+	return sb.String()
+}
+
+func writeSlack(sb *strings.Builder, nodes []Node) {
+	wrap := func(delim string, children []Node) {
+		sb.WriteString(delim)
+		writeSlack(sb, children)
+		sb.WriteString(delim)
+	}
+	for _, n := range nodes {
+		switch n.Kind {
+		case Text:
+			sb.WriteString(slackEscaper.Replace(n.Text))
+		case Bold:
+			wrap("*", n.Children)
+		case Italic:
+			wrap("_", n.Children)
+		case Strike:
+			wrap("~", n.Children)
+		case Spoiler:
+			writeSlack(sb, n.Children)
+		case Code:
+			sb.WriteString("`" + slackEscaper.Replace(n.Text) + "`")
+		case Pre:
+			sb.WriteString("```\n" + slackEscaper.Replace(n.Text) + "\n```")
+		case Link:
+			if isBareLink(n) {
+				sb.WriteString("<" + n.URL + ">")
+				continue
+			}
+			sb.WriteString("<" + n.URL + "|")
+			writeSlack(sb, n.Children)
+			sb.WriteString(">")
+		case Mention:
+			if slackUserID.MatchString(n.UserID) {
+				sb.WriteString("<@" + n.UserID + ">")
+				continue
+			}
+			sb.WriteString(slackEscaper.Replace("@" + n.Text))
+		case Quote:
+			var inner strings.Builder
+			writeSlack(&inner, n.Children)
+			sb.WriteString(prefixLines(inner.String(), "> "))
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package richtext
+
+import (
+	"strings"
+)
+
+// Characters that are escaped in the MarkdownV2 of Telegram.
+// @see https://core.telegram.org/bots/api#markdownv2-style
+var (
+	markdownV2Escaper     = newEscaper("_*[]()~`>#+-=|{}.!\\")
+	markdownV2CodeEscaper = newEscaper("`\\")
+	markdownV2URLEscaper  = newEscaper(")\\")
+)
+
+// newEscaper returns a replacer that escapes chars with a backslash.
+func newEscaper(chars string) *strings.Replacer {
+	var oldnew []string
+	for _, c := range chars {
+		oldnew = append(oldnew, string(c), "\\"+string(c))
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+// TelegramMarkdownV2 renders doc as the MarkdownV2 of Telegram messages.
+func TelegramMarkdownV2(doc Document) string {
+	var sb strings.Builder
+	writeMarkdownV2(&sb, doc)
+	return sb.String()
+}
+
+func writeMarkdownV2(sb *strings.Builder, nodes []Node) {
+	wrap := func(delim string, children []Node) {
+		sb.WriteString(delim)
+		writeMarkdownV2(sb, children)
+		sb.WriteString(delim)
+	}
+	for _, n := range nodes {
+		switch n.Kind {
+		case Text:
+			sb.WriteString(markdownV2Escaper.Replace(n.Text))
+		case Bold:
+			wrap("*", n.Children)
+		case Italic:
+			wrap("_", n.Children)
+		case Strike:
+			wrap("~", n.Children)
+		case Spoiler:
+			wrap("||", n.Children)
+		case Code:
+			sb.WriteString("`" + markdownV2CodeEscaper.Replace(n.Text) + "`")
+		case Pre:
+			sb.WriteString("```" + n.Lang + "\n" + markdownV2CodeEscaper.Replace(n.Text) + "\n```")
+		case Link:
+			sb.WriteString("[")
+			writeMarkdownV2(sb, n.Children)
+			sb.WriteString("](" + markdownV2URLEscaper.Replace(n.URL) + ")")
+		case Mention:
+			if href := telegramMention(n); href != "" {
+				sb.WriteString("[" + markdownV2Escaper.Replace(n.Text) + "](" + href + ")")
+				continue
+			}
+			sb.WriteString(markdownV2Escaper.Replace("@" + n.Text))
+		case Quote:
+			var inner strings.Builder
+			writeMarkdownV2(&inner, n.Children)
+			sb.WriteString(prefixLines(inner.String(), ">"))
+		}
+	}
+}
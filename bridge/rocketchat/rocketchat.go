@@ -109,6 +109,11 @@ func (b *Brocketchat) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// Capabilities returns what rocketchat supports.
+func (b *Brocketchat) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Edits: true, Deletes: true, Files: true, Formatting: true}
+}
+
 func (b *Brocketchat) Send(msg config.Message) (string, error) {
 	// strip the # if people has set this
 	msg.Channel = strings.TrimPrefix(msg.Channel, "#")
@@ -3,11 +3,11 @@ package bslack
 import (
 	"errors"
 	"fmt"
-	"html"
 	"time"
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/slack-go/slack"
 )
 
@@ -30,13 +30,8 @@ func (b *Bslack) handleSlack() {
 		if message.Event != config.EventUserTyping && message.Event != config.EventMsgDelete &&
 			message.Event != config.EventFileDelete {
 			b.Log.Debugf("<= Sending message from %s on %s to gateway", message.Username, b.Account)
-			// cleanup the message
-			message.Text = b.replaceMention(message.Text)
-			message.Text = b.replaceVariable(message.Text)
-			message.Text = b.replaceChannel(message.Text)
-			message.Text = b.replaceURL(message.Text)
-			message.Text = b.replaceb0rkedMarkDown(message.Text)
-			message.Text = html.UnescapeString(message.Text)
+			// convert mrkdwn, mentions and links
+			message.SetRichText(richtext.ParseSlack(message.Text, b.users.getUsername))
 
 			// Add the avatar
 			message.Avatar = b.users.getAvatar(message.UserID)
@@ -77,6 +72,24 @@ func (b *Bslack) handleSlackClient(messages chan *config.Message) {
 				continue
 			}
 			messages <- rmsg
+		case *slack.ReactionAddedEvent:
+			rmsg, err := b.handleReactionEvent(ev, false)
+			if err == ErrEventIgnored {
+				continue
+			} else if err != nil {
+				b.Log.Errorf("%#v", err)
+				continue
+			}
+			messages <- rmsg
+		case *slack.ReactionRemovedEvent:
+			rmsg, err := b.handleReactionEvent((*slack.ReactionAddedEvent)(ev), true)
+			if err == ErrEventIgnored {
+				continue
+			} else if err != nil {
+				b.Log.Errorf("%#v", err)
+				continue
+			}
+			messages <- rmsg
 		case *slack.FileDeletedEvent:
 			rmsg, err := b.handleFileDeletedEvent(ev)
 			if err != nil {
@@ -342,6 +355,29 @@ func (b *Bslack) handleTypingEvent(ev *slack.UserTypingEvent) (*config.Message,
 	}, nil
 }
 
+func (b *Bslack) handleReactionEvent(ev *slack.ReactionAddedEvent, removed bool) (*config.Message, error) {
+	if ev.User == b.si.User.ID || ev.Item.Type != "message" {
+		return nil, ErrEventIgnored
+	}
+	channelInfo, err := b.channels.getChannelByID(ev.Item.Channel)
+	if err != nil {
+		return nil, err
+	}
+	rmsg := &config.Message{
+		Channel:  channelInfo.Name,
+		Account:  b.Account,
+		Event:    config.EventReaction,
+		UserID:   ev.User,
+		Username: b.users.getUsername(ev.User),
+		// the gateway replaces the :name: of standard emoji with unicode
+		Reaction: &config.Reaction{Emoji: ":" + ev.Reaction + ":", TargetID: ev.Item.Timestamp, Removed: removed},
+	}
+	if b.useChannelID {
+		rmsg.Channel = "ID:" + channelInfo.ID
+	}
+	return rmsg, nil
+}
+
 // handleDownloadFile handles file download
 func (b *Bslack) handleDownloadFile(rmsg *config.Message, file *slack.File, retry bool) error {
 	if b.fileCached(file) {
@@ -381,15 +417,7 @@ func (b *Bslack) handleGetChannelMembers(rmsg *config.Message) bool {
 		return false
 	}
 
-	cMembers := b.channels.getChannelMembers(b.users)
-
-	extra := make(map[string][]interface{})
-	extra[config.EventGetChannelMembers] = append(extra[config.EventGetChannelMembers], cMembers)
-	msg := config.Message{
-		Extra:   extra,
-		Event:   config.EventGetChannelMembers,
-		Account: b.Account,
-	}
+	msg := helper.ChannelMembersMessage(b.Account, b.channels.getChannelMembers(b.users))
 
 	b.Log.Debugf("sending msg to remote %#v", msg)
 	b.Remote <- msg
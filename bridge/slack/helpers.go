@@ -3,7 +3,6 @@ package bslack
 import (
 	"fmt"
 	"regexp"
-	"strings"
 	"time"
 
 	"github.com/42wim/matterbridge/bridge/config"
@@ -123,14 +122,7 @@ func (b *Bslack) populateMessageWithBotInfo(ev *slack.MessageEvent, rmsg *config
 	return nil
 }
 
-var (
-	mentionRE        = regexp.MustCompile(`<@([a-zA-Z0-9]+)>`)
-	channelRE        = regexp.MustCompile(`<#[a-zA-Z0-9]+\|(.+?)>`)
-	variableRE       = regexp.MustCompile(`<!((?:subteam\^)?[a-zA-Z0-9]+)(?:\|@?(.+?))?>`)
-	urlRE            = regexp.MustCompile(`<([^<\|]+)\|([^>]+)>`)
-	codeFenceRE      = regexp.MustCompile(`(?m)^` + "```" + `\w+$`)
-	topicOrPurposeRE = regexp.MustCompile(`(?s)(@.+) (cleared|set)(?: the)? channel (topic|purpose)(?:: (.*))?`)
-)
+var topicOrPurposeRE = regexp.MustCompile(`(?s)(@.+) (cleared|set)(?: the)? channel (topic|purpose)(?:: (.*))?`)
 
 func (b *Bslack) extractTopicOrPurpose(text string) (string, string) {
 	r := topicOrPurposeRE.FindStringSubmatch(text)
@@ -147,77 +139,6 @@ func (b *Bslack) extractTopicOrPurpose(text string) (string, string) {
 	return "unknown", ""
 }
 
-// @see https://api.slack.com/docs/message-formatting#linking_to_channels_and_users
-func (b *Bslack) replaceMention(text string) string {
-	replaceFunc := func(match string) string {
-		userID := strings.Trim(match, "@<>")
-		if username := b.users.getUsername(userID); userID != "" {
-			return "@" + username
-		}
-		return match
-	}
-	return mentionRE.ReplaceAllStringFunc(text, replaceFunc)
-}
-
-// @see https://api.slack.com/docs/message-formatting#linking_to_channels_and_users
-func (b *Bslack) replaceChannel(text string) string {
-	for _, r := range channelRE.FindAllStringSubmatch(text, -1) {
-		text = strings.Replace(text, r[0], "#"+r[1], 1)
-	}
-	return text
-}
-
-// @see https://api.slack.com/docs/message-formatting#variables
-func (b *Bslack) replaceVariable(text string) string {
-	for _, r := range variableRE.FindAllStringSubmatch(text, -1) {
-		if r[2] != "" {
-			text = strings.Replace(text, r[0], "@"+r[2], 1)
-		} else {
-			text = strings.Replace(text, r[0], "@"+r[1], 1)
-		}
-	}
-	return text
-}
-
-// @see https://api.slack.com/docs/message-formatting#linking_to_urls
-func (b *Bslack) replaceURL(text string) string {
-	return urlRE.ReplaceAllString(text, "[${2}](${1})")
-}
-
-func (b *Bslack) replaceb0rkedMarkDown(text string) string {
-	// taken from https://github.com/mattermost/mattermost-server/blob/master/app/slackimport.go
-	//
-	regexReplaceAllString := []struct {
-		regex *regexp.Regexp
-		rpl   string
-	}{
-		// bold
-		{
-			regexp.MustCompile(`(^|[\s.;,])\*(\S[^*\n]+)\*`),
-			"$1**$2**",
-		},
-		// strikethrough
-		{
-			regexp.MustCompile(`(^|[\s.;,])\~(\S[^~\n]+)\~`),
-			"$1~~$2~~",
-		},
-		// single paragraph blockquote
-		// Slack converts > character to &gt;
-		{
-			regexp.MustCompile(`(?sm)^&gt;`),
-			">",
-		},
-	}
-	for _, rule := range regexReplaceAllString {
-		text = rule.regex.ReplaceAllString(text, rule.rpl)
-	}
-	return text
-}
-
-func (b *Bslack) replaceCodeFence(text string) string {
-	return codeFenceRE.ReplaceAllString(text, "```")
-}
-
 // getUsersInConversation returns an array of userIDs that are members of channelID
 func (b *Bslack) getUsersInConversation(channelID string) ([]string, error) {
 	channelMembers := []string{}
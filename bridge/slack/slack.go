@@ -11,8 +11,10 @@ import (
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/42wim/matterbridge/matterhook"
 	lru "github.com/hashicorp/golang-lru"
+	"github.com/kyokomi/emoji/v2"
 	"github.com/rs/xid"
 	"github.com/slack-go/slack"
 )
@@ -190,14 +192,34 @@ func (b *Bslack) Reload(cfg *bridge.Config) (string, error) {
 	return "", nil
 }
 
+// Capabilities returns what slack supports, texts are clipped to the max length
+// of slack messages.
+func (b *Bslack) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{
+		Edits:      true,
+		Deletes:    true,
+		Threads:    true,
+		Reactions:  true,
+		Files:      true,
+		UserTyping: true,
+		Members:    true,
+		Formatting: true,
+		MaxLength:  messageLength,
+	}
+}
+
 func (b *Bslack) Send(msg config.Message) (string, error) {
 	// Too noisy to log like other events
 	if msg.Event != config.EventUserTyping {
 		b.Log.Debugf("=> Receiving %#v", msg)
 	}
 
+	// Convert markdown to mrkdwn
+	if msg.Event == "" || msg.Event == config.EventUserAction {
+		msg.Text = richtext.Slack(msg.RichText())
+	}
+
 	msg.Text = helper.ClipMessage(msg.Text, messageLength, b.GetString("MessageClipped"))
-	msg.Text = b.replaceCodeFence(msg.Text)
 
 	// Make a action /me of the message
 	if msg.Event == config.EventUserAction {
@@ -291,6 +313,9 @@ func (b *Bslack) sendRTM(msg config.Message) (string, error) {
 		}
 		return "", nil
 	}
+	if msg.Event == config.EventReaction {
+		return "", b.sendReaction(&msg, channelInfo)
+	}
 
 	var handled bool
 
@@ -405,6 +430,32 @@ func (b *Bslack) deleteMessage(msg *config.Message, channelInfo *slack.Channel)
 	}
 }
 
+// sendReaction adds or removes a reaction of the bot. Reactions other users already
+// added, or removed, are ignored.
+func (b *Bslack) sendReaction(msg *config.Message, channelInfo *slack.Channel) error {
+	name := strings.Trim(msg.Reaction.Emoji, ":")
+	if aliases := emoji.RevCodeMap()[msg.Reaction.Emoji]; len(aliases) > 0 {
+		name = strings.Trim(aliases[0], ":")
+	}
+	item := slack.NewRefToMessage(channelInfo.ID, msg.Reaction.TargetID)
+	for {
+		var err error
+		if msg.Reaction.Removed {
+			err = b.rtm.RemoveReaction(name, item)
+		} else {
+			err = b.rtm.AddReaction(name, item)
+		}
+		if err == nil || err.Error() == "already_reacted" || err.Error() == "no_reaction" {
+			return nil
+		}
+
+		if err = handleRateLimit(b.Log, err); err != nil {
+			b.Log.Errorf("Failed to send reaction to Slack: %#v", err)
+			return err
+		}
+	}
+}
+
 func (b *Bslack) editMessage(msg *config.Message, channelInfo *slack.Channel) (bool, error) {
 	if msg.ID == "" {
 		return false, nil
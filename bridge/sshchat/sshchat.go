@@ -64,11 +64,12 @@ func (b *Bsshchat) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// Capabilities returns what ssh-chat supports, it only has plain text.
+func (b *Bsshchat) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Files: true}
+}
+
 func (b *Bsshchat) Send(msg config.Message) (string, error) {
-	// ignore delete messages
-	if msg.Event == config.EventMsgDelete {
-		return "", nil
-	}
 	b.Log.Debugf("=> Receiving %#v", msg)
 	if msg.Extra != nil {
 		for _, rmsg := range helper.HandleExtra(&msg, b.General) {
@@ -0,0 +1,66 @@
+package bridge
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the connection state of a bridge.
+type State string
+
+const (
+	StateConnecting   State = "connecting"
+	StateConnected    State = "connected"
+	StateReconnecting State = "reconnecting"
+	StateFailed       State = "failed"
+	StateDisabled     State = "disabled"
+)
+
+// Status describes the connection state of a bridge.
+type Status struct {
+	Account     string    `json:"account"`
+	State       State     `json:"state"`
+	Since       time.Time `json:"since"`
+	LastConnect time.Time `json:"last_connect"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+type stateTracker struct {
+	sync.Mutex
+	status Status
+}
+
+// SetState changes the state of the bridge, err is recorded as the last error if not nil.
+// Returns true if the state changed.
+func (b *Bridge) SetState(state State, err error) bool {
+	if b.state == nil {
+		return false
+	}
+	b.state.Lock()
+	defer b.state.Unlock()
+	s := &b.state.status
+	if err != nil {
+		s.LastError = err.Error()
+	}
+	if s.State == state {
+		return false
+	}
+	s.State = state
+	s.Since = time.Now()
+	if state == StateConnected {
+		s.LastConnect = s.Since
+	}
+	return true
+}
+
+// Status returns the current status of the bridge.
+func (b *Bridge) Status() Status {
+	if b.state == nil {
+		return Status{Account: b.Account, State: StateDisabled}
+	}
+	b.state.Lock()
+	defer b.state.Unlock()
+	s := b.state.status
+	s.Account = b.Account
+	return s
+}
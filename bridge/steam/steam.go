@@ -57,11 +57,12 @@ func (b *Bsteam) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// Capabilities returns what steam supports, it only has plain text.
+func (b *Bsteam) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Files: true}
+}
+
 func (b *Bsteam) Send(msg config.Message) (string, error) {
-	// ignore delete messages
-	if msg.Event == config.EventMsgDelete {
-		return "", nil
-	}
 	id, err := steamid.NewId(msg.Channel)
 	if err != nil {
 		return "", err
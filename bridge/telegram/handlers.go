@@ -10,6 +10,7 @@ import (
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	"github.com/davecgh/go-spew/spew"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -116,6 +117,8 @@ func (b *Btelegram) handleQuoting(rmsg *config.Message, message *tgbotapi.Messag
 				quote = message.ReplyToMessage.Caption
 			}
 			rmsg.Text = b.handleQuote(rmsg.Text, usernameReply, quote)
+			// so the gateway doesn't quote it again
+			rmsg.Quote = &config.Quote{Username: usernameReply, Text: quote, InText: true}
 		}
 	}
 }
@@ -124,6 +127,7 @@ func (b *Btelegram) handleQuoting(rmsg *config.Message, message *tgbotapi.Messag
 func (b *Btelegram) handleUsername(rmsg *config.Message, message *tgbotapi.Message) {
 	if message.From != nil {
 		rmsg.UserID = strconv.FormatInt(message.From.ID, 10)
+		b.addMember(rmsg.Channel, message.From)
 		if b.GetBool("UseFirstName") {
 			rmsg.Username = message.From.FirstName
 		}
@@ -452,9 +456,7 @@ func (b *Btelegram) handleUploadFile(msg *config.Message, chatid int64, parentID
 			Bytes: *fi.Data,
 		}
 
-		if b.GetString("MessageFormat") == HTMLFormat {
-			fi.Comment = makeHTML(html.EscapeString(fi.Comment))
-		}
+		fi.Comment = b.formatText(richtext.ParseMarkdown(fi.Comment), fi.Comment)
 
 		switch filepath.Ext(fi.Name) {
 		case ".jpg", ".jpe", ".png":
@@ -515,66 +517,54 @@ func (b *Btelegram) handleQuote(message, quoteNick, quoteMessage string) string
 	return format
 }
 
-// handleEntities handles messageEntities
+// handleEntities converts the formatting entities of the message to rich text
 func (b *Btelegram) handleEntities(rmsg *config.Message, message *tgbotapi.Message) {
 	if message.Entities == nil {
 		return
 	}
 
-	indexMovedBy := 0
-	prevLinkOffset := -1
+	// entities are in UTF-16 code units
+	text := utf16.Encode([]rune(rmsg.Text))
+	runeOffset := func(offset int) int {
+		return len(utf16.Decode(text[:offset]))
+	}
 
+	var spans []richtext.Span
 	for _, e := range message.Entities {
-
-		asRunes := utf16.Encode([]rune(rmsg.Text))
-
-		if e.Type == "text_link" {
-			offset := e.Offset + indexMovedBy
-			url, err := e.ParseURL()
-			if err != nil {
-				b.Log.Errorf("entity text_link url parse failed: %s", err)
-				continue
-			}
-			utfEncodedString := utf16.Encode([]rune(rmsg.Text))
-			if offset+e.Length > len(utfEncodedString) {
-				b.Log.Errorf("entity length is too long %d > %d", offset+e.Length, len(utfEncodedString))
+		if e.Offset+e.Length > len(text) {
+			b.Log.Errorf("entity length is too long %d > %d", e.Offset+e.Length, len(text))
+			continue
+		}
+		span := richtext.Span{Start: runeOffset(e.Offset), End: runeOffset(e.Offset + e.Length)}
+		switch e.Type {
+		case "bold":
+			span.Node.Kind = richtext.Bold
+		case "italic":
+			span.Node.Kind = richtext.Italic
+		case "strikethrough":
+			span.Node.Kind = richtext.Strike
+		case "spoiler":
+			span.Node.Kind = richtext.Spoiler
+		case "code":
+			span.Node.Kind = richtext.Code
+		case "pre":
+			span.Node = richtext.Node{Kind: richtext.Pre, Lang: e.Language}
+		case "blockquote":
+			span.Node.Kind = richtext.Quote
+		case "text_link":
+			span.Node = richtext.Node{Kind: richtext.Link, URL: e.URL}
+		case "mention":
+			span.Node.Kind = richtext.Mention
+		case "text_mention":
+			if e.User == nil {
 				continue
 			}
-			rmsg.Text = string(utf16.Decode(asRunes[:offset+e.Length])) + " (" + url.String() + ")" + string(utf16.Decode(asRunes[offset+e.Length:]))
-			indexMovedBy += len(url.String()) + 3
-			prevLinkOffset = e.Offset
-		}
-
-		if e.Offset == prevLinkOffset {
+			b.addMember(rmsg.Channel, e.User)
+			span.Node = richtext.Node{Kind: richtext.Mention, UserID: strconv.FormatInt(e.User.ID, 10)}
+		default:
 			continue
 		}
-
-		if e.Type == "code" {
-			offset := e.Offset + indexMovedBy
-			rmsg.Text = string(utf16.Decode(asRunes[:offset])) + "`" + string(utf16.Decode(asRunes[offset:offset+e.Length])) + "`" + string(utf16.Decode(asRunes[offset+e.Length:]))
-			indexMovedBy += 2
-		}
-
-		if e.Type == "pre" {
-			offset := e.Offset + indexMovedBy
-			rmsg.Text = string(utf16.Decode(asRunes[:offset])) + "```\n" + string(utf16.Decode(asRunes[offset:offset+e.Length])) + "```\n" + string(utf16.Decode(asRunes[offset+e.Length:]))
-			indexMovedBy += 8
-		}
-
-		if e.Type == "bold" {
-			offset := e.Offset + indexMovedBy
-			rmsg.Text = string(utf16.Decode(asRunes[:offset])) + "*" + string(utf16.Decode(asRunes[offset:offset+e.Length])) + "*" + string(utf16.Decode(asRunes[offset+e.Length:]))
-			indexMovedBy += 2
-		}
-		if e.Type == "italic" {
-			offset := e.Offset + indexMovedBy
-			rmsg.Text = string(utf16.Decode(asRunes[:offset])) + "_" + string(utf16.Decode(asRunes[offset:offset+e.Length])) + "_" + string(utf16.Decode(asRunes[offset+e.Length:]))
-			indexMovedBy += 2
-		}
-		if e.Type == "strike" {
-			offset := e.Offset + indexMovedBy
-			rmsg.Text = string(utf16.Decode(asRunes[:offset])) + "~" + string(utf16.Decode(asRunes[offset:offset+e.Length])) + "~" + string(utf16.Decode(asRunes[offset+e.Length:]))
-			indexMovedBy += 2
-		}
+		spans = append(spans, span)
 	}
+	rmsg.SetRichText(richtext.FromSpans(rmsg.Text, spans))
 }
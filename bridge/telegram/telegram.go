@@ -6,10 +6,12 @@ import (
 	"log"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
@@ -24,6 +26,10 @@ type Btelegram struct {
 	c *tgbotapi.BotAPI
 	*bridge.Config
 	avatarMap map[string]string // keep cache of userid and avatar sha
+
+	// members are the users seen in the chats, bots can't get the members of chats.
+	members      map[string]config.ChannelMember
+	membersMutex sync.RWMutex
 }
 
 func New(cfg *bridge.Config) bridge.Bridger {
@@ -37,7 +43,7 @@ func New(cfg *bridge.Config) bridge.Bridger {
 			log.Fatalf("Telegram bridge configured to convert .tgs files to '%s', but %s doesn't support it.", tgsConvertFormat, helper.LottieBackend())
 		}
 	}
-	return &Btelegram{Config: cfg, avatarMap: make(map[string]string)}
+	return &Btelegram{Config: cfg, avatarMap: make(map[string]string), members: make(map[string]config.ChannelMember)}
 }
 
 func (b *Btelegram) Connect() error {
@@ -86,9 +92,39 @@ func TGGetParseMode(b *Btelegram, username string, text string) (textout string,
 	return textout, parsemode
 }
 
+// formatText returns doc in the MessageFormat of the bridge, or text if that's
+// not a format that formatting is converted to.
+func (b *Btelegram) formatText(doc richtext.Document, text string) string {
+	switch b.GetString("MessageFormat") {
+	case HTMLFormat:
+		return richtext.TelegramHTML(doc)
+	case MarkdownV2:
+		return richtext.TelegramMarkdownV2(doc)
+	}
+	return text
+}
+
+// Capabilities returns what telegram supports.
+func (b *Btelegram) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{
+		Edits:      true,
+		Deletes:    true,
+		Threads:    true,
+		Files:      true,
+		Avatars:    true,
+		Members:    true,
+		Formatting: true,
+	}
+}
+
 func (b *Btelegram) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
+	if msg.Event == config.EventGetChannelMembers {
+		b.Remote <- helper.ChannelMembersMessage(b.Account, b.getChannelMembers())
+		return "", nil
+	}
+
 	// get the chatid
 	chatid, err := strconv.ParseInt(msg.Channel, 10, 64)
 	if err != nil {
@@ -100,21 +136,19 @@ func (b *Btelegram) Send(msg config.Message) (string, error) {
 		return b.cacheAvatar(&msg)
 	}
 
-	if b.GetString("MessageFormat") == HTMLFormat {
-		msg.Text = makeHTML(html.EscapeString(msg.Text))
+	// Handle prefix hint for unthreaded messages.
+	if msg.ParentNotFound() {
+		msg.ParentID = ""
+		msg.Text = fmt.Sprintf("[reply]: %s", msg.Text)
 	}
 
+	msg.Text = b.formatText(msg.RichText(), msg.Text)
+
 	// Delete message
 	if msg.Event == config.EventMsgDelete {
 		return b.handleDelete(&msg, chatid)
 	}
 
-	// Handle prefix hint for unthreaded messages.
-	if msg.ParentNotFound() {
-		msg.ParentID = ""
-		msg.Text = fmt.Sprintf("[reply]: %s", msg.Text)
-	}
-
 	var parentID int
 	if msg.ParentID != "" {
 		parentID, _ = b.intParentID(msg.ParentID)
@@ -203,3 +237,28 @@ func (b *Btelegram) cacheAvatar(msg *config.Message) (string, error) {
 	}
 	return "", nil
 }
+
+// addMember remembers user as a member of the chat channel.
+func (b *Btelegram) addMember(channel string, user *tgbotapi.User) {
+	id := strconv.FormatInt(user.ID, 10)
+	b.membersMutex.Lock()
+	defer b.membersMutex.Unlock()
+	b.members[channel+" "+id] = config.ChannelMember{
+		Username:    user.UserName,
+		Nick:        strings.TrimSpace(user.FirstName + " " + user.LastName),
+		UserID:      id,
+		ChannelID:   channel,
+		ChannelName: channel,
+	}
+}
+
+// getChannelMembers returns the users seen in the chats.
+func (b *Btelegram) getChannelMembers() config.ChannelMembers {
+	b.membersMutex.RLock()
+	defer b.membersMutex.RUnlock()
+	members := make(config.ChannelMembers, 0, len(b.members))
+	for _, member := range b.members {
+		members = append(members, member)
+	}
+	return members
+}
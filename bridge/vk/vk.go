@@ -81,6 +81,11 @@ func (b *Bvk) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// Capabilities returns what vk supports, it has no formatting.
+func (b *Bvk) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Edits: true, Files: true}
+}
+
 func (b *Bvk) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
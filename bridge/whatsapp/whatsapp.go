@@ -275,6 +275,12 @@ func (b *Bwhatsapp) PostImageMessage(msg config.Message, filetype string) (strin
 	return message.Info.Id, err
 }
 
+// Capabilities returns what whatsapp supports, messages can't be edited so edits
+// are sent as new messages marked with " (edited)".
+func (b *Bwhatsapp) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Deletes: true, Files: true, Formatting: true, EditSuffix: " (edited)"}
+}
+
 // Send a message from the bridge to WhatsApp
 // Required implementation of the Bridger interface
 // https://github.com/42wim/matterbridge/blob/2cfd880cdb0df29771bf8f31df8d990ab897889d/bridge/bridge.go#L11-L16
@@ -294,17 +300,6 @@ func (b *Bwhatsapp) Send(msg config.Message) (string, error) {
 		return "", err
 	}
 
-	// Edit message
-	if msg.ID != "" {
-		b.Log.Debugf("updating message with id %s", msg.ID)
-
-		if b.GetString("editsuffix") != "" {
-			msg.Text += b.GetString("EditSuffix")
-		} else {
-			msg.Text += " (edited)"
-		}
-	}
-
 	// Handle Upload a file
 	if msg.Extra["file"] != nil {
 		fi := msg.Extra["file"][0].(config.FileInfo)
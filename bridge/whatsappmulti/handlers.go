@@ -9,6 +9,7 @@ import (
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
 
 	"go.mau.fi/whatsmeow/binary/proto"
 	"go.mau.fi/whatsmeow/types"
@@ -32,6 +33,14 @@ func (b *Bwhatsapp) handleMessage(message *events.Message) {
 
 	b.Log.Infof("Receiving message %#v", msg)
 
+	if msg.ReactionMessage != nil {
+		b.handleReactionMessage(message.Info, msg.GetReactionMessage())
+
+		return
+	}
+
+	b.senders.Add(message.Info.ID, message.Info.Sender)
+
 	switch {
 	case msg.Conversation != nil || msg.ExtendedTextMessage != nil:
 		b.handleTextMessage(message.Info, msg)
@@ -61,7 +70,10 @@ func (b *Bwhatsapp) handleTextMessage(messageInfo types.MessageInfo, msg *proto.
 		return
 	}
 
-	var text string
+	var (
+		text string
+		doc  richtext.Document
+	)
 
 	// nolint:nestif
 	if msg.GetExtendedTextMessage() == nil {
@@ -76,18 +88,26 @@ func (b *Bwhatsapp) handleTextMessage(messageInfo types.MessageInfo, msg *proto.
 
 		if ci.MentionedJid != nil {
 			// handle user mentions
+			mentions := make(map[string]richtext.Node)
 			for _, mentionedJID := range ci.MentionedJid {
 				numberAndSuffix := strings.SplitN(mentionedJID, "@", 2)
+				jid := types.NewJID(numberAndSuffix[0], types.DefaultUserServer)
 
 				// mentions comes as telephone numbers and we don't want to expose it to other bridges
 				// replace it with something more meaninful to others
-				mention := b.getSenderNotify(types.NewJID(numberAndSuffix[0], types.DefaultUserServer))
+				mention := b.getSenderNotify(jid)
 				if mention == "" {
 					mention = "someone"
 				}
 
-				text = strings.Replace(text, "@"+numberAndSuffix[0], "@"+mention, 1)
+				mentions[numberAndSuffix[0]] = richtext.Node{Kind: richtext.Mention, UserID: jid.String(), Text: mention}
 			}
+			doc = richtext.ParseMarkdown(text).MapMentions(func(n richtext.Node) richtext.Node {
+				if mention, ok := mentions[n.Text]; ok {
+					return mention
+				}
+				return n
+			})
 		}
 	}
 
@@ -103,6 +123,10 @@ func (b *Bwhatsapp) handleTextMessage(messageInfo types.MessageInfo, msg *proto.
 		ID: messageInfo.ID,
 	}
 
+	if doc != nil {
+		rmsg.SetRichText(doc)
+	}
+
 	if avatarURL, exists := b.userAvatars[senderJID.String()]; exists {
 		rmsg.Avatar = avatarURL
 	}
@@ -113,6 +137,37 @@ func (b *Bwhatsapp) handleTextMessage(messageInfo types.MessageInfo, msg *proto.
 	b.Remote <- rmsg
 }
 
+// handleReactionMessage relays a reaction, reactions without an emoji are removed reactions.
+func (b *Bwhatsapp) handleReactionMessage(messageInfo types.MessageInfo, reaction *proto.ReactionMessage) {
+	senderName := b.getSenderName(messageInfo.Sender)
+	if senderName == "" {
+		senderName = "Someone" // don't expose telephone number
+	}
+
+	rmsg := config.Message{
+		UserID:   messageInfo.Sender.String(),
+		Username: senderName,
+		Channel:  messageInfo.Chat.String(),
+		Account:  b.Account,
+		Protocol: b.Protocol,
+		Event:    config.EventReaction,
+		Reaction: &config.Reaction{
+			Emoji:    reaction.GetText(),
+			TargetID: reaction.GetKey().GetId(),
+			Removed:  reaction.GetText() == "",
+		},
+	}
+
+	if avatarURL, exists := b.userAvatars[messageInfo.Sender.String()]; exists {
+		rmsg.Avatar = avatarURL
+	}
+
+	b.Log.Debugf("<= Sending reaction from %s on %s to gateway", messageInfo.Sender, b.Account)
+	b.Log.Debugf("<= Message is %#v", rmsg)
+
+	b.Remote <- rmsg
+}
+
 // HandleImageMessage sent from WhatsApp, relay it to the brige
 func (b *Bwhatsapp) handleImageMessage(msg *events.Message) {
 	imsg := msg.Message.GetImageMessage()
@@ -14,6 +14,7 @@ import (
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/mdp/qrterminal"
 
 	"go.mau.fi/whatsmeow"
@@ -40,6 +41,9 @@ type Bwhatsapp struct {
 	contacts    map[types.JID]types.ContactInfo
 	users       map[string]types.ContactInfo
 	userAvatars map[string]string
+	// senders are the senders of the received messages by message ID, reactions
+	// to messages of others need them.
+	senders *lru.Cache
 }
 
 // New Create a new WhatsApp bridge. This will be called for each [whatsapp.<server>] entry you have in the config file
@@ -56,6 +60,7 @@ func New(cfg *bridge.Config) bridge.Bridger {
 		users:       make(map[string]types.ContactInfo),
 		userAvatars: make(map[string]string),
 	}
+	b.senders, _ = lru.New(5000)
 
 	return b
 }
@@ -347,6 +352,12 @@ func (b *Bwhatsapp) PostAudioMessage(msg config.Message, filetype string) (strin
 	return ID, err
 }
 
+// Capabilities returns what whatsapp supports, messages can't be edited so edits
+// are sent as new messages marked with " (edited)".
+func (b *Bwhatsapp) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Deletes: true, Reactions: true, Files: true, Formatting: true, EditSuffix: " (edited)"}
+}
+
 // Send a message from the bridge to WhatsApp
 func (b *Bwhatsapp) Send(msg config.Message) (string, error) {
 	groupJID, _ := types.ParseJID(msg.Channel)
@@ -366,15 +377,8 @@ func (b *Bwhatsapp) Send(msg config.Message) (string, error) {
 		return "", err
 	}
 
-	// Edit message
-	if msg.ID != "" {
-		b.Log.Debugf("updating message with id %s", msg.ID)
-
-		if b.GetString("editsuffix") != "" {
-			msg.Text += b.GetString("EditSuffix")
-		} else {
-			msg.Text += " (edited)"
-		}
+	if msg.Event == config.EventReaction {
+		return b.sendReaction(&msg, groupJID)
 	}
 
 	// Handle Upload a file
@@ -411,3 +415,34 @@ func (b *Bwhatsapp) Send(msg config.Message) (string, error) {
 
 	return ID, err
 }
+
+// sendReaction sends the reaction of msg, reactions without an emoji remove the reaction.
+func (b *Bwhatsapp) sendReaction(msg *config.Message, groupJID types.JID) (string, error) {
+	key := &proto.MessageKey{
+		FromMe:    goproto.Bool(true),
+		Id:        goproto.String(msg.Reaction.TargetID),
+		RemoteJid: goproto.String(groupJID.String()),
+	}
+	if sender, ok := b.senders.Get(msg.Reaction.TargetID); ok {
+		key.FromMe = goproto.Bool(false)
+		key.Participant = goproto.String(sender.(types.JID).ToNonAD().String())
+	}
+
+	text := msg.Reaction.Emoji
+	if msg.Reaction.Removed {
+		text = ""
+	}
+
+	message := proto.Message{
+		ReactionMessage: &proto.ReactionMessage{
+			Key:               key,
+			Text:              goproto.String(text),
+			SenderTimestampMs: goproto.Int64(time.Now().UnixMilli()),
+		},
+	}
+
+	ID := whatsmeow.GenerateMessageID()
+	_, err := b.wc.SendMessage(context.TODO(), groupJID, ID, &message)
+
+	return ID, err
+}
@@ -26,6 +26,7 @@ type Bxmpp struct {
 	xc        *xmpp.Client
 	xmppMap   map[string]string
 	connected bool
+	stop      chan struct{} // closed by Disconnect to stop manageConnection from reconnecting
 	sync.RWMutex
 
 	avatarAvailability map[string]bool
@@ -49,12 +50,29 @@ func (b *Bxmpp) Connect() error {
 	}
 
 	b.Log.Info("Connection succeeded")
-	go b.manageConnection()
+	b.Lock()
+	b.stop = make(chan struct{})
+	b.Unlock()
+	go b.manageConnection(b.stop)
 	return nil
 }
 
 func (b *Bxmpp) Disconnect() error {
-	return nil
+	b.Lock()
+	if b.stop != nil {
+		close(b.stop)
+		b.stop = nil
+	}
+	b.Unlock()
+	if !b.Connected() {
+		return nil
+	}
+	for _, channel := range b.Channels {
+		if _, err := b.xc.LeaveMUC(channel.Name + "@" + b.GetString("Muc") + "/" + b.GetString("Nick")); err != nil {
+			b.Log.Errorf("Leaving %s failed: %s", channel.Name, err)
+		}
+	}
+	return b.xc.Close()
 }
 
 func (b *Bxmpp) JoinChannel(channel config.ChannelInfo) error {
@@ -67,16 +85,16 @@ func (b *Bxmpp) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// Capabilities returns what XMPP supports, edits are sent as message corrections.
+func (b *Bxmpp) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Edits: true, Files: true, Avatars: true, Formatting: true}
+}
+
 func (b *Bxmpp) Send(msg config.Message) (string, error) {
 	// should be fixed by using a cache instead of dropping
 	if !b.Connected() {
 		return "", fmt.Errorf("bridge %s not connected, dropping message %#v to bridge", b.Account, msg)
 	}
-	// ignore delete messages
-	if msg.Event == config.EventMsgDelete {
-		return "", nil
-	}
-
 	b.Log.Debugf("=> Receiving %#v", msg)
 
 	if msg.Event == config.EventAvatarDownload {
@@ -207,7 +225,7 @@ func (b *Bxmpp) createXMPP() error {
 	return err
 }
 
-func (b *Bxmpp) manageConnection() {
+func (b *Bxmpp) manageConnection(stop chan struct{}) {
 	b.setConnected(true)
 	initial := true
 	bf := &backoff.Backoff{
@@ -231,7 +249,14 @@ func (b *Bxmpp) manageConnection() {
 			}
 		}
 
-		if err := b.handleXMPP(); err != nil {
+		err := b.handleXMPP()
+		select {
+		case <-stop:
+			b.setConnected(false)
+			return
+		default:
+		}
+		if err != nil {
 			b.Log.WithError(err).Error("Disconnected.")
 			b.setConnected(false)
 		}
@@ -241,10 +266,21 @@ func (b *Bxmpp) manageConnection() {
 		for {
 			d := bf.Duration()
 			b.Log.Infof("Reconnecting in %s.", d)
-			time.Sleep(d)
+			select {
+			case <-stop:
+				return
+			case <-time.After(d):
+			}
 
 			b.Log.Infof("Reconnecting now.")
 			if err := b.createXMPP(); err == nil {
+				select {
+				case <-stop:
+					// disconnected while we were reconnecting
+					b.xc.Close()
+					return
+				default:
+				}
 				b.setConnected(true)
 				bf.Reset()
 				break
@@ -53,6 +53,11 @@ func (b *Bzulip) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
+// Capabilities returns what zulip supports.
+func (b *Bzulip) Capabilities() bridge.Capabilities {
+	return bridge.Capabilities{Edits: true, Deletes: true, Files: true, Formatting: true}
+}
+
 func (b *Bzulip) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
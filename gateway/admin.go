@@ -0,0 +1,286 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+type adminChannel struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Account   string `json:"account"`
+	Direction string `json:"direction"`
+}
+
+type adminGateway struct {
+	Name     string         `json:"name"`
+	Paused   bool           `json:"paused"`
+	Channels []adminChannel `json:"channels"`
+}
+
+type adminBridge struct {
+	Account  string                `json:"account"`
+	Protocol string                `json:"protocol"`
+	Gateways []string              `json:"gateways"`
+	Status   bridge.Status         `json:"status"`
+	Channels []string              `json:"channels"`
+	Members  config.ChannelMembers `json:"members"`
+}
+
+type adminMsgID struct {
+	Account   string `json:"account"`
+	ChannelID string `json:"channel_id"`
+	ID        string `json:"id"`
+}
+
+type adminMessage struct {
+	Gateway    string       `json:"gateway"`
+	Canonical  string       `json:"canonical"`
+	Inflight   bool         `json:"inflight"`
+	Downstream []adminMsgID `json:"downstream"`
+}
+
+// startAdmin serves the admin API on the AdminBindAddress, it needs an AdminToken.
+func (r *Router) startAdmin() {
+	general := r.BridgeValues().General
+	if general.AdminBindAddress == "" {
+		return
+	}
+	if general.AdminToken == "" {
+		r.logger.Errorf("AdminBindAddress is set without an AdminToken, not serving the admin API")
+		return
+	}
+	r.logger.Infof("Serving the admin API on http://%s/admin/", general.AdminBindAddress)
+	go func() {
+		if err := http.ListenAndServe(general.AdminBindAddress, r.adminHandler(general.AdminToken)); err != nil {
+			r.logger.Errorf("Serving the admin API failed: %s", err)
+		}
+	}()
+}
+
+// adminHandler returns the handler of the admin API, requests need token as bearer token.
+func (r *Router) adminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/gateways", r.handleAdminGateways)
+	mux.HandleFunc("/admin/gateways/", r.handleAdminGateway)
+	mux.HandleFunc("/admin/bridges", r.handleAdminBridges)
+	mux.HandleFunc("/admin/bridges/", r.handleAdminBridge)
+	mux.HandleFunc("/admin/messages", r.handleAdminMessages)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			adminError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+func adminJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func adminError(w http.ResponseWriter, code int, msg string) {
+	adminJSON(w, code, map[string]string{"error": msg})
+}
+
+// adminAction splits the path of a /admin/{kind}/{name}/{action} request. It fails the
+// request and returns false when it isn't a POST.
+func adminAction(w http.ResponseWriter, req *http.Request, prefix string) (string, string, bool) {
+	if req.Method != http.MethodPost {
+		adminError(w, http.StatusMethodNotAllowed, "use POST")
+		return "", "", false
+	}
+	path := strings.TrimPrefix(req.URL.Path, prefix)
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		adminError(w, http.StatusNotFound, "not found")
+		return "", "", false
+	}
+	return path[:i], path[i+1:], true
+}
+
+// handleAdminGateways lists the gateways with their channels.
+func (r *Router) handleAdminGateways(w http.ResponseWriter, req *http.Request) {
+	r.RLock()
+	res := []adminGateway{}
+	for _, gw := range r.Gateways {
+		g := adminGateway{Name: gw.Name, Paused: r.isPaused(gw.Name), Channels: []adminChannel{}}
+		for _, channel := range gw.Channels {
+			g.Channels = append(g.Channels, adminChannel{
+				ID:        channel.ID,
+				Name:      channel.Name,
+				Account:   channel.Account,
+				Direction: channel.Direction,
+			})
+		}
+		sort.Slice(g.Channels, func(i, j int) bool { return g.Channels[i].ID < g.Channels[j].ID })
+		res = append(res, g)
+	}
+	r.RUnlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	adminJSON(w, http.StatusOK, res)
+}
+
+// handleAdminGateway pauses or resumes a gateway with /admin/gateways/{name}/pause|resume.
+func (r *Router) handleAdminGateway(w http.ResponseWriter, req *http.Request) {
+	name, action, ok := adminAction(w, req, "/admin/gateways/")
+	if !ok {
+		return
+	}
+	if action != "pause" && action != "resume" {
+		adminError(w, http.StatusNotFound, "unknown action "+action)
+		return
+	}
+	r.RLock()
+	_, exists := r.Gateways[name]
+	r.RUnlock()
+	if !exists {
+		adminError(w, http.StatusNotFound, "unknown gateway "+name)
+		return
+	}
+	r.setPaused(name, action == "pause")
+	adminJSON(w, http.StatusOK, map[string]interface{}{"name": name, "paused": action == "pause"})
+}
+
+// handleAdminBridges lists the bridges with their state and channel members.
+func (r *Router) handleAdminBridges(w http.ResponseWriter, req *http.Request) {
+	r.RLock()
+	res := []adminBridge{}
+	for account, br := range r.bridges() {
+		b := adminBridge{
+			Account:  account,
+			Protocol: br.Protocol,
+			Gateways: []string{},
+			Status:   br.Status(),
+			Channels: []string{},
+			Members:  config.ChannelMembers{},
+		}
+		for _, gw := range r.Gateways {
+			if _, ok := gw.Bridges[account]; ok {
+				b.Gateways = append(b.Gateways, gw.Name)
+			}
+		}
+		sort.Strings(b.Gateways)
+		for _, channel := range br.Channels {
+			b.Channels = append(b.Channels, channel.Name)
+		}
+		sort.Strings(b.Channels)
+		br.Lock()
+		if br.ChannelMembers != nil {
+			b.Members = *br.ChannelMembers
+		}
+		br.Unlock()
+		res = append(res, b)
+	}
+	r.RUnlock()
+	// bridges that failed to start aren't in the gateways anymore
+	r.stateMu.Lock()
+	for account, status := range r.inactive {
+		found := false
+		for _, b := range res {
+			found = found || b.Account == account
+		}
+		if !found {
+			res = append(res, adminBridge{Account: account, Status: status})
+		}
+	}
+	r.stateMu.Unlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].Account < res[j].Account })
+	adminJSON(w, http.StatusOK, res)
+}
+
+// handleAdminBridge reconnects a bridge with /admin/bridges/{account}/reconnect.
+func (r *Router) handleAdminBridge(w http.ResponseWriter, req *http.Request) {
+	account, action, ok := adminAction(w, req, "/admin/bridges/")
+	if !ok {
+		return
+	}
+	if action != "reconnect" {
+		adminError(w, http.StatusNotFound, "unknown action "+action)
+		return
+	}
+	r.RLock()
+	err := r.reconnect(account)
+	r.RUnlock()
+	switch err {
+	case nil:
+	case errUnknownBridge:
+		adminError(w, http.StatusNotFound, "unknown bridge "+account)
+		return
+	case errReconnecting:
+		adminError(w, http.StatusConflict, account+" is already reconnecting")
+		return
+	default:
+		adminError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	r.logger.Infof("Reconnecting %s on request of the admin API", account)
+	adminJSON(w, http.StatusAccepted, map[string]string{"account": account})
+}
+
+// handleAdminMessages looks up the IDs a message was relayed with in the message
+// cache of the gateways. The message is given by its protocol and ID, on the
+// bridge it came from or on one it was relayed to.
+func (r *Router) handleAdminMessages(w http.ResponseWriter, req *http.Request) {
+	protocol, id := req.URL.Query().Get("protocol"), req.URL.Query().Get("id")
+	if protocol == "" || id == "" {
+		adminError(w, http.StatusBadRequest, "protocol and id are required")
+		return
+	}
+	r.RLock()
+	res := []adminMessage{}
+	for _, gw := range r.Gateways {
+		canonical := gw.FindCanonicalMsgID(protocol, id)
+		if canonical == "" {
+			continue
+		}
+		m := adminMessage{Gateway: gw.Name, Canonical: canonical, Downstream: []adminMsgID{}}
+		ids, ok := gw.Messages.Get(canonical)
+		if !ok {
+			ids, m.Inflight = gw.inflight.get(canonical)
+		}
+		for _, brID := range ids {
+			m.Downstream = append(m.Downstream, adminMsgID{
+				Account:   brID.br.Account,
+				ChannelID: brID.ChannelID,
+				ID:        brID.ID,
+			})
+		}
+		res = append(res, m)
+	}
+	r.RUnlock()
+	if len(res) == 0 {
+		adminError(w, http.StatusNotFound, "unknown message "+protocol+" "+id)
+		return
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Gateway < res[j].Gateway })
+	adminJSON(w, http.StatusOK, res)
+}
+
+// isPaused returns true if the gateway name is paused.
+func (r *Router) isPaused(name string) bool {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+	return r.paused[name]
+}
+
+func (r *Router) setPaused(name string, paused bool) {
+	r.stateMu.Lock()
+	defer r.stateMu.Unlock()
+	if paused {
+		r.paused[name] = true
+	} else {
+		delete(r.paused, name)
+	}
+	r.logger.Infof("Gateway %s paused: %t", name, paused)
+}
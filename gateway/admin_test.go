@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmin(t *testing.T) {
+	r, _ := maketestRouterWithBridgers(t, testconfig)
+	h := r.adminHandler("secret")
+	request := func(method, target string, res interface{}) int {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if res != nil {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), res))
+		}
+		return rec.Code
+	}
+
+	for _, auth := range []string{"", "secret", "Bearer other", "Basic secret"} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/gateways", nil)
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, auth)
+	}
+
+	var gateways []adminGateway
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/admin/gateways", &gateways))
+	require.Len(t, gateways, 1)
+	assert.Equal(t, "bridge1", gateways[0].Name)
+	assert.Contains(t, gateways[0].Channels, adminChannel{
+		ID: "generaldiscord.test", Name: "general", Account: "discord.test", Direction: "inout",
+	})
+
+	var bridges []adminBridge
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/admin/bridges", &bridges))
+	require.Len(t, bridges, 4)
+	assert.Equal(t, "discord.test", bridges[0].Account)
+	assert.Equal(t, []string{"bridge1"}, bridges[0].Gateways)
+	assert.Equal(t, "connected", string(bridges[0].Status.State))
+
+	// paused gateways don't relay messages
+	discord := r.getBridge("discord.test").Bridger.(*testBridger)
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/admin/gateways/bridge1/pause", nil))
+	dropped := metrics.MessagesDropped.Value("irc.freenode", metrics.DropPaused)
+	r.Message <- config.Message{Text: "paused", Channel: "#wimtesting", Account: "irc.freenode", ID: "1"}
+	assert.Eventually(t, func() bool {
+		return metrics.MessagesDropped.Value("irc.freenode", metrics.DropPaused) == dropped+1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/admin/gateways/bridge1/resume", nil))
+	r.Message <- config.Message{Text: "resumed", Channel: "#wimtesting", Account: "irc.freenode", ID: "2"}
+	assert.Eventually(t, func() bool { return len(discord.messages()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, "resumed", discord.messages()[0].Text)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/admin/gateways/unknown/pause", nil))
+
+	// the message cache can be looked up by the ID on any bridge
+	var msgs []adminMessage
+	assert.Eventually(t, func() bool {
+		return request(http.MethodGet, "/admin/messages?protocol=discord&id=1", nil) == http.StatusOK
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/admin/messages?protocol=irc&id=2", &msgs))
+	require.Len(t, msgs, 1)
+	assert.Equal(t, "irc 2", msgs[0].Canonical)
+	assert.Contains(t, msgs[0].Downstream, adminMsgID{Account: "discord.test", ChannelID: "generaldiscord.test", ID: "discord 1"})
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, "/admin/messages?protocol=irc&id=1", nil))
+
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/admin/bridges/unknown.test/reconnect", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodGet, "/admin/bridges/irc.freenode/reconnect", nil))
+
+	// a bridge is reconnected once at a time, and stopping ends the reconnect
+	irc := r.getBridge("irc.freenode").Bridger.(*testBridger)
+	assert.Equal(t, http.StatusAccepted, request(http.MethodPost, "/admin/bridges/irc.freenode/reconnect", nil))
+	assert.Equal(t, http.StatusConflict, request(http.MethodPost, "/admin/bridges/irc.freenode/reconnect", nil))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, r.Stop(ctx))
+	assert.Equal(t, 1, irc.disconnects)
+	assert.False(t, irc.connected)
+	assert.Equal(t, http.StatusServiceUnavailable, request(http.MethodPost, "/admin/bridges/irc.freenode/reconnect", nil))
+}
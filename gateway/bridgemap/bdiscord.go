@@ -8,5 +8,4 @@ import (
 
 func init() {
 	FullMap["discord"] = bdiscord.New
-	UserTypingSupport["discord"] = struct{}{}
 }
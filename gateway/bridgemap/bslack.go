@@ -9,5 +9,4 @@ import (
 func init() {
 	FullMap["slack-legacy"] = bslack.NewLegacy
 	FullMap["slack"] = bslack.New
-	UserTypingSupport["slack"] = struct{}{}
 }
@@ -0,0 +1,11 @@
+// +build !nogrpc
+
+package bridgemap
+
+import (
+	"github.com/42wim/matterbridge/bridge/api"
+)
+
+func init() {
+	FullMap["grpc"] = api.NewGRPC
+}
@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/richtext"
+)
+
+// isEdit returns true if msg changes the message with the canonical ID key that
+// was relayed before. It has to be called before msg is added as a delivery.
+func (gw *Gateway) isEdit(key string, msg *config.Message) bool {
+	if key == "" || (msg.Event != "" && msg.Event != config.EventUserAction) {
+		return false
+	}
+	return gw.FindCanonicalMsgID(msg.Protocol, msg.ID) != ""
+}
+
+// handleEdit sends the edit of job as a new message with the EditSuffix of the
+// destination when it can't edit messages. It returns false if the destination has
+// EditDisable set, it doesn't get the edit then.
+func handleEdit(job *sendJob) bool {
+	caps := job.dest.Capabilities()
+	if caps.Edits {
+		return true
+	}
+	if job.dest.GetBool("EditDisable") {
+		return false
+	}
+	msg := &job.rmsg
+	msg.ID = ""
+	suffix := job.dest.GetString("EditSuffix")
+	if suffix == "" {
+		suffix = caps.EditSuffix
+	}
+	// the bridge the edit came from can have added it already
+	if suffix == "" || strings.HasSuffix(msg.Text, suffix) {
+		return true
+	}
+	if msg.Document == nil {
+		msg.Text += suffix
+		return true
+	}
+	msg.SetRichText(append(msg.RichText(), richtext.Node{Kind: richtext.Text, Text: suffix}))
+	return true
+}
+
+// handleCapabilities changes msg to what dest can show: files it can't upload are
+// added as links, the formatting is removed and the text is clipped.
+func (gw *Gateway) handleCapabilities(msg *config.Message, dest *bridge.Bridge) {
+	caps := dest.Capabilities()
+	if !caps.Files && msg.Extra != nil && len(msg.Extra["file"]) > 0 {
+		addFileLinks(msg)
+	}
+	if !caps.Formatting && msg.Text != "" && (msg.Event == "" || msg.Event == config.EventUserAction) {
+		msg.Text = richtext.Plain(msg.RichText())
+		msg.Document = nil
+	}
+	if caps.MaxLength > 0 {
+		msg.Text = helper.ClipMessage(msg.Text, caps.MaxLength, dest.GetString("MessageClipped"))
+	}
+}
+
+// addFileLinks adds a line with the comment and URL, or the name, of every file of
+// msg to its text and removes the files.
+func addFileLinks(msg *config.Message) {
+	for _, f := range msg.Extra["file"] {
+		fi := f.(config.FileInfo)
+		link := fi.URL
+		if link == "" {
+			link = fi.Name
+		}
+		if fi.Comment != "" {
+			link = fi.Comment + " : " + link
+		}
+		if msg.Text != "" {
+			msg.Text += "\n"
+		}
+		msg.Text += link
+	}
+	extra := make(map[string][]interface{}, len(msg.Extra))
+	for k, v := range msg.Extra {
+		if k != "file" {
+			extra[k] = v
+		}
+	}
+	msg.Extra = extra
+}
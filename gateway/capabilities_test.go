@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var capabilitiesTestConfig = []byte(`
+[irc.freenode]
+server=""
+EditSuffix=" (edited)"
+[gitter.42wim]
+MessageClipped=" [...]"
+EditDisable=true
+[discord.test]
+server=""
+[slack.test]
+server=""
+
+[[gateway]]
+name = "bridge1"
+enable=true
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+    [[gateway.inout]]
+    account="gitter.42wim"
+    channel="42wim/testroom"
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+    [[gateway.inout]]
+    account="slack.test"
+    channel="testing"
+`)
+
+func TestCapabilities(t *testing.T) {
+	r, _ := maketestRouterWithBridgers(t, capabilitiesTestConfig)
+	irc := r.getBridge("irc.freenode").Bridger.(*testBridger)
+	gitter := r.getBridge("gitter.42wim").Bridger.(*testBridger)
+	slack := r.getBridge("slack.test").Bridger.(*testBridger)
+	gitter.setCapabilities(bridge.Capabilities{MaxLength: 24})
+	sent := func(toIRC, toGitter, toSlack int) func() bool {
+		return func() bool {
+			return len(irc.messages()) == toIRC && len(gitter.messages()) == toGitter && len(slack.messages()) == toSlack
+		}
+	}
+
+	r.Message <- config.Message{Username: "bob", Text: "**hello**", Channel: "general", Account: "discord.test", ID: "1"}
+	assert.Eventually(t, sent(1, 1, 1), time.Second, time.Millisecond)
+	// bridges without formatting get plain text
+	assert.Equal(t, "**hello**", irc.messages()[0].Text)
+	assert.Equal(t, "hello", gitter.messages()[0].Text)
+
+	// bridges that can't edit get the edit as a new message with their EditSuffix,
+	// unless they have EditDisable set
+	r.Message <- config.Message{Username: "bob", Text: "**hi**", Channel: "general", Account: "discord.test", ID: "1"}
+	assert.Eventually(t, sent(2, 1, 2), time.Second, time.Millisecond)
+	assert.Equal(t, "**hi** (edited)", irc.messages()[1].Text)
+	assert.Equal(t, "", irc.messages()[1].ID)
+	assert.Equal(t, "**hi**", slack.messages()[1].Text)
+	assert.Equal(t, "1", slack.messages()[1].ID)
+	// the suffix isn't added twice when the edit already has it
+	r.Message <- config.Message{Username: "bob", Text: "hey (edited)", Channel: "general", Account: "discord.test", ID: "1"}
+	assert.Eventually(t, sent(3, 1, 3), time.Second, time.Millisecond)
+	assert.Equal(t, "hey (edited)", irc.messages()[2].Text)
+
+	// deletes are only sent to bridges that can delete
+	r.Message <- config.Message{Event: config.EventMsgDelete, Text: config.EventMsgDelete, Channel: "general", Account: "discord.test", ID: "1"}
+	assert.Eventually(t, sent(3, 1, 4), time.Second, time.Millisecond)
+	assert.Equal(t, config.EventMsgDelete, slack.messages()[3].Event)
+
+	// files become links on bridges that can't upload them
+	file := config.FileInfo{Name: "cat.png", URL: "http://x/cat.png"}
+	r.Message <- config.Message{Text: "look", Channel: "general", Account: "discord.test", ID: "2",
+		Extra: map[string][]interface{}{"file": {file}}}
+	assert.Eventually(t, sent(4, 2, 5), time.Second, time.Millisecond)
+	assert.Equal(t, "look\nhttp://x/cat.png", gitter.messages()[1].Text)
+	assert.Empty(t, gitter.messages()[1].Extra["file"])
+	assert.Equal(t, "look", slack.messages()[4].Text)
+	assert.Equal(t, []interface{}{file}, slack.messages()[4].Extra["file"])
+
+	// and long texts are clipped
+	r.Message <- config.Message{Text: "this message is much too long", Channel: "general", Account: "discord.test", ID: "3"}
+	assert.Eventually(t, sent(5, 3, 6), time.Second, time.Millisecond)
+	assert.Equal(t, "this message is mu [...]", gitter.messages()[2].Text)
+	assert.Equal(t, "this message is much too long", slack.messages()[5].Text)
+}
@@ -0,0 +1,251 @@
+package gateway
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/internal/metrics"
+)
+
+// destQueueSize is the amount of messages that can be queued for a destination
+// bridge, the messages that don't fit are retried later or dropped.
+var destQueueSize = 100
+
+var errQueueFull = errors.New("the send queue of the bridge is full")
+
+// sendJob is a message queued for delivery to a channel of a destination bridge.
+type sendJob struct {
+	gw       *Gateway
+	rmsg     config.Message
+	dest     *bridge.Bridge
+	channel  config.ChannelInfo
+	delivery *delivery
+	retry    *retryEntry
+}
+
+// delivery tracks a message that is being sent to all its destinations.
+// The message IDs of the destinations are added to the message store once every
+// send has reported back.
+type delivery struct {
+	gw        *Gateway
+	key       string
+	store     bool
+	remaining int
+	ids       []*BrMsgID
+}
+
+// inflight keeps the deliveries that haven't finished yet, so that edits and
+// replies sent right after a message can find the IDs it already got.
+type inflight struct {
+	sync.Mutex
+
+	deliveries map[string]*delivery
+	index      msgIndex
+}
+
+func newInflight() *inflight {
+	return &inflight{
+		deliveries: make(map[string]*delivery),
+		index:      make(msgIndex),
+	}
+}
+
+// get returns the message IDs a message being delivered got so far.
+func (f *inflight) get(key string) ([]*BrMsgID, bool) {
+	f.Lock()
+	defer f.Unlock()
+	d, ok := f.deliveries[key]
+	if !ok {
+		return nil, false
+	}
+	return append([]*BrMsgID(nil), d.ids...), true
+}
+
+// canonical returns the canonical ID of a message being delivered that got downstreamID.
+func (f *inflight) canonical(downstreamID string) (string, bool) {
+	f.Lock()
+	defer f.Unlock()
+	key, ok := f.index[downstreamID]
+	return key, ok
+}
+
+// newDelivery starts tracking a message with the specified canonical ID that is sent in jobs sends.
+// Only the first message with a canonical ID gets its message IDs stored, as edits of messages
+// have the same canonical ID.
+func (gw *Gateway) newDelivery(key string, jobs int) *delivery {
+	d := &delivery{gw: gw, key: key, remaining: jobs}
+	if key == "" {
+		return d
+	}
+	if _, exists := gw.Messages.Get(key); exists {
+		return d
+	}
+	gw.inflight.Lock()
+	defer gw.inflight.Unlock()
+	if _, exists := gw.inflight.deliveries[key]; exists {
+		return d
+	}
+	d.store = true
+	if jobs == 0 {
+		gw.Messages.Add(key, nil)
+		return d
+	}
+	gw.inflight.deliveries[key] = d
+	return d
+}
+
+// report is called when a send of the delivery has finished, id is nil if the
+// destination didn't return a message ID.
+func (d *delivery) report(id *BrMsgID) {
+	f := d.gw.inflight
+	f.Lock()
+	defer f.Unlock()
+	if id != nil {
+		d.ids = append(d.ids, id)
+		if d.store {
+			f.index.add(d.key, id.ID)
+		}
+	}
+	d.remaining--
+	if d.remaining > 0 || !d.store {
+		return
+	}
+	d.gw.Messages.Add(d.key, d.ids)
+	f.index.remove(d.key, downstreamIDs(d.ids)...)
+	delete(f.deliveries, d.key)
+}
+
+// enqueue queues the job for the worker of its destination bridge, starting the worker if needed.
+// It never blocks, a job that doesn't fit in the queue of the destination is handled as a failed
+// send. Jobs are dropped once the router stops.
+func (r *Router) enqueue(job *sendJob) {
+	r.queuesMu.Lock()
+	if r.stopping {
+		r.queuesMu.Unlock()
+		switch {
+		case job.retry != nil:
+			r.getRetryQueue(job.dest.Account).add(job.retry)
+		case job.delivery != nil:
+			job.delivery.report(nil)
+		}
+		return
+	}
+	r.sending.Add(1)
+	queue, ok := r.queues[job.dest.Account]
+	if !ok {
+		queue = make(chan *sendJob, destQueueSize)
+		r.queues[job.dest.Account] = queue
+		go r.sendWorker(queue)
+	}
+	r.queuesMu.Unlock()
+	select {
+	case queue <- job:
+	default:
+		r.sending.Done()
+		r.overflow(job)
+	}
+}
+
+// overflow handles a job that didn't fit in the queue of its destination, so that
+// one slow bridge doesn't hold up the messages to all the others.
+func (r *Router) overflow(job *sendJob) {
+	if !job.gw.handleSendFailure(job, errQueueFull) {
+		metrics.MessagesDropped.Inc(job.dest.Account, metrics.DropQueueFull)
+	}
+	if job.retry == nil && job.delivery != nil {
+		job.delivery.report(nil)
+	}
+}
+
+// sendWorker sends the jobs queued for a destination bridge in order.
+func (r *Router) sendWorker(queue chan *sendJob) {
+	for job := range queue {
+		job.gw.deliver(job)
+		r.sending.Done()
+	}
+}
+
+// deliver sends the message of the job and reports the resulting message ID to its delivery.
+// The maps of the gateway of the job don't change anymore, but a reload can replace its
+// destination bridge, so that is looked up again.
+func (gw *Gateway) deliver(job *sendJob) {
+	if !gw.Router.retarget(job) {
+		gw.logger.Debugf("=> Dropping message to %s (%s), it was removed by a reload", job.dest.Account, job.channel.Name)
+		metrics.MessagesDropped.Inc(job.dest.Account, metrics.DropRemoved)
+		if job.retry == nil && job.delivery != nil {
+			job.delivery.report(nil)
+		}
+		return
+	}
+
+	// Get the ID of the parent message in thread
+	var canonicalParentMsgID string
+	if job.rmsg.ParentID != "" && job.dest.GetBool("PreserveThreading") {
+		canonicalParentMsgID = gw.FindCanonicalMsgID(job.rmsg.Protocol, job.rmsg.ParentID)
+	}
+
+	// keep the job's message untouched in case we need to retry
+	rmsg := copyMessage(&job.rmsg)
+	var id *BrMsgID
+	msgID, err := gw.SendMessage(&rmsg, job.dest, &job.channel, canonicalParentMsgID)
+	if err != nil {
+		gw.handleSendFailure(job, err)
+	} else if msgID != "" {
+		id = &BrMsgID{job.dest, job.dest.Protocol + " " + msgID, job.channel.ID}
+	}
+
+	if job.retry != nil {
+		if id != nil && job.retry.Key != "" {
+			gw.addMsgID(job.retry.Key, id)
+		}
+		return
+	}
+	job.delivery.report(id)
+}
+
+// retarget points the job to the bridge of its destination account in the running
+// gateway with the name of the gateway of the job. It returns false if there's none,
+// or if that gateway doesn't have the channel of the job.
+func (r *Router) retarget(job *sendJob) bool {
+	r.RLock()
+	defer r.RUnlock()
+	gw := r.Gateways[job.gw.Name]
+	if gw == nil {
+		return false
+	}
+	dest := gw.Bridges[job.dest.Account]
+	if _, ok := gw.Channels[job.channel.ID]; dest == nil || !ok {
+		return false
+	}
+	job.dest = dest
+	return true
+}
+
+// addMsgID adds the message ID of a send that succeeded after a retry to the IDs of key.
+func (gw *Gateway) addMsgID(key string, id *BrMsgID) {
+	f := gw.inflight
+	f.Lock()
+	defer f.Unlock()
+	if d, ok := f.deliveries[key]; ok {
+		d.ids = append(d.ids, id)
+		f.index.add(key, id.ID)
+		return
+	}
+	ids, _ := gw.Messages.Get(key)
+	gw.Messages.Add(key, append(append([]*BrMsgID(nil), ids...), id))
+}
+
+// copyMessage returns a copy of msg that can be modified without changing msg.
+// The Extra map is copied as well because some bridges modify it when sending.
+func copyMessage(msg *config.Message) config.Message {
+	res := *msg
+	if msg.Extra != nil {
+		res.Extra = make(map[string][]interface{}, len(msg.Extra))
+		for k, v := range msg.Extra {
+			res.Extra[k] = v
+		}
+	}
+	return res
+}
@@ -0,0 +1,185 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelivery(t *testing.T) {
+	r, _ := maketestRouterWithBridgers(t, testconfig)
+	gw := r.Gateways["bridge1"]
+	discord := r.getBridge("discord.test").Bridger.(*testBridger)
+	slack := r.getBridge("slack.test").Bridger.(*testBridger)
+	gitter := r.getBridge("gitter.42wim").Bridger.(*testBridger)
+
+	// a blocked destination doesn't hold up the others
+	discord.block = make(chan struct{})
+	for i := 1; i <= 20; i++ {
+		r.Message <- config.Message{Text: "msg" + strconv.Itoa(i), Channel: "#wimtesting", Account: "irc.freenode", ID: strconv.Itoa(i)}
+	}
+	assert.Eventually(t, func() bool { return len(slack.messages()) == 20 }, time.Second, time.Millisecond)
+	assert.Empty(t, discord.messages())
+	_, ok := gw.Messages.Get("irc 1")
+	assert.False(t, ok, "message IDs are stored when all sends are done")
+
+	// an edit sent while the original is still being delivered finds its ID
+	r.Message <- config.Message{Text: "edited", Channel: "#wimtesting", Account: "irc.freenode", ID: "1"}
+	assert.Eventually(t, func() bool { return len(gitter.messages()) == 21 }, time.Second, time.Millisecond)
+	assert.Equal(t, "1", gitter.messages()[20].ID)
+
+	close(discord.block)
+	assert.Eventually(t, func() bool { return len(discord.messages()) == 21 }, time.Second, time.Millisecond)
+	for i, msg := range discord.messages()[:20] {
+		assert.Equal(t, "msg"+strconv.Itoa(i+1), msg.Text)
+	}
+	assert.Eventually(t, func() bool {
+		ids, ok := gw.Messages.Get("irc 1")
+		return ok && len(ids) == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "irc 20", gw.FindCanonicalMsgID("discord", "20"))
+}
+
+func TestQueueFull(t *testing.T) {
+	defer func(size int) { destQueueSize = size }(destQueueSize)
+	destQueueSize = 2
+	r, _ := maketestRouterWithBridgers(t, testconfig)
+	gw := r.Gateways["bridge1"]
+	discord := r.getBridge("discord.test").Bridger.(*testBridger)
+	slack := r.getBridge("slack.test").Bridger.(*testBridger)
+
+	// a full queue doesn't hold up the other destinations
+	discord.block = make(chan struct{})
+	for i := 1; i <= 5; i++ {
+		r.Message <- config.Message{Text: "msg" + strconv.Itoa(i), Channel: "#wimtesting", Account: "irc.freenode", ID: strconv.Itoa(i)}
+		assert.Eventually(t, func() bool { return len(slack.messages()) == i }, time.Second, time.Millisecond)
+	}
+
+	// the messages that didn't fit are dropped without retries
+	close(discord.block)
+	assert.Eventually(t, func() bool {
+		for i := 1; i <= 5; i++ {
+			if _, ok := gw.Messages.Get("irc " + strconv.Itoa(i)); !ok {
+				return false
+			}
+		}
+		return true
+	}, time.Second, time.Millisecond)
+	assert.LessOrEqual(t, len(discord.messages()), 3)
+	assert.Equal(t, "msg1", discord.messages()[0].Text)
+}
+
+func TestRetry(t *testing.T) {
+	deadLetters := filepath.Join(t.TempDir(), "deadletters")
+	input := append([]byte("[general]\nRetryMaxAttempts=3\nDeadLetterFile=\""+deadLetters+"\"\n"), testconfig...)
+	r, _ := maketestRouterWithBridgers(t, input)
+	gw := r.Gateways["bridge1"]
+	slack := r.getBridge("slack.test").Bridger.(*testBridger)
+	queue := r.getRetryQueue("slack.test")
+
+	// a failed send is retried after a reconnect and gets its ID stored
+	slack.setFail(errors.New("disconnected"))
+	r.Message <- config.Message{Text: "hello", Channel: "#wimtesting", Account: "irc.freenode", ID: "1"}
+	assert.Eventually(t, func() bool {
+		ids, ok := gw.Messages.Get("irc 1")
+		return ok && len(ids) == 2
+	}, time.Second, time.Millisecond)
+	slack.setFail(nil)
+	queue.flush()
+	assert.Eventually(t, func() bool {
+		ids, _ := gw.Messages.Get("irc 1")
+		return len(ids) == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "irc 1", gw.FindCanonicalMsgID("slack", "1"))
+
+	// after RetryMaxAttempts the message is dead-lettered
+	slack.setFail(errors.New("disconnected"))
+	r.Message <- config.Message{Text: "lost", Channel: "#wimtesting", Account: "irc.freenode", ID: "2"}
+	queued := func(attempts int) func() bool {
+		return func() bool {
+			queue.Lock()
+			defer queue.Unlock()
+			return len(queue.entries) == 1 && queue.entries[0].Attempts == attempts
+		}
+	}
+	assert.Eventually(t, queued(1), time.Second, time.Millisecond)
+	queue.flush()
+	assert.Eventually(t, queued(2), time.Second, time.Millisecond)
+	queue.flush()
+	assert.Eventually(t, func() bool {
+		data, err := ioutil.ReadFile(deadLetters)
+		return err == nil && strings.Contains(string(data), `"text":"lost"`)
+	}, time.Second, time.Millisecond)
+}
+
+func TestStop(t *testing.T) {
+	r, _ := maketestRouterWithBridgers(t, testconfig)
+	discord := r.getBridge("discord.test").Bridger.(*testBridger)
+
+	// queued messages are sent before the bridges are disconnected
+	discord.block = make(chan struct{})
+	for i := 1; i <= 5; i++ {
+		r.Message <- config.Message{Text: "msg" + strconv.Itoa(i), Channel: "#wimtesting", Account: "irc.freenode", ID: strconv.Itoa(i)}
+	}
+	time.AfterFunc(50*time.Millisecond, func() { close(discord.block) })
+	require.NoError(t, r.Stop(context.Background()))
+	assert.Len(t, discord.messages(), 5)
+	for _, br := range r.bridges() {
+		assert.False(t, br.Bridger.(*testBridger).connected)
+	}
+
+	// new messages are ignored
+	r.Message <- config.Message{Text: "late", Channel: "#wimtesting", Account: "irc.freenode", ID: "6"}
+	time.Sleep(10 * time.Millisecond)
+	assert.Len(t, discord.messages(), 5)
+
+	// the deadline is respected
+	r, _ = maketestRouterWithBridgers(t, testconfig)
+	r.getBridge("discord.test").Bridger.(*testBridger).block = make(chan struct{})
+	r.Message <- config.Message{Text: "stuck", Channel: "#wimtesting", Account: "irc.freenode", ID: "1"}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, r.Stop(ctx))
+}
+
+func TestDeliverAfterReload(t *testing.T) {
+	r, cfg := maketestRouterWithBridgers(t, testconfig)
+	gw := r.Gateways["bridge1"]
+	discord := r.getBridge("discord.test")
+	gitter := r.getBridge("gitter.42wim")
+	job := func(dest string) *sendJob {
+		return &sendJob{
+			gw:       gw,
+			rmsg:     config.Message{Text: "hi", Username: "joe", Channel: "#wimtesting", Account: "irc.freenode"},
+			dest:     r.getBridge(dest),
+			channel:  *gw.Channels[map[string]string{"discord.test": "general", "gitter.42wim": "42wim/testroom"}[dest]+dest],
+			delivery: gw.newDelivery("", 1),
+		}
+	}
+	toDiscord, toGitter := job("discord.test"), job("gitter.42wim")
+
+	// discord gets a new bridge and gitter is removed while the jobs are queued
+	changed := bytes.Replace(testconfig, []byte("[discord.test]\n"), []byte("[discord.test]\ntoken=\"other\"\n"), 1)
+	require.NoError(t, cfg.Viper().ReadConfig(bytes.NewBuffer(changed)))
+	inout := cfg.BridgeValues().Gateway[0].InOut
+	cfg.BridgeValues().Gateway[0].InOut = append(inout[:1:1], inout[2:]...)
+	require.NoError(t, r.Reload())
+	require.NotSame(t, discord, r.getBridge("discord.test"))
+
+	gw.deliver(toDiscord)
+	gw.deliver(toGitter)
+	assert.Empty(t, discord.Bridger.(*testBridger).messages())
+	assert.Len(t, r.getBridge("discord.test").Bridger.(*testBridger).messages(), 1)
+	assert.Empty(t, gitter.Bridger.(*testBridger).messages())
+	assert.Equal(t, 0, toGitter.delivery.remaining)
+}
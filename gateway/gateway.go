@@ -2,7 +2,6 @@ package gateway
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"regexp"
 	"strings"
@@ -10,9 +9,7 @@ import (
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
-	"github.com/42wim/matterbridge/internal"
-	"github.com/d5/tengo/v2"
-	"github.com/d5/tengo/v2/stdlib"
+	"github.com/42wim/matterbridge/internal/metrics"
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/kyokomi/emoji/v2"
 	"github.com/sirupsen/logrus"
@@ -28,9 +25,11 @@ type Gateway struct {
 	ChannelOptions map[string]config.ChannelOptions
 	Message        chan config.Message
 	Name           string
-	Messages       *lru.Cache
+	Messages       MessageStore
 
-	logger *logrus.Entry
+	logger   *logrus.Entry
+	inflight *inflight
+	excerpts *lru.Cache
 }
 
 type BrMsgID struct {
@@ -39,23 +38,42 @@ type BrMsgID struct {
 	ChannelID string
 }
 
-const apiProtocol = "api"
+const (
+	apiProtocol  = "api"
+	grpcProtocol = "grpc"
+)
 
 // New creates a new Gateway object associated with the specified router and
 // following the given configuration.
 func New(rootLogger *logrus.Logger, cfg *config.Gateway, r *Router) *Gateway {
+	return newGateway(rootLogger, cfg, r, nil)
+}
+
+// newGateway creates a new Gateway using the specified message store, or the
+// configured one if store is nil.
+func newGateway(rootLogger *logrus.Logger, cfg *config.Gateway, r *Router, store MessageStore) *Gateway {
 	logger := rootLogger.WithFields(logrus.Fields{"prefix": "gateway"})
 
-	cache, _ := lru.New(5000)
 	gw := &Gateway{
 		Channels: make(map[string]*config.ChannelInfo),
 		Message:  r.Message,
 		Router:   r,
 		Bridges:  make(map[string]*bridge.Bridge),
 		Config:   r.Config,
-		Messages: cache,
+		Name:     cfg.Name,
 		logger:   logger,
+		inflight: newInflight(),
+	}
+	gw.excerpts, _ = lru.New(defaultMessageCacheSize)
+	if store == nil {
+		var err error
+		store, err = newMessageStore(gw)
+		if err != nil {
+			logger.Errorf("Failed to open message store, falling back to memory: %s", err)
+			store, _ = newLRUStore(defaultMessageCacheSize)
+		}
 	}
+	gw.Messages = store
 	if err := gw.AddConfig(cfg); err != nil {
 		logger.Errorf("Failed to add configuration to gateway: %#v", err)
 	}
@@ -65,26 +83,26 @@ func New(rootLogger *logrus.Logger, cfg *config.Gateway, r *Router) *Gateway {
 // FindCanonicalMsgID returns the ID under which a message was stored in the cache.
 func (gw *Gateway) FindCanonicalMsgID(protocol string, mID string) string {
 	ID := protocol + " " + mID
-	if gw.Messages.Contains(ID) {
+	if _, ok := gw.Messages.Get(ID); ok {
+		return ID
+	}
+	if _, ok := gw.inflight.get(ID); ok {
 		return ID
 	}
 
-	// If not keyed, iterate through cache for downstream, and infer upstream.
-	for _, mid := range gw.Messages.Keys() {
-		v, _ := gw.Messages.Peek(mid)
-		ids := v.([]*BrMsgID)
-		for _, downstreamMsgObj := range ids {
-			if ID == downstreamMsgObj.ID {
-				return mid.(string)
-			}
-		}
+	// If not keyed, look up the downstream ID and infer upstream.
+	if canonical, ok := gw.Messages.Canonical(ID); ok {
+		return canonical
+	}
+	if canonical, ok := gw.inflight.canonical(ID); ok {
+		return canonical
 	}
 	return ""
 }
 
 // AddBridge sets up a new bridge in the gateway object with the specified configuration.
 func (gw *Gateway) AddBridge(cfg *config.Bridge) error {
-	br := gw.Router.getBridge(cfg.Account)
+	br := gw.Router.lookupBridge(cfg.Account)
 	if br == nil {
 		gw.checkConfig(cfg)
 		br = bridge.New(cfg)
@@ -92,8 +110,9 @@ func (gw *Gateway) AddBridge(cfg *config.Bridge) error {
 		br.General = &gw.BridgeValues().General
 		br.Log = gw.logger.WithFields(logrus.Fields{"prefix": br.Protocol})
 		brconfig := &bridge.Config{
-			Remote: gw.Message,
-			Bridge: br,
+			Remote:   gw.Message,
+			Bridge:   br,
+			Statuses: gw.Router.Statuses,
 		}
 		// add the actual bridger for this protocol to this bridge using the bridgeMap
 		if _, ok := gw.Router.BridgeMap[br.Protocol]; !ok {
@@ -137,35 +156,53 @@ func (gw *Gateway) AddConfig(cfg *config.Gateway) error {
 }
 
 func (gw *Gateway) mapChannelsToBridge(br *bridge.Bridge) {
+	channels := gw.Router.bridgeChannels(br)
 	for ID, channel := range gw.Channels {
 		if br.Account == channel.Account {
-			br.Channels[ID] = *channel
+			channels[ID] = *channel
 		}
 	}
 }
 
+// reconnectBridge reconnects br, retrying with an increasing delay until it succeeds.
+// It gives up when the router stops, br is then left disconnected.
 func (gw *Gateway) reconnectBridge(br *bridge.Bridge) {
+	gw.Router.setState(br, bridge.StateReconnecting, nil)
 	if err := br.Disconnect(); err != nil {
 		gw.logger.Errorf("Disconnect() %s failed: %s", br.Account, err)
 	}
-	time.Sleep(time.Second * 5)
-RECONNECT:
-	gw.logger.Infof("Reconnecting %s", br.Account)
-	err := br.Connect()
-	if err != nil {
-		gw.logger.Errorf("Reconnection failed: %s. Trying again in 60 seconds", err)
-		time.Sleep(time.Second * 60)
-		goto RECONNECT
+	b := reconnectBackoff(br)
+	if !gw.Router.sleep(b.Duration()) {
+		return
+	}
+	for {
+		gw.logger.Infof("Reconnecting %s", br.Account)
+		metrics.Reconnects.Inc(br.Account)
+		err := br.Connect()
+		if err == nil {
+			break
+		}
+		gw.Router.setState(br, bridge.StateReconnecting, err)
+		delay := b.Duration()
+		gw.logger.Errorf("Reconnection failed: %s. Trying again in %s", err, delay.Round(time.Second))
+		if !gw.Router.sleep(delay) {
+			return
+		}
 	}
 	br.Joined = make(map[string]bool)
 	if err := br.JoinChannels(); err != nil {
 		gw.logger.Errorf("JoinChannels() %s failed: %s", br.Account, err)
+		gw.Router.setState(br, bridge.StateConnected, err)
+	} else {
+		gw.Router.setState(br, bridge.StateConnected, nil)
 	}
+	gw.Router.getRetryQueue(br.Account).flush()
 }
 
 func (gw *Gateway) mapChannelConfig(cfg []config.Bridge, direction string) {
 	for _, br := range cfg {
-		if isAPI(br.Account) {
+		// api channels without a name use the default api channel
+		if isAPI(br.Account) && br.Channel == "" {
 			br.Channel = apiProtocol
 		}
 		// make sure to lowercase irc channels in config #348
@@ -213,7 +250,7 @@ func (gw *Gateway) getDestChannel(msg *config.Message, dest bridge.Bridge) []con
 	var channels []config.ChannelInfo
 
 	// for messages received from the api check that the gateway is the specified one
-	if msg.Protocol == apiProtocol && gw.Name != msg.Gateway {
+	if isAPIProtocol(msg.Protocol) && gw.Name != msg.Gateway {
 		return channels
 	}
 
@@ -259,8 +296,12 @@ func (gw *Gateway) getDestChannel(msg *config.Message, dest bridge.Bridge) []con
 }
 
 func (gw *Gateway) getDestMsgID(msgID string, dest *bridge.Bridge, channel *config.ChannelInfo) string {
-	if res, ok := gw.Messages.Get(msgID); ok {
-		IDs := res.([]*BrMsgID)
+	IDs, ok := gw.Messages.Get(msgID)
+	if !ok {
+		// the message can still be being sent to other bridges
+		IDs, ok = gw.inflight.get(msgID)
+	}
+	if ok {
 		for _, id := range IDs {
 			// check protocol, bridge name and channelname
 			// for people that reuse the same bridge multiple times. see #342
@@ -277,7 +318,7 @@ func (gw *Gateway) ignoreTextEmpty(msg *config.Message) bool {
 	if msg.Text != "" {
 		return false
 	}
-	if msg.Event == config.EventUserTyping {
+	if msg.Event == config.EventUserTyping || msg.Event == config.EventReaction {
 		return false
 	}
 	// we have an attachment or actual bytes, do not ignore
@@ -300,6 +341,7 @@ func (gw *Gateway) ignoreMessage(msg *config.Message) bool {
 	igNicks := strings.Fields(gw.Bridges[msg.Account].GetString("IgnoreNicks"))
 	igMessages := strings.Fields(gw.Bridges[msg.Account].GetString("IgnoreMessages"))
 	if gw.ignoreTextEmpty(msg) || gw.ignoreText(msg.Username, igNicks) || gw.ignoreText(msg.Text, igMessages) || gw.ignoreFilesComment(msg.Extra, igMessages) {
+		metrics.MessagesDropped.Inc(msg.Account, metrics.DropIgnored)
 		return true
 	}
 
@@ -404,7 +446,18 @@ func (gw *Gateway) modifyMessage(msg *config.Message) {
 
 	// replace :emoji: to unicode
 	emoji.ReplacePadding = ""
-	msg.Text = emoji.Sprint(msg.Text)
+	if msg.Document != nil {
+		msg.SetRichText(msg.RichText().MapText(func(text string) string {
+			return emoji.Sprint(text)
+		}))
+	} else {
+		msg.Text = emoji.Sprint(msg.Text)
+	}
+	if msg.Reaction != nil {
+		reaction := *msg.Reaction
+		reaction.Emoji = emoji.Sprint(reaction.Emoji)
+		msg.Reaction = &reaction
+	}
 
 	br := gw.Bridges[msg.Account]
 	// loop to replace messages
@@ -423,7 +476,7 @@ func (gw *Gateway) modifyMessage(msg *config.Message) {
 	gw.handleExtractNicks(msg)
 
 	// messages from api have Gateway specified, don't overwrite
-	if msg.Protocol != apiProtocol {
+	if !isAPIProtocol(msg.Protocol) {
 		msg.Gateway = gw.Name
 	}
 }
@@ -449,11 +502,6 @@ func (gw *Gateway) SendMessage(
 		}
 	}
 
-	// Only send irc notices to irc
-	if msg.Event == config.EventNoticeIRC && dest.Protocol != "irc" {
-		return "", nil
-	}
-
 	// Too noisy to log like other events
 	debugSendMessage := ""
 	if msg.Event != config.EventUserTyping {
@@ -469,11 +517,18 @@ func (gw *Gateway) SendMessage(
 		msg.ID = gw.getDestMsgID(rmsg.Protocol+" "+rmsg.ID, dest, channel)
 	}
 
-	// for api we need originchannel as channel
-	if dest.Protocol == apiProtocol {
+	// the default api channel gets the originchannel as channel, named api channels
+	// keep their own name so clients can tell them apart
+	if isAPIProtocol(dest.Protocol) && channel.Name == apiProtocol {
 		msg.Channel = rmsg.Channel
 	}
 
+	if msg.Event == config.EventReaction && !gw.handleReaction(&msg, dest, channel) {
+		return "", nil
+	}
+
+	gw.translateMentions(&msg, dest, channel)
+
 	msg.ParentID = gw.getDestMsgID(canonicalParentMsgID, dest, channel)
 	if msg.ParentID == "" {
 		msg.ParentID = strings.Replace(canonicalParentMsgID, dest.Protocol+" ", "", 1)
@@ -481,10 +536,14 @@ func (gw *Gateway) SendMessage(
 
 	// if the parentID is still empty and we have a parentID set in the original message
 	// this means that we didn't find it in the cache so set it to a "msg-parent-not-found" constant
-	if msg.ParentID == "" && rmsg.ParentID != "" {
+	// the same goes for bridges that can't thread replies
+	if (msg.ParentID == "" || !dest.Capabilities().Threads) && rmsg.ParentID != "" {
 		msg.ParentID = config.ParentIDNotFound
 	}
 
+	gw.handleReplyFallback(&msg, dest)
+	gw.handleCapabilities(&msg, dest)
+
 	drop, err := gw.modifyOutMessageTengo(rmsg, &msg, dest)
 	if err != nil {
 		gw.logger.Errorf("modifySendMessageTengo: %s", err)
@@ -492,6 +551,7 @@ func (gw *Gateway) SendMessage(
 
 	if drop {
 		gw.logger.Debugf("=> Tengo dropping %#v from %s (%s) to %s (%s)", msg, msg.Account, rmsg.Channel, dest.Account, channel.Name)
+		metrics.MessagesDropped.Inc(dest.Account, metrics.DropTengo)
 		return "", nil
 	}
 
@@ -506,12 +566,15 @@ func (gw *Gateway) SendMessage(
 
 	defer func(t time.Time) {
 		gw.logger.Debugf("=> Send from %s (%s) to %s (%s) took %s", msg.Account, rmsg.Channel, dest.Account, channel.Name, time.Since(t))
+		metrics.SendDuration.Since(t, dest.Account)
 	}(time.Now())
 
 	mID, err := dest.Send(msg)
 	if err != nil {
+		metrics.SendFailures.Inc(dest.Account, channel.Name)
 		return mID, err
 	}
+	metrics.MessagesSent.Inc(dest.Account, channel.Name)
 
 	// append the message ID (mID) from this bridge (dest) to our brMsgIDs slice
 	if mID != "" {
@@ -531,7 +594,13 @@ func getChannelID(msg *config.Message) string {
 }
 
 func isAPI(account string) bool {
-	return strings.HasPrefix(account, "api.")
+	return strings.HasPrefix(account, apiProtocol+".") || strings.HasPrefix(account, grpcProtocol+".")
+}
+
+// isAPIProtocol returns true for the protocols of the HTTP and gRPC API, their
+// messages specify the gateway they're sent to.
+func isAPIProtocol(protocol string) bool {
+	return protocol == apiProtocol || protocol == grpcProtocol
 }
 
 // ignoreText returns true if text matches any of the input regexes.
@@ -563,112 +632,96 @@ func modifyInMessageTengo(filename string, msg *config.Message) error {
 	if filename == "" {
 		return nil
 	}
-	res, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return err
-	}
-	s := tengo.NewScript(res)
-	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
-	_ = s.Add("msgText", msg.Text)
-	_ = s.Add("msgUsername", msg.Username)
-	_ = s.Add("msgUserID", msg.UserID)
-	_ = s.Add("msgAccount", msg.Account)
-	_ = s.Add("msgChannel", msg.Channel)
-	c, err := s.Compile()
+	c, err := tengoScripts.get("in", filename, "").run(inMessageTengoVars(msg))
 	if err != nil {
 		return err
 	}
-	if err := c.Run(); err != nil {
-		return err
-	}
 	msg.Text = c.Get("msgText").String()
 	msg.Username = c.Get("msgUsername").String()
+	msg.Event = c.Get("msgEvent").String()
+	msg.Avatar = c.Get("msgAvatar").String()
+	msg.ParentID = c.Get("msgParentID").String()
 	return nil
 }
 
+func inMessageTengoVars(msg *config.Message) map[string]interface{} {
+	return map[string]interface{}{
+		"msg":         tengoMessage(msg),
+		"msgText":     msg.Text,
+		"msgUsername": msg.Username,
+		"msgUserID":   msg.UserID,
+		"msgAccount":  msg.Account,
+		"msgChannel":  msg.Channel,
+		"msgEvent":    msg.Event,
+		"msgAvatar":   msg.Avatar,
+		"msgParentID": msg.ParentID,
+	}
+}
+
 func (gw *Gateway) modifyUsernameTengo(msg *config.Message, br *bridge.Bridge) (string, error) {
 	filename := gw.BridgeValues().Tengo.RemoteNickFormat
 	if filename == "" {
 		return "", nil
 	}
-	res, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return "", err
-	}
-	s := tengo.NewScript(res)
-	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
-	_ = s.Add("result", "")
-	_ = s.Add("msgText", msg.Text)
-	_ = s.Add("msgUsername", msg.Username)
-	_ = s.Add("msgUserID", msg.UserID)
-	_ = s.Add("nick", msg.Username)
-	_ = s.Add("msgAccount", msg.Account)
-	_ = s.Add("msgChannel", msg.Channel)
-	_ = s.Add("channel", msg.Channel)
-	_ = s.Add("msgProtocol", msg.Protocol)
-	_ = s.Add("remoteAccount", br.Account)
-	_ = s.Add("protocol", br.Protocol)
-	_ = s.Add("bridge", br.Name)
-	_ = s.Add("gateway", gw.Name)
-	c, err := s.Compile()
+	c, err := tengoScripts.get("remotenickformat", filename, "").run(remoteNickTengoVars(gw.Name, msg, br))
 	if err != nil {
 		return "", err
 	}
-	if err := c.Run(); err != nil {
-		return "", err
-	}
 	return c.Get("result").String(), nil
 }
 
-func (gw *Gateway) modifyOutMessageTengo(origmsg *config.Message, msg *config.Message, br *bridge.Bridge) (bool, error) {
-	filename := gw.BridgeValues().Tengo.OutMessage
-	var (
-		res  []byte
-		err  error
-		drop bool
-	)
-
-	if filename == "" {
-		res, err = internal.Asset("tengo/outmessage.tengo")
-		if err != nil {
-			return drop, err
-		}
-	} else {
-		res, err = ioutil.ReadFile(filename)
-		if err != nil {
-			return drop, err
-		}
+func remoteNickTengoVars(gateway string, msg *config.Message, br *bridge.Bridge) map[string]interface{} {
+	return map[string]interface{}{
+		"result":        "",
+		"msg":           tengoMessage(msg),
+		"msgText":       msg.Text,
+		"msgUsername":   msg.Username,
+		"msgUserID":     msg.UserID,
+		"nick":          msg.Username,
+		"msgAccount":    msg.Account,
+		"msgChannel":    msg.Channel,
+		"channel":       msg.Channel,
+		"msgProtocol":   msg.Protocol,
+		"remoteAccount": br.Account,
+		"protocol":      br.Protocol,
+		"bridge":        br.Name,
+		"gateway":       gateway,
 	}
+}
 
-	s := tengo.NewScript(res)
-
-	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
-	_ = s.Add("inAccount", origmsg.Account)
-	_ = s.Add("inProtocol", origmsg.Protocol)
-	_ = s.Add("inChannel", origmsg.Channel)
-	_ = s.Add("inGateway", origmsg.Gateway)
-	_ = s.Add("inEvent", origmsg.Event)
-	_ = s.Add("outAccount", br.Account)
-	_ = s.Add("outProtocol", br.Protocol)
-	_ = s.Add("outChannel", msg.Channel)
-	_ = s.Add("outGateway", gw.Name)
-	_ = s.Add("outEvent", msg.Event)
-	_ = s.Add("msgText", msg.Text)
-	_ = s.Add("msgUsername", msg.Username)
-	_ = s.Add("msgUserID", msg.UserID)
-	_ = s.Add("msgDrop", drop)
-	c, err := s.Compile()
+func (gw *Gateway) modifyOutMessageTengo(origmsg *config.Message, msg *config.Message, br *bridge.Bridge) (bool, error) {
+	c, err := tengoScripts.get("out", gw.BridgeValues().Tengo.OutMessage, "tengo/outmessage.tengo").run(
+		outMessageTengoVars(gw.Name, origmsg, msg, br))
 	if err != nil {
-		return drop, err
-	}
-
-	if err := c.Run(); err != nil {
-		return drop, err
+		return false, err
 	}
-
-	drop = c.Get("msgDrop").Bool()
 	msg.Text = c.Get("msgText").String()
 	msg.Username = c.Get("msgUsername").String()
+	msg.Event = c.Get("msgEvent").String()
+	msg.Avatar = c.Get("msgAvatar").String()
+	msg.ParentID = c.Get("msgParentID").String()
+	return c.Get("msgDrop").Bool(), nil
+}
 
-	return drop, nil
+func outMessageTengoVars(gateway string, origmsg *config.Message, msg *config.Message, br *bridge.Bridge) map[string]interface{} {
+	return map[string]interface{}{
+		"msg":         tengoMessage(msg),
+		"inAccount":   origmsg.Account,
+		"inProtocol":  origmsg.Protocol,
+		"inChannel":   origmsg.Channel,
+		"inGateway":   origmsg.Gateway,
+		"inEvent":     origmsg.Event,
+		"outAccount":  br.Account,
+		"outProtocol": br.Protocol,
+		"outChannel":  msg.Channel,
+		"outGateway":  gateway,
+		"outEvent":    msg.Event,
+		"msgText":     msg.Text,
+		"msgUsername": msg.Username,
+		"msgUserID":   msg.UserID,
+		"msgEvent":    msg.Event,
+		"msgAvatar":   msg.Avatar,
+		"msgParentID": msg.ParentID,
+		"msgDrop":     false,
+	}
 }
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/gateway/bridgemap"
@@ -539,3 +540,113 @@ func BenchmarkTengo(b *testing.B) {
 		}
 	}
 }
+
+func TestFindCanonicalMsgID(t *testing.T) {
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+	br := r.getBridge("slack.test")
+	store, err := newLRUStore(2)
+	assert.NoError(t, err)
+	gw.Messages = store
+
+	gw.Messages.Add("irc 1", []*BrMsgID{{br, "slack 100", "testingslack.test"}})
+	gw.Messages.Add("irc 2", []*BrMsgID{{br, "slack 200", "testingslack.test"}})
+	assert.Equal(t, "irc 1", gw.FindCanonicalMsgID("irc", "1"))
+	assert.Equal(t, "irc 1", gw.FindCanonicalMsgID("slack", "100"))
+
+	// replacing an entry drops its old downstream IDs
+	gw.Messages.Add("irc 2", []*BrMsgID{{br, "slack 201", "testingslack.test"}})
+	assert.Equal(t, "", gw.FindCanonicalMsgID("slack", "200"))
+	assert.Equal(t, "irc 2", gw.FindCanonicalMsgID("slack", "201"))
+
+	// evicting an entry drops its downstream IDs
+	gw.Messages.Add("irc 3", []*BrMsgID{{br, "slack 300", "testingslack.test"}})
+	gw.Messages.Add("irc 4", []*BrMsgID{{br, "slack 400", "testingslack.test"}})
+	assert.Equal(t, "", gw.FindCanonicalMsgID("slack", "100"))
+	assert.Equal(t, "irc 4", gw.FindCanonicalMsgID("slack", "400"))
+}
+
+func TestAPIChannels(t *testing.T) {
+	r, _ := maketestRouterWithBridgers(t, []byte(`
+[irc.freenode]
+server=""
+[api.local]
+bindaddress=""
+[grpc.local]
+bindaddress=""
+[[gateway]]
+    name = "bridge1"
+    enable = true
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+    [[gateway.inout]]
+    account = "api.local"
+    channel = "ops"
+    [[gateway.inout]]
+    account = "api.local"
+    channel = "dev"
+    [[gateway.inout]]
+    account = "grpc.local"
+`))
+	api := r.getBridge("api.local").Bridger.(*testBridger)
+	grpc := r.getBridge("grpc.local").Bridger.(*testBridger)
+	irc := r.getBridge("irc.freenode").Bridger.(*testBridger)
+	assert.ElementsMatch(t, []string{"ops", "dev"}, api.joined)
+	assert.Equal(t, []string{"api"}, grpc.joined)
+
+	received := func(br *testBridger) []string {
+		var channels []string
+		for _, msg := range br.messages() {
+			if msg.Event == "" {
+				channels = append(channels, msg.Channel)
+			}
+		}
+		return channels
+	}
+
+	// named api channels keep their name, the default channel gets the origin channel
+	r.Message <- config.Message{Text: "hello", Channel: "#wimtesting", Account: "irc.freenode", ID: "1"}
+	assert.Eventually(t, func() bool { return len(received(api)) == 2 }, time.Second, time.Millisecond)
+	assert.ElementsMatch(t, []string{"ops", "dev"}, received(api))
+	assert.Eventually(t, func() bool { return len(received(grpc)) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, "#wimtesting", received(grpc)[0])
+
+	// messages from one api channel go to the other channels
+	r.Message <- config.Message{Text: "hi", Channel: "ops", Account: "api.local", Protocol: "api", Gateway: "bridge1"}
+	assert.Eventually(t, func() bool { return len(irc.messages()) == 1 }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool { return len(received(api)) == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, "dev", received(api)[2])
+
+	// gRPC messages specify their gateway as well
+	r.Message <- config.Message{Text: "hey", Channel: "api", Account: "grpc.local", Protocol: "grpc", Gateway: "bridge1"}
+	assert.Eventually(t, func() bool { return len(irc.messages()) == 2 }, time.Second, time.Millisecond)
+	r.Message <- config.Message{Text: "hey", Channel: "api", Account: "grpc.local", Protocol: "grpc", Gateway: "unknown"}
+	r.Message <- config.Message{Text: "last", Channel: "#wimtesting", Account: "irc.freenode", ID: "2"}
+	assert.Eventually(t, func() bool { return len(received(api)) == 7 && len(received(grpc)) == 3 }, time.Second, time.Millisecond)
+	assert.Len(t, irc.messages(), 2)
+}
+
+func BenchmarkFindCanonicalMsgID(b *testing.B) {
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+	for _, size := range []int{500, defaultMessageCacheSize} {
+		store, _ := newLRUStore(size)
+		gw.Messages = store
+		for i := 0; i < size; i++ {
+			var ids []*BrMsgID
+			for _, br := range gw.Bridges {
+				ids = append(ids, &BrMsgID{br, br.Protocol + " " + strconv.Itoa(i), "channel" + br.Account})
+			}
+			gw.Messages.Add("irc "+strconv.Itoa(i), ids)
+		}
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				// the oldest downstream ID is the worst case for a linear scan
+				if gw.FindCanonicalMsgID("slack", "0") == "" {
+					b.Fatal("message not found")
+				}
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package gateway
 import (
 	"bytes"
 	"crypto/sha1" //nolint:gosec
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -14,7 +15,13 @@ import (
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
-	"github.com/42wim/matterbridge/gateway/bridgemap"
+	"github.com/42wim/matterbridge/internal/metrics"
+)
+
+var (
+	errUnknownBridge = errors.New("unknown bridge")
+	errReconnecting  = errors.New("the bridge is already reconnecting")
+	errStopping      = errors.New("the router is stopping")
 )
 
 // handleEventFailure handles failures and reconnects bridges.
@@ -22,14 +29,45 @@ func (r *Router) handleEventFailure(msg *config.Message) {
 	if msg.Event != config.EventFailure {
 		return
 	}
+	if err := r.reconnect(msg.Account); err != nil {
+		r.logger.Debugf("Not reconnecting %s: %s", msg.Account, err)
+	}
+}
+
+// reconnect starts reconnecting the bridge of account. It returns errUnknownBridge
+// if there's no such bridge, errReconnecting if the bridge is already reconnecting
+// and errStopping once the router stops.
+func (r *Router) reconnect(account string) error {
 	for _, gw := range r.Gateways {
 		for _, br := range gw.Bridges {
-			if msg.Account == br.Account {
-				go gw.reconnectBridge(br)
-				return
+			if account != br.Account {
+				continue
 			}
+			r.reconnectMu.Lock()
+			defer r.reconnectMu.Unlock()
+			select {
+			case <-r.stopped:
+				return errStopping
+			default:
+			}
+			if _, ok := r.reconnecting[br]; ok {
+				return errReconnecting
+			}
+			done := make(chan struct{})
+			r.reconnecting[br] = done
+			// don't retry failed sends until we're reconnected
+			r.getRetryQueue(br.Account).pause()
+			go func() {
+				gw.reconnectBridge(br)
+				r.reconnectMu.Lock()
+				delete(r.reconnecting, br)
+				close(done)
+				r.reconnectMu.Unlock()
+			}()
+			return nil
 		}
 	}
+	return errUnknownBridge
 }
 
 // handleEventGetChannelMembers handles channel members
@@ -106,6 +144,8 @@ func (gw *Gateway) handleFiles(msg *config.Message) {
 			}
 		}
 
+		metrics.UploadBytes.Add(float64(len(*fi.Data)), msg.Account)
+
 		// Download URL.
 		durl := gw.BridgeValues().General.MediaServerDownload + "/" + sha1sum + "/" + fi.Name
 
@@ -168,8 +208,15 @@ func (gw *Gateway) handleFilesLocal(fi *config.FileInfo) error {
 func (gw *Gateway) ignoreEvent(event string, dest *bridge.Bridge) bool {
 	switch event {
 	case config.EventAvatarDownload:
-		// Avatar downloads are only relevant for telegram and mattermost for now
-		if dest.Protocol != "mattermost" && dest.Protocol != "telegram" && dest.Protocol != "xmpp" {
+		if !dest.Capabilities().Avatars {
+			return true
+		}
+	case config.EventNoticeIRC:
+		if !dest.Capabilities().Notices {
+			return true
+		}
+	case config.EventMsgDelete:
+		if !dest.Capabilities().Deletes {
 			return true
 		}
 	case config.EventJoinLeave:
@@ -187,53 +234,51 @@ func (gw *Gateway) ignoreEvent(event string, dest *bridge.Bridge) bool {
 }
 
 // handleMessage makes sure the message get sent to the correct bridge/channels.
-// Returns an array of msg ID's
-func (gw *Gateway) handleMessage(rmsg *config.Message, dest *bridge.Bridge) []*BrMsgID {
-	var brMsgIDs []*BrMsgID
+// Returns the jobs that need to be queued for the destination bridge.
+func (gw *Gateway) handleMessage(rmsg *config.Message, dest *bridge.Bridge) []*sendJob {
+	if gw.ignoreDest(rmsg, dest) {
+		return nil
+	}
 
+	var jobs []*sendJob
+	for _, channel := range gw.getDestChannel(rmsg, *dest) {
+		jobs = append(jobs, gw.newSendJob(rmsg, dest, channel))
+	}
+	return jobs
+}
+
+// ignoreDest returns true if the message shouldn't be sent to the destination bridge at all.
+func (gw *Gateway) ignoreDest(rmsg *config.Message, dest *bridge.Bridge) bool {
 	// Not all bridges support "user is typing" indications so skip the message
 	// if the targeted bridge does not support it.
-	if rmsg.Event == config.EventUserTyping {
-		if _, ok := bridgemap.UserTypingSupport[dest.Protocol]; !ok {
-			return nil
-		}
+	if rmsg.Event == config.EventUserTyping && !dest.Capabilities().UserTyping {
+		return true
 	}
 
 	// if we have an attached file, or other info
 	if rmsg.Extra != nil && len(rmsg.Extra[config.EventFileFailureSize]) != 0 && rmsg.Text == "" {
-		return brMsgIDs
+		return true
 	}
 
 	if gw.ignoreEvent(rmsg.Event, dest) {
-		return brMsgIDs
+		return true
 	}
 
 	// broadcast to every out channel (irc QUIT)
 	if rmsg.Channel == "" && rmsg.Event != config.EventJoinLeave {
 		gw.logger.Debug("empty channel")
-		return brMsgIDs
-	}
-
-	// Get the ID of the parent message in thread
-	var canonicalParentMsgID string
-	if rmsg.ParentID != "" && dest.GetBool("PreserveThreading") {
-		canonicalParentMsgID = gw.FindCanonicalMsgID(rmsg.Protocol, rmsg.ParentID)
+		return true
 	}
+	return false
+}
 
-	channels := gw.getDestChannel(rmsg, *dest)
-	for idx := range channels {
-		channel := &channels[idx]
-		msgID, err := gw.SendMessage(rmsg, dest, channel, canonicalParentMsgID)
-		if err != nil {
-			gw.logger.Errorf("SendMessage failed: %s", err)
-			continue
-		}
-		if msgID == "" {
-			continue
-		}
-		brMsgIDs = append(brMsgIDs, &BrMsgID{dest, dest.Protocol + " " + msgID, channel.ID})
+func (gw *Gateway) newSendJob(rmsg *config.Message, dest *bridge.Bridge, channel config.ChannelInfo) *sendJob {
+	return &sendJob{
+		gw:      gw,
+		rmsg:    copyMessage(rmsg),
+		dest:    dest,
+		channel: channel,
 	}
-	return brMsgIDs
 }
 
 func (gw *Gateway) handleExtractNicks(msg *config.Message) {
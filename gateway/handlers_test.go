@@ -16,17 +16,42 @@ func TestIgnoreEvent(t *testing.T) {
 	}{
 		"avatar mattermost": {
 			input:  config.EventAvatarDownload,
-			dest:   &bridge.Bridge{Protocol: "mattermost"},
+			dest:   &bridge.Bridge{Protocol: "mattermost", Bridger: &testBridger{caps: bridge.Capabilities{Avatars: true}}},
 			output: false,
 		},
 		"avatar slack": {
 			input:  config.EventAvatarDownload,
-			dest:   &bridge.Bridge{Protocol: "slack"},
+			dest:   &bridge.Bridge{Protocol: "slack", Bridger: &testBridger{caps: testCapabilities["slack"]}},
 			output: true,
 		},
 		"avatar telegram": {
 			input:  config.EventAvatarDownload,
 			dest:   &bridge.Bridge{Protocol: "telegram"},
+			output: true,
+		},
+		"avatar without capabilities": {
+			input:  config.EventAvatarDownload,
+			dest:   &bridge.Bridge{Protocol: "gitter"},
+			output: true,
+		},
+		"notice irc": {
+			input:  config.EventNoticeIRC,
+			dest:   &bridge.Bridge{Protocol: "irc", Bridger: &testBridger{caps: testCapabilities["irc"]}},
+			output: false,
+		},
+		"notice slack": {
+			input:  config.EventNoticeIRC,
+			dest:   &bridge.Bridge{Protocol: "slack", Bridger: &testBridger{caps: testCapabilities["slack"]}},
+			output: true,
+		},
+		"delete irc": {
+			input:  config.EventMsgDelete,
+			dest:   &bridge.Bridge{Protocol: "irc", Bridger: &testBridger{caps: testCapabilities["irc"]}},
+			output: true,
+		},
+		"delete without capabilities": {
+			input:  config.EventMsgDelete,
+			dest:   &bridge.Bridge{Protocol: "gitter"},
 			output: false,
 		},
 	}
@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/richtext"
+)
+
+// translateMentions points the mentions of msg to the users of dest, configured in
+// its MentionAliases or found by name in its channel members. The user IDs of other
+// bridges mean nothing on dest, so mentions of unknown users become plain @name.
+func (gw *Gateway) translateMentions(msg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo) {
+	if msg.Account == dest.Account || msg.Text == "" {
+		return
+	}
+	if msg.Event != "" && msg.Event != config.EventUserAction {
+		return
+	}
+	var (
+		aliases [][]string
+		changed bool
+	)
+	doc := msg.RichText().MapMentions(func(n richtext.Node) richtext.Node {
+		if !changed && aliases == nil {
+			aliases = dest.GetStringSlice2D("MentionAliases")
+		}
+		mention := n
+		mention.UserID = ""
+		if id := findMentionAlias(aliases, n); id != "" {
+			mention.UserID = id
+		} else if member, ok := findChannelMember(dest, channel, n.Text); ok {
+			mention.UserID = member.UserID
+			if member.Nick != "" {
+				mention.Text = member.Nick
+			} else if member.Username != "" {
+				mention.Text = member.Username
+			}
+		}
+		if mention.UserID != n.UserID || mention.Text != n.Text {
+			changed = true
+		}
+		return mention
+	})
+	if changed {
+		msg.SetRichText(doc)
+	}
+}
+
+// findMentionAlias returns the user ID of the alias of the mention n, which is an
+// alias of the user ID or the name of n.
+func findMentionAlias(aliases [][]string, n richtext.Node) string {
+	for _, alias := range aliases {
+		if len(alias) != 2 {
+			continue
+		}
+		if (n.UserID != "" && alias[0] == n.UserID) || strings.EqualFold(alias[0], n.Text) {
+			return alias[1]
+		}
+	}
+	return ""
+}
+
+// findChannelMember returns the member of channel on dest with the nick or username
+// name.
+func findChannelMember(dest *bridge.Bridge, channel *config.ChannelInfo, name string) (config.ChannelMember, bool) {
+	dest.RLock()
+	defer dest.RUnlock()
+	if dest.ChannelMembers == nil || name == "" {
+		return config.ChannelMember{}, false
+	}
+	for _, member := range *dest.ChannelMembers {
+		// members without a channel are in all channels
+		if member.ChannelName != "" && member.ChannelName != channel.Name && "ID:"+member.ChannelID != channel.Name {
+			continue
+		}
+		if strings.EqualFold(member.Nick, name) || strings.EqualFold(member.Username, name) {
+			return member, true
+		}
+	}
+	return config.ChannelMember{}, false
+}
@@ -0,0 +1,112 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/richtext"
+	"github.com/stretchr/testify/assert"
+)
+
+var mentionsTestConfig = []byte(`
+[irc.freenode]
+server=""
+[gitter.42wim]
+server=""
+[discord.test]
+server=""
+[slack.test]
+server=""
+MentionAliases=[ ["bob","U0B"] ]
+
+[[gateway]]
+name = "bridge1"
+enable=true
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+    [[gateway.inout]]
+    account="gitter.42wim"
+    channel="42wim/testroom"
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+    [[gateway.inout]]
+    account="slack.test"
+    channel="testing"
+`)
+
+func TestTranslateMentions(t *testing.T) {
+	r, _ := maketestRouterWithBridgers(t, mentionsTestConfig)
+	irc := r.getBridge("irc.freenode").Bridger.(*testBridger)
+	gitter := r.getBridge("gitter.42wim").Bridger.(*testBridger)
+	discord := r.getBridge("discord.test").Bridger.(*testBridger)
+	slack := r.getBridge("slack.test").Bridger.(*testBridger)
+	r.getBridge("discord.test").SetChannelMembers(&config.ChannelMembers{
+		{Username: "alice", Nick: "Alice W", UserID: "111"},
+		{Username: "carol", UserID: "333", ChannelName: "other"},
+	})
+
+	r.Message <- config.Message{Text: "hi @alice, @bob and @carol", Channel: "#wimtesting", Account: "irc.freenode"}
+	assert.Eventually(t, func() bool {
+		return len(gitter.messages()) == 1 && len(discord.messages()) == 1 && len(slack.messages()) == 1
+	}, time.Second, time.Millisecond)
+
+	// discord has a member alice, but carol isn't in the channel
+	msg := discord.messages()[0]
+	assert.Equal(t, "hi @Alice W, @bob and @carol", msg.Text)
+	assert.Equal(t, richtext.Node{Kind: richtext.Mention, UserID: "111", Text: "Alice W"}, msg.Document[1])
+	assert.Equal(t, "", msg.Document[3].UserID)
+	// slack has an alias of bob
+	msg = slack.messages()[0]
+	assert.Equal(t, "hi @alice, @bob and @carol", msg.Text)
+	assert.Equal(t, richtext.Node{Kind: richtext.Mention, UserID: "U0B", Text: "bob"}, msg.Document[3])
+	// bridges without members get the text as is
+	assert.Equal(t, "hi @alice, @bob and @carol", gitter.messages()[0].Text)
+	assert.Nil(t, gitter.messages()[0].Document)
+
+	// user IDs of other bridges aren't sent
+	msg = config.Message{Channel: "testing", Account: "slack.test"}
+	msg.SetRichText(richtext.Document{{Kind: richtext.Mention, UserID: "U0B", Text: "bob"}})
+	r.Message <- msg
+	assert.Eventually(t, func() bool {
+		return len(irc.messages()) == 1 && len(gitter.messages()) == 2 && len(discord.messages()) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, richtext.Document{{Kind: richtext.Mention, Text: "bob"}}, discord.messages()[1].Document)
+	assert.Equal(t, "@bob", gitter.messages()[1].Text)
+}
+
+var channelMembersTestConfig = []byte(`
+[irc.freenode]
+server=""
+[telegram.test]
+server=""
+
+[[gateway]]
+name = "bridge1"
+enable=true
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+    [[gateway.inout]]
+    account="telegram.test"
+    channel="-100"
+`)
+
+func TestChannelMembers(t *testing.T) {
+	r, _ := maketestRouterWithBridgers(t, channelMembersTestConfig)
+	telegram := r.getBridge("telegram.test")
+
+	// the members are asked when the router starts
+	assert.Eventually(t, func() bool {
+		telegram.RLock()
+		defer telegram.RUnlock()
+		return telegram.ChannelMembers != nil
+	}, time.Second, time.Millisecond)
+
+	r.Message <- config.Message{Text: "hi @alice", Channel: "#wimtesting", Account: "irc.freenode"}
+	tg := telegram.Bridger.(*testBridger)
+	assert.Eventually(t, func() bool { return len(tg.messages()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, richtext.Node{Kind: richtext.Mention, UserID: "42", Text: "Alice"}, tg.messages()[0].Document[1])
+}
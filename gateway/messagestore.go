@@ -0,0 +1,399 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultMessageCacheSize = 5000
+	defaultMessageStoreTTL  = 7 * 24 * time.Hour
+)
+
+// MessageStore keeps track of the IDs a message got on every bridge it was relayed to.
+// Entries are keyed by the canonical ID of the original message ("protocol ID").
+type MessageStore interface {
+	// Get returns the downstream IDs stored for the canonical ID key.
+	Get(key string) ([]*BrMsgID, bool)
+	// Add stores (or replaces) the downstream IDs for the canonical ID key.
+	Add(key string, ids []*BrMsgID)
+	// Canonical returns the canonical ID of the message that was relayed as downstreamID.
+	Canonical(downstreamID string) (string, bool)
+	// Range calls fn for every stored entry until fn returns false.
+	Range(fn func(key string, ids []*BrMsgID) bool)
+	// Close releases the resources held by the store.
+	Close() error
+}
+
+// newMessageStore returns the message store configured in the general section.
+// Without a MessageStorePath we use the in-memory LRU cache.
+func newMessageStore(gw *Gateway) (MessageStore, error) {
+	general := gw.BridgeValues().General
+	if general.MessageStorePath == "" {
+		return newLRUStore(defaultMessageCacheSize)
+	}
+	ttl := time.Duration(general.MessageStoreTTL) * time.Hour
+	if ttl <= 0 {
+		ttl = defaultMessageStoreTTL
+	}
+	if err := os.MkdirAll(general.MessageStorePath, 0700); err != nil {
+		return nil, fmt.Errorf("creating message store directory failed: %s", err)
+	}
+	path := filepath.Join(general.MessageStorePath, storeFileName(gw.Name)+".msgstore")
+	return newFileStore(path, ttl, gw.Router.getBridge, gw.logger)
+}
+
+// storeFileName returns name with every byte other than letters, digits, "_" and
+// "-" escaped as %XX, so that different gateway names never share a file.
+func storeFileName(name string) string {
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+// msgIndex maps downstream message IDs back to the canonical ID they were relayed from.
+type msgIndex map[string]string
+
+func (idx msgIndex) add(key string, downstream ...string) {
+	for _, id := range downstream {
+		idx[id] = key
+	}
+}
+
+// remove drops the downstream IDs, unless they've been reused by another canonical ID.
+func (idx msgIndex) remove(key string, downstream ...string) {
+	for _, id := range downstream {
+		if idx[id] == key {
+			delete(idx, id)
+		}
+	}
+}
+
+func downstreamIDs(ids []*BrMsgID) []string {
+	res := make([]string, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, id.ID)
+	}
+	return res
+}
+
+// lruStore is the default in-memory MessageStore.
+type lruStore struct {
+	sync.Mutex
+
+	cache *lru.Cache
+	index msgIndex
+}
+
+func newLRUStore(size int) (*lruStore, error) {
+	s := &lruStore{index: make(msgIndex)}
+	// the evict callback always runs from within Add or Close, which hold the lock
+	cache, err := lru.NewWithEvict(size, func(key, value interface{}) {
+		s.index.remove(key.(string), downstreamIDs(value.([]*BrMsgID))...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.cache = cache
+	return s, nil
+}
+
+func (s *lruStore) Get(key string) ([]*BrMsgID, bool) {
+	v, ok := s.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return v.([]*BrMsgID), true
+}
+
+func (s *lruStore) Add(key string, ids []*BrMsgID) {
+	s.Lock()
+	defer s.Unlock()
+	if old, ok := s.cache.Peek(key); ok {
+		s.index.remove(key, downstreamIDs(old.([]*BrMsgID))...)
+	}
+	s.cache.Add(key, ids)
+	s.index.add(key, downstreamIDs(ids)...)
+}
+
+func (s *lruStore) Canonical(downstreamID string) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+	key, ok := s.index[downstreamID]
+	return key, ok
+}
+
+func (s *lruStore) Range(fn func(key string, ids []*BrMsgID) bool) {
+	for _, k := range s.cache.Keys() {
+		v, ok := s.cache.Peek(k)
+		if !ok {
+			continue
+		}
+		if !fn(k.(string), v.([]*BrMsgID)) {
+			return
+		}
+	}
+}
+
+func (s *lruStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.cache.Purge()
+	return nil
+}
+
+// storedMsgID is the on-disk representation of a BrMsgID.
+type storedMsgID struct {
+	Account   string `json:"a"`
+	ID        string `json:"id"`
+	ChannelID string `json:"c"`
+}
+
+// storedRecord is a single line in the message store file.
+type storedRecord struct {
+	Key   string        `json:"k"`
+	Added int64         `json:"t"`
+	IDs   []storedMsgID `json:"ids"`
+}
+
+// fileStore is a MessageStore persisted as an append-only log of JSON records.
+// The whole log is indexed in memory on open and compacted when it grows too
+// large compared to the amount of live entries. Entries older than ttl expire.
+type fileStore struct {
+	sync.Mutex
+
+	path    string
+	ttl     time.Duration
+	file    *os.File
+	records map[string]storedRecord
+	index   msgIndex
+	written int
+	resolve func(account string) *bridge.Bridge
+	now     func() time.Time
+	rename  func(oldpath, newpath string) error
+	logger  *logrus.Entry
+}
+
+func newFileStore(path string, ttl time.Duration, resolve func(string) *bridge.Bridge, logger *logrus.Entry) (*fileStore, error) {
+	s := &fileStore{
+		path:    path,
+		ttl:     ttl,
+		records: make(map[string]storedRecord),
+		index:   make(msgIndex),
+		resolve: resolve,
+		now:     time.Now,
+		rename:  os.Rename,
+		logger:  logger,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileStore) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec storedRecord
+		// skip partially written records, eg after a crash
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		if s.expired(rec) {
+			s.remove(rec.Key)
+			continue
+		}
+		s.set(rec)
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the log with only the live records.
+func (s *fileStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for key, rec := range s.records {
+		if s.expired(rec) {
+			s.remove(key)
+			continue
+		}
+		if err := enc.Encode(rec); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	if err := s.rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		// keep appending to the old log, it still has every live record
+		s.file, _ = os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		return err
+	}
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	s.written = len(s.records)
+	return err
+}
+
+// set replaces the record and its downstream IDs in the index.
+func (s *fileStore) set(rec storedRecord) {
+	s.remove(rec.Key)
+	s.records[rec.Key] = rec
+	for _, id := range rec.IDs {
+		s.index.add(rec.Key, id.ID)
+	}
+}
+
+func (s *fileStore) remove(key string) {
+	for _, id := range s.records[key].IDs {
+		s.index.remove(key, id.ID)
+	}
+	delete(s.records, key)
+}
+
+func (s *fileStore) expired(rec storedRecord) bool {
+	return s.now().Sub(time.Unix(rec.Added, 0)) > s.ttl
+}
+
+func (s *fileStore) toBrMsgIDs(rec storedRecord) []*BrMsgID {
+	ids := make([]*BrMsgID, 0, len(rec.IDs))
+	for _, id := range rec.IDs {
+		br := s.resolve(id.Account)
+		// the account isn't configured (anymore)
+		if br == nil {
+			continue
+		}
+		ids = append(ids, &BrMsgID{br, id.ID, id.ChannelID})
+	}
+	return ids
+}
+
+func (s *fileStore) Get(key string) ([]*BrMsgID, bool) {
+	s.Lock()
+	defer s.Unlock()
+	rec, ok := s.records[key]
+	if !ok {
+		return nil, false
+	}
+	if s.expired(rec) {
+		s.remove(key)
+		return nil, false
+	}
+	return s.toBrMsgIDs(rec), true
+}
+
+func (s *fileStore) Canonical(downstreamID string) (string, bool) {
+	s.Lock()
+	defer s.Unlock()
+	key, ok := s.index[downstreamID]
+	if !ok {
+		return "", false
+	}
+	if s.expired(s.records[key]) {
+		s.remove(key)
+		return "", false
+	}
+	return key, true
+}
+
+func (s *fileStore) Add(key string, ids []*BrMsgID) {
+	s.Lock()
+	defer s.Unlock()
+	rec := storedRecord{Key: key, Added: s.now().Unix()}
+	for _, id := range ids {
+		rec.IDs = append(rec.IDs, storedMsgID{Account: id.br.Account, ID: id.ID, ChannelID: id.ChannelID})
+	}
+	s.set(rec)
+	if s.file == nil {
+		return
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		s.logger.Errorf("Encoding the message IDs of %s failed: %s", key, err)
+		return
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		s.logger.Errorf("Writing the message IDs of %s to %s failed: %s", key, s.path, err)
+		return
+	}
+	s.written++
+	if s.written > 2*len(s.records)+defaultMessageCacheSize {
+		if err := s.compact(); err != nil {
+			s.logger.Errorf("Compacting message store %s failed: %s", s.path, err)
+		}
+	}
+}
+
+func (s *fileStore) Range(fn func(key string, ids []*BrMsgID) bool) {
+	s.Lock()
+	recs := make([]storedRecord, 0, len(s.records))
+	for key, rec := range s.records {
+		if s.expired(rec) {
+			s.remove(key)
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	s.Unlock()
+	for _, rec := range recs {
+		if !fn(rec.Key, s.toBrMsgIDs(rec)) {
+			return
+		}
+	}
+}
+
+func (s *fileStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	r := maketestRouter(testconfig)
+	path := filepath.Join(t.TempDir(), "bridge1.msgstore")
+	br := r.getBridge("slack.test")
+
+	s, err := newFileStore(path, time.Hour, r.getBridge, r.logger)
+	require.NoError(t, err)
+	s.Add("irc 1", []*BrMsgID{{br, "slack 100", "testingslack.test"}})
+	s.Add("irc 2", []*BrMsgID{{br, "slack 200", "testingslack.test"}})
+	s.Add("irc 1", []*BrMsgID{{br, "slack 101", "testingslack.test"}})
+	require.NoError(t, s.Close())
+
+	// reopening gives us the latest mapping of every key
+	s, err = newFileStore(path, time.Hour, r.getBridge, r.logger)
+	require.NoError(t, err)
+	ids, ok := s.Get("irc 1")
+	assert.True(t, ok)
+	assert.Equal(t, []*BrMsgID{{br, "slack 101", "testingslack.test"}}, ids)
+	_, ok = s.Get("irc 3")
+	assert.False(t, ok)
+
+	// entries expire after the ttl
+	s.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, ok = s.Get("irc 2")
+	assert.False(t, ok)
+	require.NoError(t, s.Close())
+}
+
+func TestFileStoreCompactFailure(t *testing.T) {
+	r := maketestRouter(testconfig)
+	path := filepath.Join(t.TempDir(), "bridge1.msgstore")
+	br := r.getBridge("slack.test")
+
+	s, err := newFileStore(path, time.Hour, r.getBridge, r.logger)
+	require.NoError(t, err)
+	s.Add("irc 1", []*BrMsgID{{br, "slack 100", "testingslack.test"}})
+
+	// a failed compaction keeps the old log open for appending
+	s.rename = func(string, string) error { return errors.New("rename failed") }
+	assert.Error(t, s.compact())
+	_, err = os.Stat(path + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+	s.Add("irc 2", []*BrMsgID{{br, "slack 200", "testingslack.test"}})
+	require.NoError(t, s.Close())
+
+	s, err = newFileStore(path, time.Hour, r.getBridge, r.logger)
+	require.NoError(t, err)
+	for _, key := range []string{"irc 1", "irc 2"} {
+		_, ok := s.Get(key)
+		assert.True(t, ok, key)
+	}
+	require.NoError(t, s.Close())
+}
+
+func TestStoreFileName(t *testing.T) {
+	assert.Equal(t, "bridge_1-a", storeFileName("bridge_1-a"))
+	assert.Equal(t, "a%2Eb", storeFileName("a.b"))
+	assert.Equal(t, "a%2Fb%25", storeFileName("a/b%"))
+	assert.NotEqual(t, storeFileName("a.b"), storeFileName("a_b"))
+}
@@ -0,0 +1,74 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// excerptLength is the max length in runes of the quoted message in reactions
+// and replies that are sent as text.
+const excerptLength = 50
+
+// addExcerpt remembers the author and text of the message with the canonical ID key,
+// so that reactions and replies to it can quote it on bridges without them.
+func (gw *Gateway) addExcerpt(key string, msg *config.Message) {
+	if key == "" || msg.Event != "" || msg.Text == "" {
+		return
+	}
+	text := strings.Join(strings.Fields(msg.Text), " ")
+	if runes := []rune(text); len(runes) > excerptLength {
+		text = strings.TrimSpace(string(runes[:excerptLength])) + "…"
+	}
+	gw.excerpts.Add(key, &config.Quote{Username: msg.Username, Text: text})
+}
+
+// handleReaction points the reaction of msg to the message it reacts to on dest.
+// Destinations that don't support reactions get a text message instead. It returns
+// false if the reaction can't be sent, because the message isn't known.
+func (gw *Gateway) handleReaction(msg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo) bool {
+	if msg.Reaction == nil || msg.Reaction.TargetID == "" {
+		return false
+	}
+	canonical := gw.FindCanonicalMsgID(msg.Protocol, msg.Reaction.TargetID)
+	if canonical == "" {
+		gw.logger.Debugf("ignoring reaction to unknown message %s from %s", msg.Reaction.TargetID, msg.Account)
+		return false
+	}
+	// reactions don't change the messages with the ID of the reaction
+	msg.ID = ""
+
+	if !dest.Capabilities().Reactions {
+		excerpt, _ := gw.excerpts.Get(canonical)
+		msg.Text = reactionText(msg.Reaction, excerpt)
+		msg.Event = config.EventUserAction
+		msg.Reaction = nil
+		return true
+	}
+
+	target := gw.getDestMsgID(canonical, dest, channel)
+	if target == "" && strings.HasPrefix(canonical, dest.Protocol+" ") {
+		target = strings.TrimPrefix(canonical, dest.Protocol+" ")
+	}
+	if target == "" {
+		gw.logger.Debugf("ignoring reaction to message %s that isn't on %s", canonical, dest.Account)
+		return false
+	}
+	reaction := *msg.Reaction
+	reaction.TargetID = target
+	msg.Reaction = &reaction
+	return true
+}
+
+// reactionText returns the text of a reaction for bridges without reactions.
+func reactionText(reaction *config.Reaction, excerpt interface{}) string {
+	text := "reacted " + reaction.Emoji + " to "
+	if reaction.Removed {
+		text = "removed the reaction " + reaction.Emoji + " from "
+	}
+	if quote, ok := excerpt.(*config.Quote); ok {
+		return text + "“" + quote.Text + "”"
+	}
+	return text + "a message"
+}
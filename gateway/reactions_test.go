@@ -0,0 +1,56 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReactions(t *testing.T) {
+	r, _ := maketestRouterWithBridgers(t, testconfig)
+	gw := r.Gateways["bridge1"]
+	irc := r.getBridge("irc.freenode").Bridger.(*testBridger)
+	slack := r.getBridge("slack.test").Bridger.(*testBridger)
+	gitter := r.getBridge("gitter.42wim").Bridger.(*testBridger)
+
+	r.Message <- config.Message{Text: "hello  world " + strings.Repeat("x", 60), Channel: "#wimtesting", Account: "irc.freenode", ID: "1"}
+	assert.Eventually(t, func() bool {
+		ids, ok := gw.Messages.Get("irc 1")
+		return ok && len(ids) == 3
+	}, time.Second, time.Millisecond)
+
+	// reactions go to the message on bridges with reactions, and are sent as text on the others
+	r.Message <- config.Message{
+		Username: "alice", Channel: "general", Account: "discord.test", Event: config.EventReaction,
+		Reaction: &config.Reaction{Emoji: ":+1:", TargetID: "1"},
+	}
+	assert.Eventually(t, func() bool { return len(slack.messages()) == 2 && len(irc.messages()) == 1 }, time.Second, time.Millisecond)
+	reaction := slack.messages()[1]
+	assert.Equal(t, config.EventReaction, reaction.Event)
+	assert.Equal(t, &config.Reaction{Emoji: "👍", TargetID: "1"}, reaction.Reaction)
+	text := irc.messages()[0]
+	assert.Equal(t, config.EventUserAction, text.Event)
+	assert.Nil(t, text.Reaction)
+	assert.Equal(t, "reacted 👍 to “hello world "+strings.Repeat("x", 38)+"…”", text.Text)
+
+	r.Message <- config.Message{
+		Username: "alice", Channel: "general", Account: "discord.test", Event: config.EventReaction,
+		Reaction: &config.Reaction{Emoji: "👍", TargetID: "1", Removed: true},
+	}
+	assert.Eventually(t, func() bool { return len(gitter.messages()) == 3 }, time.Second, time.Millisecond)
+	assert.True(t, strings.HasPrefix(gitter.messages()[2].Text, "removed the reaction 👍 from “hello world"))
+
+	// reactions to unknown messages are dropped
+	r.Message <- config.Message{
+		Username: "alice", Channel: "general", Account: "discord.test", Event: config.EventReaction,
+		Reaction: &config.Reaction{Emoji: "👍", TargetID: "unknown"},
+	}
+	r.Message <- config.Message{Text: "next", Channel: "general", Account: "discord.test"}
+	assert.Eventually(t, func() bool {
+		return len(slack.messages()) == 4 && len(irc.messages()) == 3 && len(gitter.messages()) == 4
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "next", slack.messages()[3].Text)
+}
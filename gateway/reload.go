@@ -0,0 +1,333 @@
+package gateway
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// Reload brings the gateways in line with the (reloaded) configuration.
+// Gateways and accounts that were added are set up and connected before they
+// get any messages, accounts that aren't used anymore are disconnected and
+// bridges that are still in use stay connected and join their new channels.
+// Accounts whose own configuration section changed are disconnected and set up
+// again with the new configuration. The bridges that are running get new channel
+// maps, the maps they have are never changed while messages are relayed.
+func (r *Router) Reload() error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	entries, err := r.gatewayConfigs()
+	if err != nil {
+		return err
+	}
+	// validate before changing anything, setting up a gateway exits on bad accounts
+	for _, entry := range entries {
+		for _, br := range append(entry.In, append(entry.InOut, entry.Out...)...) {
+			if err := r.checkAccount(br.Account); err != nil {
+				return fmt.Errorf("gateway %s: %s", entry.Name, err)
+			}
+		}
+	}
+
+	// a broken script only fails the messages it runs for, so it doesn't stop the reload
+	if err := r.compileTengoScripts(); err != nil {
+		r.logger.Warn(err)
+	}
+
+	r.RLock()
+	oldGateways := r.Gateways
+	oldBridges := r.bridges()
+	r.RUnlock()
+
+	// accounts with a changed configuration get a new bridge
+	changed := make(map[string]bool)
+	for account := range oldBridges {
+		if !reflect.DeepEqual(r.settings[account], r.accountSettings(account)) {
+			changed[account] = true
+		}
+	}
+
+	// build the new gateways aside, so they don't get any messages yet
+	r.reloading = make(map[string]*Gateway)
+	r.reloadChannels = make(map[*bridge.Bridge]map[string]config.ChannelInfo)
+	r.restarting = changed
+	for _, entry := range entries {
+		gw, ok := oldGateways[entry.Name]
+		switch {
+		case !ok:
+			r.logger.Infof("Adding gateway %s", entry.Name)
+			gw = New(r.logger.Logger, entry, r)
+		case !reflect.DeepEqual(gw.MyConfig, entry) || usesAccount(gw, changed):
+			r.logger.Infof("Updating gateway %s", entry.Name)
+			gw = newGateway(r.logger.Logger, entry, r, gw.Messages)
+		}
+		r.reloading[entry.Name] = gw
+	}
+	gateways := r.reloading
+	channels := r.reloadChannels
+	r.reloading = nil
+	r.reloadChannels = nil
+	r.restarting = nil
+
+	// the old connection has to be gone before the new one logs in with the same account
+	for account := range changed {
+		r.logger.Infof("Stopping bridge %s, its configuration changed", account)
+		if err := oldBridges[account].Disconnect(); err != nil {
+			r.logger.Errorf("Disconnect() %s failed: %s", account, err)
+		}
+	}
+
+	// connect the new bridges at the same time, so a slow login doesn't hold up the others
+	started := r.startBridges(gateways, oldBridges, changed)
+	for account, err := range started {
+		if err == nil {
+			continue
+		}
+		for _, gw := range gateways {
+			delete(gw.Bridges, account)
+		}
+	}
+	select {
+	case <-r.stopped:
+		// Stop doesn't know about these bridges
+		for account, err := range started {
+			if err == nil {
+				if err := bridgesOf(gateways)[account].Disconnect(); err != nil {
+					r.logger.Errorf("Disconnect() %s failed: %s", account, err)
+				}
+			}
+		}
+		return errStopping
+	default:
+	}
+
+	r.Lock()
+	r.Gateways = gateways
+	bridges := r.bridges()
+	for account, br := range bridges {
+		if _, ok := started[account]; !ok {
+			r.unmapChannels(br, channels[br])
+		}
+	}
+	r.Unlock()
+
+	for name, gw := range oldGateways {
+		if _, ok := gateways[name]; !ok {
+			r.logger.Infof("Removing gateway %s", name)
+			if err := gw.Messages.Close(); err != nil {
+				r.logger.Errorf("Closing message store of %s failed: %s", name, err)
+			}
+		}
+	}
+
+	for account := range r.settings {
+		delete(r.settings, account)
+	}
+	for account := range bridges {
+		r.settings[account] = r.accountSettings(account)
+	}
+
+	for account, br := range oldBridges {
+		if changed[account] {
+			continue
+		}
+		if _, ok := bridges[account]; !ok {
+			r.logger.Infof("Stopping bridge: %s", account)
+			if err := br.Disconnect(); err != nil {
+				r.logger.Errorf("Disconnect() %s failed: %s", account, err)
+			}
+			continue
+		}
+		if err := br.JoinChannels(); err != nil {
+			r.logger.Errorf("JoinChannels() %s failed: %s", account, err)
+		}
+		if reloader, ok := br.Bridger.(bridge.Reloader); ok {
+			res, err := reloader.Reload(&bridge.Config{Bridge: br, Remote: r.Message, Statuses: r.Statuses})
+			if err != nil {
+				r.logger.Errorf("Reload() %s failed: %s", account, err)
+			} else if res != "" {
+				r.logger.Infof("Reload() %s: %s", account, res)
+			}
+		}
+	}
+	return nil
+}
+
+// checkAccount returns an error if a gateway can't be set up with the account.
+func (r *Router) checkAccount(account string) error {
+	accInfo := strings.Split(account, ".")
+	if len(accInfo) != 2 {
+		return fmt.Errorf("account incorrect: %s", account)
+	}
+	if _, ok := r.BridgeMap[accInfo[0]]; !ok {
+		return fmt.Errorf("incorrect protocol %s specified for %s", accInfo[0], account)
+	}
+	for _, key := range r.Viper().AllKeys() {
+		if strings.HasPrefix(key, strings.ToLower(account)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("account %s defined but no configuration found", account)
+}
+
+// gatewaySettings are the account settings the gateway reads for every message,
+// changing them doesn't need a new connection.
+var gatewaySettings = map[string]bool{
+	"extractnicks":        true,
+	"iconurl":             true,
+	"ignoremessages":      true,
+	"ignorenicks":         true,
+	"label":               true,
+	"mentionaliases":      true,
+	"messageclipped":      true,
+	"preservethreading":   true,
+	"reconnectmaxdelay":   true,
+	"remotenickformat":    true,
+	"replacemessages":     true,
+	"replacenicks":        true,
+	"replyfallbackformat": true,
+	"retrymaxage":         true,
+	"retrymaxattempts":    true,
+	"showjoinpart":        true,
+	"showtopicchange":     true,
+	"stripnick":           true,
+	"synctopic":           true,
+}
+
+// accountSettings returns the configuration section of the account without the
+// gatewaySettings. The bridges keep the [general] values they're set up with, so
+// the ones they read are part of it too.
+func (r *Router) accountSettings(account string) map[string]interface{} {
+	settings := make(map[string]interface{})
+	for key, value := range r.Viper().GetStringMap(account) {
+		if !gatewaySettings[key] {
+			settings[key] = value
+		}
+	}
+	general := r.BridgeValues().General
+	settings["[general]"] = []interface{}{
+		general.MediaDownloadBlackList, general.MediaDownloadPath, general.MediaDownloadSize,
+		general.MediaServerDownload, general.MediaServerUpload,
+	}
+	return settings
+}
+
+// lookupBridge returns the bridge for the account from the running gateways
+// or the gateways being set up by a reload. Accounts that are restarted by the
+// reload only come from the latter.
+func (r *Router) lookupBridge(account string) *bridge.Bridge {
+	if br := r.getBridge(account); br != nil && !r.restarting[account] {
+		return br
+	}
+	return bridgesOf(r.reloading)[account]
+}
+
+// usesAccount returns true if gw has a bridge for any of the accounts.
+func usesAccount(gw *Gateway, accounts map[string]bool) bool {
+	for account := range gw.Bridges {
+		if accounts[account] {
+			return true
+		}
+	}
+	return false
+}
+
+// bridges returns all bridges used by the gateways of the router.
+func (r *Router) bridges() map[string]*bridge.Bridge {
+	return bridgesOf(r.Gateways)
+}
+
+// startBridges connects and joins the bridges of gateways that aren't in running or
+// are changed, all at the same time. It returns the accounts it started with the
+// error they failed with, if any.
+func (r *Router) startBridges(gateways map[string]*Gateway, running map[string]*bridge.Bridge, changed map[string]bool) map[string]error {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		started = make(map[string]error)
+	)
+	for account, br := range bridgesOf(gateways) {
+		if _, ok := running[account]; ok && !changed[account] {
+			continue
+		}
+		wg.Add(1)
+		go func(account string, br *bridge.Bridge) {
+			defer wg.Done()
+			r.logger.Infof("Starting bridge: %s ", account)
+			r.setState(br, bridge.StateConnecting, nil)
+			err := br.Connect()
+			if err == nil {
+				err = br.JoinChannels()
+			}
+			if err == nil {
+				r.setState(br, bridge.StateConnected, nil)
+			} else {
+				r.setState(br, bridge.StateFailed, err)
+				r.logger.Errorf("Bridge %s failed to start: %v", account, err)
+			}
+			mu.Lock()
+			started[account] = err
+			mu.Unlock()
+		}(account, br)
+	}
+	wg.Wait()
+	return started
+}
+
+// bridgeChannels returns the channel map a gateway being set up adds the channels of
+// br to. During a reload the bridges that are running get a copy, that replaces
+// their map when the reload is done.
+func (r *Router) bridgeChannels(br *bridge.Bridge) map[string]config.ChannelInfo {
+	if r.reloadChannels == nil || r.getBridge(br.Account) != br {
+		return br.Channels
+	}
+	if channels, ok := r.reloadChannels[br]; ok {
+		return channels
+	}
+	channels := make(map[string]config.ChannelInfo, len(br.Channels))
+	for ID, channel := range br.Channels {
+		channels[ID] = channel
+	}
+	r.reloadChannels[br] = channels
+	return channels
+}
+
+// unmapChannels gives br new channel maps with the channels, or the ones br has if
+// channels is nil, that are still used by a gateway. The router must be locked.
+func (r *Router) unmapChannels(br *bridge.Bridge, channels map[string]config.ChannelInfo) {
+	if channels == nil {
+		channels = br.Channels
+	}
+	used := make(map[string]config.ChannelInfo, len(channels))
+	joined := make(map[string]bool, len(br.Joined))
+	for ID, channel := range channels {
+		for _, gw := range r.Gateways {
+			if _, ok := gw.Channels[ID]; ok {
+				used[ID] = channel
+				if br.Joined[ID] {
+					joined[ID] = true
+				}
+				break
+			}
+		}
+	}
+	br.Lock()
+	br.Channels = used
+	br.Joined = joined
+	br.Unlock()
+}
+
+func bridgesOf(gateways map[string]*Gateway) map[string]*bridge.Bridge {
+	bridges := make(map[string]*bridge.Bridge)
+	for _, gw := range gateways {
+		for account, br := range gw.Bridges {
+			bridges[account] = br
+		}
+	}
+	return bridges
+}
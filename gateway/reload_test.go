@@ -0,0 +1,215 @@
+package gateway
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testBridger struct {
+	sync.Mutex
+
+	connected   bool
+	disconnects int
+	joined      []string
+	sent        []config.Message
+	block       chan struct{}
+	fail        error
+	caps        bridge.Capabilities
+	members     config.ChannelMembers
+	remote      chan config.Message
+	account     string
+}
+
+// testCapabilities are the capabilities of the testBridgers of the protocols
+// that don't have the bridge.DefaultCapabilities.
+var testCapabilities = map[string]bridge.Capabilities{
+	"api":      {Edits: true, Deletes: true, Threads: true, Reactions: true, Files: true, Formatting: true},
+	"grpc":     {Edits: true, Deletes: true, Threads: true, Reactions: true, Files: true, Formatting: true},
+	"irc":      {Files: true, Notices: true, Formatting: true},
+	"discord":  {Edits: true, Deletes: true, Threads: true, Reactions: true, Files: true, UserTyping: true, Formatting: true},
+	"slack":    {Edits: true, Deletes: true, Threads: true, Reactions: true, Files: true, UserTyping: true, Formatting: true},
+	"telegram": {Edits: true, Deletes: true, Threads: true, Files: true, Members: true, Formatting: true},
+}
+
+// testMembers are the channel members the testBridgers of the protocols answer
+// EventGetChannelMembers with.
+var testMembers = map[string]config.ChannelMembers{
+	"telegram": {{Username: "alice", Nick: "Alice", UserID: "42"}},
+}
+
+func (b *testBridger) Connect() error    { b.connected = true; return nil }
+func (b *testBridger) Disconnect() error { b.connected = false; b.disconnects++; return nil }
+
+func (b *testBridger) Capabilities() bridge.Capabilities {
+	b.Lock()
+	defer b.Unlock()
+	return b.caps
+}
+
+// Send records the message and returns its index as message ID, when block
+// is set it waits until block is closed and when fail is set it returns fail.
+// EventGetChannelMembers is answered with the members instead.
+func (b *testBridger) Send(msg config.Message) (string, error) {
+	if msg.Event == config.EventGetChannelMembers {
+		if b.members != nil {
+			b.remote <- helper.ChannelMembersMessage(b.account, b.members)
+		}
+		return "", nil
+	}
+	if b.block != nil {
+		<-b.block
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.fail != nil {
+		return "", b.fail
+	}
+	b.sent = append(b.sent, msg)
+	return strconv.Itoa(len(b.sent)), nil
+}
+
+func (b *testBridger) setCapabilities(caps bridge.Capabilities) {
+	b.Lock()
+	b.caps = caps
+	b.Unlock()
+}
+
+func (b *testBridger) setFail(err error) {
+	b.Lock()
+	b.fail = err
+	b.Unlock()
+}
+
+func (b *testBridger) messages() []config.Message {
+	b.Lock()
+	defer b.Unlock()
+	return append([]config.Message(nil), b.sent...)
+}
+
+func (b *testBridger) JoinChannel(channel config.ChannelInfo) error {
+	b.joined = append(b.joined, channel.Name)
+	return nil
+}
+
+// maketestRouterWithBridgers returns a started router using testBridgers for all bridges.
+func maketestRouterWithBridgers(t *testing.T, input []byte) (*Router, config.Config) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	cfg := config.NewConfigFromString(logger, input)
+	bridgeMap := make(map[string]bridge.Factory)
+	for _, protocol := range []string{"api", "grpc", "irc", "gitter", "discord", "slack", "mattermost", "telegram"} {
+		caps, ok := testCapabilities[protocol]
+		if !ok {
+			caps = bridge.DefaultCapabilities
+		}
+		members := testMembers[protocol]
+		bridgeMap[protocol] = func(cfg *bridge.Config) bridge.Bridger {
+			return &testBridger{caps: caps, members: members, remote: cfg.Remote, account: cfg.Account}
+		}
+	}
+	r, err := NewRouter(logger, cfg, bridgeMap)
+	require.NoError(t, err)
+	require.NoError(t, r.Start())
+	return r, cfg
+}
+
+func TestReload(t *testing.T) {
+	r, cfg := maketestRouterWithBridgers(t, testconfig)
+
+	irc := r.getBridge("irc.freenode")
+	gitter := r.getBridge("gitter.42wim")
+
+	// drop gitter, add a channel to irc and a new gateway with mattermost
+	cfg.BridgeValues().Gateway = []config.Gateway{{
+		Name:   "bridge1",
+		Enable: true,
+		InOut: []config.Bridge{
+			{Account: "irc.freenode", Channel: "#wimtesting"},
+			{Account: "irc.freenode", Channel: "#wimtesting2"},
+			{Account: "discord.test", Channel: "general"},
+			{Account: "slack.test", Channel: "testing"},
+		},
+	}, {
+		Name:   "bridge2",
+		Enable: true,
+		InOut: []config.Bridge{
+			{Account: "discord.test", Channel: "general2"},
+			{Account: "mattermost.test", Channel: "town-square"},
+		},
+	}}
+	require.NoError(t, r.Reload())
+
+	assert.Len(t, r.Gateways, 2)
+	assert.Same(t, irc, r.getBridge("irc.freenode"))
+	assert.True(t, irc.Bridger.(*testBridger).connected)
+	assert.ElementsMatch(t, []string{"#wimtesting", "#wimtesting2"}, irc.Bridger.(*testBridger).joined)
+	assert.False(t, gitter.Bridger.(*testBridger).connected)
+	assert.Nil(t, r.getBridge("gitter.42wim"))
+	assert.Len(t, r.getBridge("discord.test").Channels, 2)
+	assert.True(t, r.getBridge("mattermost.test").Bridger.(*testBridger).connected)
+
+	// an unknown account doesn't change anything
+	cfg.BridgeValues().Gateway[1].InOut = append(cfg.BridgeValues().Gateway[1].InOut, config.Bridge{Account: "irc.unknown", Channel: "#x"})
+	assert.Error(t, r.Reload())
+	assert.Len(t, r.getBridge("discord.test").Channels, 2)
+}
+
+func TestReloadChangedAccount(t *testing.T) {
+	r, cfg := maketestRouterWithBridgers(t, testconfig)
+
+	irc := r.getBridge("irc.freenode")
+	gitter := r.getBridge("gitter.42wim")
+
+	// only the configuration of irc changes
+	changed := bytes.Replace(testconfig, []byte("[irc.freenode]\n"), []byte("[irc.freenode]\nnick=\"other\"\n"), 1)
+	require.NoError(t, cfg.Viper().ReadConfig(bytes.NewBuffer(changed)))
+	require.NoError(t, r.Reload())
+
+	assert.NotSame(t, irc, r.getBridge("irc.freenode"))
+	assert.False(t, irc.Bridger.(*testBridger).connected)
+	assert.True(t, r.getBridge("irc.freenode").Bridger.(*testBridger).connected)
+	assert.Equal(t, []string{"#wimtesting"}, r.getBridge("irc.freenode").Bridger.(*testBridger).joined)
+	assert.Same(t, gitter, r.getBridge("gitter.42wim"))
+	assert.Same(t, r.getBridge("irc.freenode"), r.Gateways["bridge1"].Bridges["irc.freenode"])
+
+	// reloading again doesn't restart it, neither do settings the gateway reads for every message
+	irc = r.getBridge("irc.freenode")
+	changed = bytes.Replace(changed, []byte("[irc.freenode]\n"), []byte("[irc.freenode]\nRemoteNickFormat=\"{NICK}\"\n"), 1)
+	require.NoError(t, cfg.Viper().ReadConfig(bytes.NewBuffer(changed)))
+	require.NoError(t, r.Reload())
+	assert.Same(t, irc, r.getBridge("irc.freenode"))
+}
+
+func TestReloadWhileRunning(t *testing.T) {
+	r, cfg := maketestRouterWithBridgers(t, testconfig)
+	irc := r.getBridge("irc.freenode")
+	channels := irc.Channels
+
+	// the channels of the running bridges can be read while a reload changes them
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			r.handleAdminBridges(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/bridges", nil))
+		}
+	}()
+	values := cfg.BridgeValues()
+	values.Gateway[0].InOut = append(values.Gateway[0].InOut, config.Bridge{Account: "irc.freenode", Channel: "#wimtesting2"})
+	require.NoError(t, r.Reload())
+	<-done
+
+	assert.Len(t, irc.Channels, 2)
+	assert.Len(t, channels, 1)
+}
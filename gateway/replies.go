@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/richtext"
+)
+
+// addQuote sets the quote of the message that msg replies to, if it's known and
+// the bridge didn't set it.
+func (gw *Gateway) addQuote(msg *config.Message) {
+	if msg.Quote != nil || msg.ParentID == "" || msg.ParentNotFound() {
+		return
+	}
+	if msg.Event != "" && msg.Event != config.EventUserAction {
+		return
+	}
+	canonical := gw.FindCanonicalMsgID(msg.Protocol, msg.ParentID)
+	if canonical == "" {
+		return
+	}
+	if quote, ok := gw.excerpts.Get(canonical); ok {
+		msg.Quote = quote.(*config.Quote)
+	}
+}
+
+// handleReplyFallback puts the quote of the reply msg in its text with the
+// ReplyFallbackFormat of dest, when the reply isn't threaded on dest and the
+// bridge it came from didn't quote it already.
+func (gw *Gateway) handleReplyFallback(msg *config.Message, dest *bridge.Bridge) {
+	if msg.Quote == nil || msg.Quote.InText || msg.ParentValid() {
+		return
+	}
+	if msg.Event != "" && msg.Event != config.EventUserAction {
+		return
+	}
+	format := dest.GetString("ReplyFallbackFormat")
+	if format == "" {
+		return
+	}
+	msg.SetRichText(replyFallback(format, msg.Quote, msg.RichText()))
+	// the quote already tells it's a reply
+	msg.ParentID = ""
+}
+
+// replyFallback returns doc with quote in the format, which has the message at
+// {MESSAGE} or else after the quote. The quote is markdown, like the format, the
+// nick is text.
+func replyFallback(format string, quote *config.Quote, doc richtext.Document) richtext.Document {
+	replacer := strings.NewReplacer("{QUOTENICK}", richtext.EscapeMarkdown(quote.Username), "{QUOTEMESSAGE}", quote.Text)
+	parts := strings.SplitN(format, "{MESSAGE}", 2)
+	if len(parts) == 1 {
+		parts = append(parts, "")
+	}
+	res := append(richtext.ParseMarkdown(replacer.Replace(parts[0])), doc...)
+	return append(res, richtext.ParseMarkdown(replacer.Replace(parts[1]))...)
+}
@@ -0,0 +1,107 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/richtext"
+	"github.com/stretchr/testify/assert"
+)
+
+var repliesTestConfig = []byte(`
+[general]
+ReplyFallbackFormat="↪ {QUOTENICK}: {QUOTEMESSAGE}\n"
+[irc.freenode]
+server=""
+[gitter.42wim]
+ReplyFallbackFormat=""
+[discord.test]
+server=""
+[slack.test]
+PreserveThreading=true
+
+[[gateway]]
+name = "bridge1"
+enable=true
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+    [[gateway.inout]]
+    account="gitter.42wim"
+    channel="42wim/testroom"
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+    [[gateway.inout]]
+    account="slack.test"
+    channel="testing"
+`)
+
+func TestReplies(t *testing.T) {
+	r, _ := maketestRouterWithBridgers(t, repliesTestConfig)
+	gw := r.Gateways["bridge1"]
+	irc := r.getBridge("irc.freenode").Bridger.(*testBridger)
+	gitter := r.getBridge("gitter.42wim").Bridger.(*testBridger)
+	slack := r.getBridge("slack.test").Bridger.(*testBridger)
+
+	r.Message <- config.Message{Username: "bob", Text: "original  **text**", Channel: "general", Account: "discord.test", ID: "1"}
+	assert.Eventually(t, func() bool {
+		ids, ok := gw.Messages.Get("discord 1")
+		return ok && len(ids) == 3
+	}, time.Second, time.Millisecond)
+
+	r.Message <- config.Message{Username: "alice", Text: "reply", Channel: "general", Account: "discord.test", ID: "2", ParentID: "1"}
+	assert.Eventually(t, func() bool {
+		ids, ok := gw.Messages.Get("discord 2")
+		return ok && len(ids) == 3
+	}, time.Second, time.Millisecond)
+
+	// bridges without threading get the quote in the text
+	msg := irc.messages()[1]
+	assert.Equal(t, "↪ bob: original **text**\nreply", msg.Text)
+	assert.Equal(t, "", msg.ParentID)
+	assert.Equal(t, &config.Quote{Username: "bob", Text: "original **text**"}, msg.Quote)
+	// unless they have no ReplyFallbackFormat
+	msg = gitter.messages()[1]
+	assert.Equal(t, "reply", msg.Text)
+	assert.Equal(t, config.ParentIDNotFound, msg.ParentID)
+	// threaded replies don't need the quote
+	msg = slack.messages()[1]
+	assert.Equal(t, "reply", msg.Text)
+	assert.Equal(t, "1", msg.ParentID)
+
+	// replies the bridge already quoted aren't quoted again
+	quote := &config.Quote{Username: "bob", Text: "original **text**", InText: true}
+	r.Message <- config.Message{Username: "alice", Text: "reply (re @bob: original **text**)", Channel: "general",
+		Account: "discord.test", ID: "3", ParentID: "1", Quote: quote}
+	assert.Eventually(t, func() bool {
+		ids, ok := gw.Messages.Get("discord 3")
+		return ok && len(ids) == 3
+	}, time.Second, time.Millisecond)
+	msg = irc.messages()[2]
+	assert.Equal(t, "reply (re @bob: original **text**)", msg.Text)
+	assert.Equal(t, quote, msg.Quote)
+}
+
+func TestReplyFallback(t *testing.T) {
+	quote := &config.Quote{Username: "bob", Text: "hi"}
+	doc := richtext.Document{{Kind: richtext.Mention, UserID: "1", Text: "bob"}}
+	// the quote is markdown like the format
+	assert.Equal(t, richtext.Document{
+		{Kind: richtext.Quote, Children: []richtext.Node{{Kind: richtext.Text, Text: "bob: hi"}}},
+		{Kind: richtext.Text, Text: "\n"}, doc[0],
+	}, replyFallback("> {QUOTENICK}: {QUOTEMESSAGE}\n", quote, doc))
+	assert.Equal(t, richtext.Document{
+		doc[0], {Kind: richtext.Text, Text: " (re bob: hi)"},
+	}, replyFallback("{MESSAGE} (re {QUOTENICK}: {QUOTEMESSAGE})", quote, doc))
+
+	// the nick isn't formatting
+	quote = &config.Quote{Username: "_foo*bar_`x`", Text: "**hi**"}
+	assert.Equal(t, richtext.Document{
+		{Kind: richtext.Bold, Children: []richtext.Node{{Kind: richtext.Text, Text: "_foo*bar_`x`"}}},
+		{Kind: richtext.Text, Text: ": "},
+		{Kind: richtext.Bold, Children: []richtext.Node{{Kind: richtext.Text, Text: "hi"}}},
+		{Kind: richtext.Text, Text: " "}, doc[0],
+	}, replyFallback("**{QUOTENICK}**: {QUOTEMESSAGE} {MESSAGE}", quote, doc))
+}
@@ -0,0 +1,311 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/jpillora/backoff"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultRetryMaxAge = time.Hour
+
+var retryBackoff = &backoff.Backoff{
+	Min:    5 * time.Second,
+	Max:    5 * time.Minute,
+	Factor: 2,
+	Jitter: true,
+}
+
+// retryEntry is a send that failed and waits to be retried.
+type retryEntry struct {
+	Gateway  string             `json:"gateway"`
+	Account  string             `json:"account"`
+	Channel  config.ChannelInfo `json:"channel"`
+	Message  config.Message     `json:"message"`
+	Key      string             `json:"key"`
+	Attempts int                `json:"attempts"`
+	First    time.Time          `json:"first"`
+	Next     time.Time          `json:"next"`
+	Error    string             `json:"error"`
+}
+
+// retryQueue holds the failed sends of a destination account until they're due again.
+// It's paused while the account reconnects.
+type retryQueue struct {
+	sync.Mutex
+
+	r       *Router
+	account string
+	path    string
+	entries []*retryEntry
+	paused  bool
+	wake    chan struct{}
+}
+
+// getRetryQueue returns the retry queue of the account, loading and starting it if needed.
+func (r *Router) getRetryQueue(account string) *retryQueue {
+	r.retriesMu.Lock()
+	defer r.retriesMu.Unlock()
+	if q, ok := r.retries[account]; ok {
+		return q
+	}
+	q := &retryQueue{r: r, account: account, wake: make(chan struct{}, 1)}
+	if dir := r.BridgeValues().General.RetryQueuePath; dir != "" {
+		q.path = filepath.Join(dir, account+".retry")
+		if err := q.load(); err != nil {
+			r.logger.Errorf("Loading retry queue %s failed: %s", q.path, err)
+		}
+	}
+	r.retries[account] = q
+	go q.run()
+	return q
+}
+
+// loadRetryQueues starts the retry queues persisted in the RetryQueuePath.
+func (r *Router) loadRetryQueues() {
+	dir := r.BridgeValues().General.RetryQueuePath
+	if dir == "" {
+		return
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		r.logger.Errorf("Creating retry queue directory failed: %s", err)
+		return
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.retry"))
+	for _, file := range files {
+		r.getRetryQueue(strings.TrimSuffix(filepath.Base(file), ".retry"))
+	}
+}
+
+// handleSendFailure queues a failed send for a retry, or gives up on it when the destination
+// doesn't retry or it has been tried too often already. It returns true if the send is retried.
+func (gw *Gateway) handleSendFailure(job *sendJob, sendErr error) bool {
+	maxAttempts := job.dest.GetInt("RetryMaxAttempts")
+	if maxAttempts <= 0 {
+		gw.logger.Errorf("SendMessage failed: %s", sendErr)
+		return false
+	}
+	maxAge := time.Duration(job.dest.GetInt("RetryMaxAge")) * time.Second
+	if maxAge <= 0 {
+		maxAge = defaultRetryMaxAge
+	}
+
+	entry := job.retry
+	if entry == nil {
+		entry = &retryEntry{
+			Gateway: gw.Name,
+			Account: job.dest.Account,
+			Channel: job.channel,
+			Message: job.rmsg,
+			First:   time.Now(),
+		}
+		if job.delivery != nil && job.delivery.store {
+			entry.Key = job.delivery.key
+		}
+	}
+	entry.Attempts++
+	entry.Error = sendErr.Error()
+	if entry.Attempts >= maxAttempts || time.Since(entry.First) > maxAge {
+		gw.Router.deadLetter(entry)
+		return false
+	}
+	gw.logger.Errorf("SendMessage to %s (%s) failed (attempt %d of %d), retrying: %s",
+		entry.Account, entry.Channel.Name, entry.Attempts, maxAttempts, sendErr)
+	gw.Router.getRetryQueue(entry.Account).add(entry)
+	return true
+}
+
+// deadLetter logs a message we gave up on and appends it to the DeadLetterFile.
+func (r *Router) deadLetter(e *retryEntry) {
+	r.logger.WithFields(logrus.Fields{
+		"gateway":  e.Gateway,
+		"account":  e.Account,
+		"channel":  e.Channel.Name,
+		"attempts": e.Attempts,
+	}).Errorf("Giving up on sending message from %s: %s", e.Message.Account, e.Error)
+
+	file := r.BridgeValues().General.DeadLetterFile
+	if file == "" {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		r.logger.Errorf("Encoding dead letter failed: %s", err)
+		return
+	}
+	r.retriesMu.Lock()
+	defer r.retriesMu.Unlock()
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		r.logger.Errorf("Opening dead letter file failed: %s", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		r.logger.Errorf("Writing dead letter file failed: %s", err)
+	}
+}
+
+// resend queues a retry entry for its destination again.
+func (r *Router) resend(e *retryEntry) {
+	r.RLock()
+	var dest *bridge.Bridge
+	gw := r.Gateways[e.Gateway]
+	if gw != nil {
+		dest = gw.Bridges[e.Account]
+	}
+	r.RUnlock()
+	if dest == nil {
+		e.Error = "gateway or account isn't configured anymore"
+		r.deadLetter(e)
+		return
+	}
+	r.enqueue(&sendJob{gw: gw, rmsg: e.Message, dest: dest, channel: e.Channel, retry: e})
+}
+
+func (q *retryQueue) add(e *retryEntry) {
+	q.Lock()
+	e.Next = time.Now().Add(retryBackoff.ForAttempt(float64(e.Attempts - 1)))
+	q.entries = append(q.entries, e)
+	q.save()
+	q.Unlock()
+	q.signal()
+}
+
+// pause stops retrying until flush is called, eg while the account reconnects.
+func (q *retryQueue) pause() {
+	q.Lock()
+	q.paused = true
+	q.Unlock()
+}
+
+// flush retries all entries right away.
+func (q *retryQueue) flush() {
+	q.Lock()
+	q.paused = false
+	for _, e := range q.entries {
+		e.Next = time.Time{}
+	}
+	q.Unlock()
+	q.signal()
+}
+
+func (q *retryQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *retryQueue) run() {
+	for {
+		wait := time.Minute
+		var due []*retryEntry
+		q.Lock()
+		if !q.paused {
+			now := time.Now()
+			var keep []*retryEntry
+			for _, e := range q.entries {
+				if !e.Next.After(now) {
+					due = append(due, e)
+					continue
+				}
+				keep = append(keep, e)
+				if d := e.Next.Sub(now); d < wait {
+					wait = d
+				}
+			}
+			q.entries = keep
+			if len(due) > 0 {
+				q.save()
+			}
+		}
+		q.Unlock()
+
+		for _, e := range due {
+			q.r.resend(e)
+		}
+		if len(due) > 0 {
+			continue
+		}
+		select {
+		case <-q.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// save writes the entries to the queue file, if the queue is persisted.
+func (q *retryQueue) save() {
+	if q.path == "" {
+		return
+	}
+	if len(q.entries) == 0 {
+		if err := os.Remove(q.path); err != nil && !os.IsNotExist(err) {
+			q.r.logger.Errorf("Removing retry queue %s failed: %s", q.path, err)
+		}
+		return
+	}
+	data, err := json.Marshal(q.entries)
+	if err == nil {
+		err = ioutil.WriteFile(q.path+".tmp", data, 0600)
+	}
+	if err == nil {
+		err = os.Rename(q.path+".tmp", q.path)
+	}
+	if err != nil {
+		q.r.logger.Errorf("Saving retry queue %s failed: %s", q.path, err)
+	}
+}
+
+func (q *retryQueue) load() error {
+	data, err := ioutil.ReadFile(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &q.entries); err != nil {
+		return err
+	}
+	for _, e := range q.entries {
+		if err := restoreFileInfo(&e.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFileInfo turns the decoded files of a message back into config.FileInfo,
+// as bridges expect them. Other extra data can't be restored and is dropped.
+func restoreFileInfo(msg *config.Message) error {
+	extra := msg.Extra
+	msg.Extra = nil
+	for _, key := range []string{"file", config.EventFileFailureSize} {
+		for _, f := range extra[key] {
+			data, err := json.Marshal(f)
+			if err != nil {
+				return err
+			}
+			var fi config.FileInfo
+			if err := json.Unmarshal(data, &fi); err != nil {
+				return errors.New("invalid file in retry queue: " + err.Error())
+			}
+			if msg.Extra == nil {
+				msg.Extra = make(map[string][]interface{})
+			}
+			msg.Extra[key] = append(msg.Extra[key], fi)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// msgRoutes are the destinations the Route script picked for a message.
+type msgRoutes struct {
+	// only is true when the message is only sent to the routes and not to the
+	// channels of its gateways.
+	only bool
+	// IDs of the channels the message is routed to.
+	channels []string
+	dests    map[string]string
+	done     map[string]bool
+}
+
+// routeMessage runs the Route script for msg. The script returns its destinations
+// in routes as "account:channel" strings.
+func (r *Router) routeMessage(msg *config.Message) *msgRoutes {
+	routes := &msgRoutes{dests: make(map[string]string), done: make(map[string]bool)}
+	filename := r.BridgeValues().Tengo.Route
+	if filename == "" {
+		return routes
+	}
+	c, err := tengoScripts.get("route", filename, "").run(routeTengoVars(msg))
+	if err != nil {
+		r.logger.Errorf("Tengo Route failed: %s", err)
+		return routes
+	}
+	for _, route := range c.Get("routes").Array() {
+		dest, ok := route.(string)
+		if !ok {
+			r.logger.Errorf("Tengo Route: %v is not a string", route)
+			continue
+		}
+		ID, err := routeChannelID(dest)
+		if err != nil {
+			r.logger.Errorf("Tengo Route: %s", err)
+			continue
+		}
+		routes.channels = append(routes.channels, ID)
+		routes.dests[ID] = dest
+	}
+	routes.only = c.Get("routeOnly").Bool()
+	return routes
+}
+
+func routeTengoVars(msg *config.Message) map[string]interface{} {
+	return map[string]interface{}{
+		"msg":         tengoMessage(msg),
+		"msgText":     msg.Text,
+		"msgUsername": msg.Username,
+		"msgUserID":   msg.UserID,
+		"msgAccount":  msg.Account,
+		"msgChannel":  msg.Channel,
+		"msgProtocol": msg.Protocol,
+		"routes":      []interface{}{},
+		"routeOnly":   false,
+	}
+}
+
+// routeChannelID returns the channel ID of an "account:channel" destination.
+func routeChannelID(dest string) (string, error) {
+	i := strings.Index(dest, ":")
+	if i <= 0 || i == len(dest)-1 {
+		return "", fmt.Errorf("destination %s isn't formatted as account:channel", dest)
+	}
+	account, channel := dest[:i], dest[i+1:]
+	// irc channels are lowercased in the config as well
+	if strings.HasPrefix(account, "irc.") {
+		channel = strings.ToLower(channel)
+	}
+	return channel + account, nil
+}
+
+// addJobs adds jobs for the routes to channels of gw to jobs, unless a job for
+// the channel is already there.
+func (routes *msgRoutes) addJobs(gw *Gateway, msg *config.Message, jobs []*sendJob) []*sendJob {
+	for _, ID := range routes.channels {
+		channel, ok := gw.Channels[ID]
+		if !ok || routes.done[ID] || !strings.Contains(channel.Direction, "out") {
+			continue
+		}
+		routes.done[ID] = true
+		dest := gw.Bridges[channel.Account]
+		if dest == nil || gw.ignoreDest(msg, dest) {
+			continue
+		}
+		queued := false
+		for _, job := range jobs {
+			if job.channel.ID == ID {
+				queued = true
+				break
+			}
+		}
+		if !queued {
+			jobs = append(jobs, gw.newSendJob(msg, dest, *channel))
+		}
+	}
+	return jobs
+}
+
+// dispatchRoutes queues the routes that weren't handled yet by the gateways the message
+// came in on. They're sent through the first gateway (by name) that has the channel.
+func (r *Router) dispatchRoutes(routes *msgRoutes, msg *config.Message) {
+	names := make([]string, 0, len(r.Gateways))
+	for name := range r.Gateways {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		gw := r.Gateways[name]
+		if r.isPaused(name) {
+			// paused gateways drop their routes too
+			for _, ID := range routes.channels {
+				if _, ok := gw.Channels[ID]; ok {
+					routes.done[ID] = true
+				}
+			}
+			continue
+		}
+		if jobs := routes.addJobs(gw, msg, nil); len(jobs) > 0 {
+			r.dispatch(gw, msg, jobs)
+		}
+	}
+	for _, ID := range routes.channels {
+		if !routes.done[ID] {
+			r.logger.Errorf("Tengo Route: %s isn't an out channel of any gateway", routes.dests[ID])
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoute(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "route.tengo")
+	require.NoError(t, ioutil.WriteFile(script, []byte(`
+text := import("text")
+if text.contains(msgText, "#ops") {
+    routes = append(routes, "discord.test:general2", "slack.test:testing", "irc.freenode:#unknown")
+    routeOnly = text.contains(msgText, "only")
+}
+`), 0600))
+	r, _ := maketestRouterWithBridgers(t, append([]byte("[tengo]\nRoute=\""+script+"\"\n"), testconfig2...))
+	discord := r.getBridge("discord.test").Bridger.(*testBridger)
+	slack := r.getBridge("slack.test").Bridger.(*testBridger)
+
+	channels := func(b *testBridger) []string {
+		var res []string
+		for _, msg := range b.messages() {
+			res = append(res, msg.Channel)
+		}
+		return res
+	}
+
+	// routes are added to the normal destinations, without sending twice
+	r.Message <- config.Message{Text: "#ops: disk full", Channel: "#wimtesting", Account: "irc.freenode", ID: "1"}
+	assert.Eventually(t, func() bool { return len(discord.messages()) == 2 }, time.Second, time.Millisecond)
+	assert.ElementsMatch(t, []string{"general", "general2"}, channels(discord))
+	assert.Eventually(t, func() bool { return len(slack.messages()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"testing"}, channels(slack))
+
+	// other messages aren't routed
+	r.Message <- config.Message{Text: "hello", Channel: "#wimtesting", Account: "irc.freenode", ID: "2"}
+	assert.Eventually(t, func() bool { return len(discord.messages()) == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, "general", discord.messages()[2].Channel)
+
+	// with routeOnly the normal destinations are skipped
+	r.Message <- config.Message{Text: "#ops only", Channel: "#wimtesting", Account: "irc.freenode", ID: "3"}
+	assert.Eventually(t, func() bool { return len(discord.messages()) == 4 }, time.Second, time.Millisecond)
+	assert.Equal(t, "general2", discord.messages()[3].Channel)
+	assert.Eventually(t, func() bool { return len(slack.messages()) == 3 }, time.Second, time.Millisecond)
+}
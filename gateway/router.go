@@ -1,13 +1,17 @@
 package gateway
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/gateway/samechannel"
+	"github.com/42wim/matterbridge/internal/metrics"
 	"github.com/sirupsen/logrus"
 )
 
@@ -20,7 +24,29 @@ type Router struct {
 	Message          chan config.Message
 	MattermostPlugin chan config.Message
 
-	logger *logrus.Entry
+	logger    *logrus.Entry
+	reloadMu  sync.Mutex
+	reloading map[string]*Gateway
+	queues    map[string]chan *sendJob
+	queuesMu  sync.Mutex
+	sending   sync.WaitGroup
+	stopping  bool
+	stopped   chan struct{}
+	stopOnce  sync.Once
+	retries   map[string]*retryQueue
+	retriesMu sync.Mutex
+
+	restarting     map[string]bool                                  // accounts that get a new bridge during a reload
+	reloadChannels map[*bridge.Bridge]map[string]config.ChannelInfo // copies of the channel maps of the running bridges during a reload
+	settings       map[string]map[string]interface{}                // the configuration the bridges of the accounts run with
+
+	reconnectMu  sync.Mutex
+	reconnecting map[*bridge.Bridge]chan struct{} // bridges being reconnected, the channels are closed when done
+
+	stateMu       sync.Mutex
+	stateHandlers []func(bridge.Status)
+	inactive      map[string]bridge.Status
+	paused        map[string]bool // names of the gateways that don't relay messages
 }
 
 // NewRouter initializes a new Matterbridge router for the specified configuration and
@@ -35,10 +61,32 @@ func NewRouter(rootLogger *logrus.Logger, cfg config.Config, bridgeMap map[strin
 		MattermostPlugin: make(chan config.Message),
 		Gateways:         make(map[string]*Gateway),
 		logger:           logger,
+		queues:           make(map[string]chan *sendJob),
+		retries:          make(map[string]*retryQueue),
+		inactive:         make(map[string]bridge.Status),
+		paused:           make(map[string]bool),
+		settings:         make(map[string]map[string]interface{}),
+		reconnecting:     make(map[*bridge.Bridge]chan struct{}),
+		stopped:          make(chan struct{}),
 	}
-	sgw := samechannel.New(cfg)
-	gwconfigs := append(sgw.GetConfig(), cfg.BridgeValues().Gateway...)
+	gwconfigs, err := r.gatewayConfigs()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range gwconfigs {
+		r.Gateways[entry.Name] = New(rootLogger, entry, r)
+	}
+	return r, nil
+}
+
+// gatewayConfigs returns the configuration of all enabled gateways, including
+// the ones generated from the samechannelgateway configuration.
+func (r *Router) gatewayConfigs() ([]*config.Gateway, error) {
+	sgw := samechannel.New(r.Config)
+	gwconfigs := append(sgw.GetConfig(), r.BridgeValues().Gateway...)
 
+	var entries []*config.Gateway
+	names := make(map[string]bool)
 	for idx := range gwconfigs {
 		entry := &gwconfigs[idx]
 		if !entry.Enable {
@@ -47,18 +95,20 @@ func NewRouter(rootLogger *logrus.Logger, cfg config.Config, bridgeMap map[strin
 		if entry.Name == "" {
 			return nil, fmt.Errorf("%s", "Gateway without name found")
 		}
-		if _, ok := r.Gateways[entry.Name]; ok {
+		if names[entry.Name] {
 			return nil, fmt.Errorf("Gateway with name %s already exists", entry.Name)
 		}
-		r.Gateways[entry.Name] = New(rootLogger, entry, r)
+		names[entry.Name] = true
+		entries = append(entries, entry)
 	}
-	return r, nil
+	return entries, nil
 }
 
 // Start will connect all gateways belonging to this router and subsequently route messages
 // between them.
 func (r *Router) Start() error {
 	m := make(map[string]*bridge.Bridge)
+	r.OnStateChange(r.sendStateEvent)
 	if len(r.Gateways) == 0 {
 		return fmt.Errorf("no [[gateway]] configured. See https://github.com/42wim/matterbridge/wiki/How-to-create-your-config for more info")
 	}
@@ -69,13 +119,19 @@ func (r *Router) Start() error {
 		}
 		for _, br := range gw.Bridges {
 			m[br.Account] = br
+			r.settings[br.Account] = r.accountSettings(br.Account)
 		}
 	}
+	if err := r.compileTengoScripts(); err != nil {
+		return err
+	}
 	for _, br := range m {
 		r.logger.Infof("Starting bridge: %s ", br.Account)
+		r.setState(br, bridge.StateConnecting, nil)
 		err := br.Connect()
 		if err != nil {
 			e := fmt.Errorf("Bridge %s failed to start: %v", br.Account, err)
+			r.setState(br, bridge.StateFailed, err)
 			if r.disableBridge(br, e) {
 				continue
 			}
@@ -84,11 +140,13 @@ func (r *Router) Start() error {
 		err = br.JoinChannels()
 		if err != nil {
 			e := fmt.Errorf("Bridge %s failed to join channel: %v", br.Account, err)
+			r.setState(br, bridge.StateFailed, err)
 			if r.disableBridge(br, e) {
 				continue
 			}
 			return e
 		}
+		r.setState(br, bridge.StateConnected, nil)
 	}
 	// remove unused bridges
 	for _, gw := range r.Gateways {
@@ -99,16 +157,144 @@ func (r *Router) Start() error {
 			}
 		}
 	}
+	r.loadRetryQueues()
+	r.startMetrics()
+	r.startAdmin()
+	// the reload connects the new bridges, which mustn't hold up the signals
+	r.OnReload(func() {
+		go func() {
+			if err := r.Reload(); err != nil {
+				r.logger.Errorf("Reloading gateways failed: %s", err)
+			}
+		}()
+	})
 	go r.handleReceive()
-	//go r.updateChannelMembers()
+	go r.updateChannelMembers()
 	return nil
 }
 
+// Stop shuts the router down. Messages received from now on are ignored, the messages
+// that are queued are sent until ctx is done, then all bridges are disconnected.
+// Returns ctx.Err() if sending or disconnecting didn't finish in time.
+func (r *Router) Stop(ctx context.Context) error {
+	var err error
+	r.stopOnce.Do(func() {
+		close(r.stopped)
+		// wait for the message that's being handled
+		r.Lock()
+		bridges := r.bridges()
+		gateways := r.Gateways
+		r.Unlock()
+
+		// failed sends stay in the retry queues, they're saved if RetryQueuePath is set
+		r.retriesMu.Lock()
+		for _, q := range r.retries {
+			q.pause()
+		}
+		r.retriesMu.Unlock()
+
+		r.logger.Info("Sending queued messages")
+		r.queuesMu.Lock()
+		r.stopping = true
+		r.queuesMu.Unlock()
+		if err = wait(ctx, r.sending.Wait); err != nil {
+			r.logger.Errorf("Not all queued messages could be sent: %s", err)
+		}
+
+		// reconnects stop at their next attempt, a bridge that's still disconnected
+		// then isn't disconnected again
+		r.reconnectMu.Lock()
+		var reconnects []chan struct{}
+		for _, done := range r.reconnecting {
+			reconnects = append(reconnects, done)
+		}
+		r.reconnectMu.Unlock()
+		if e := wait(ctx, func() {
+			for _, done := range reconnects {
+				<-done
+			}
+		}); e != nil {
+			r.logger.Errorf("Not all reconnects stopped: %s", e)
+		}
+
+		r.logger.Info("Disconnecting bridges")
+		var wg sync.WaitGroup
+		for _, br := range bridges {
+			if br.Bridger == nil || br.Status().State == bridge.StateReconnecting {
+				continue
+			}
+			wg.Add(1)
+			go func(br *bridge.Bridge) {
+				defer wg.Done()
+				if err := br.Disconnect(); err != nil {
+					r.logger.Errorf("Disconnect() %s failed: %s", br.Account, err)
+				}
+			}(br)
+		}
+		if e := wait(ctx, wg.Wait); e != nil {
+			r.logger.Errorf("Not all bridges disconnected: %s", e)
+			err = e
+		}
+
+		for name, gw := range gateways {
+			if e := gw.Messages.Close(); e != nil {
+				r.logger.Errorf("Closing message store of %s failed: %s", name, e)
+			}
+		}
+		if f, ok := r.logger.Logger.Out.(*os.File); ok {
+			f.Sync() //nolint:errcheck
+		}
+	})
+	return err
+}
+
+// sleep waits for d, it returns false if the router stops before that.
+func (r *Router) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-r.stopped:
+		return false
+	}
+}
+
+// wait calls fn and returns when it's done or ctx is done.
+func wait(ctx context.Context, fn func()) error {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startMetrics serves the prometheus metrics on the MetricsBindAddress, if configured.
+func (r *Router) startMetrics() {
+	addr := r.BridgeValues().General.MetricsBindAddress
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	r.logger.Infof("Serving metrics on http://%s/metrics", addr)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			r.logger.Errorf("Serving metrics failed: %s", err)
+		}
+	}()
+}
+
 // disableBridge returns true and empties a bridge if we have IgnoreFailureOnStart configured
 // otherwise returns false
 func (r *Router) disableBridge(br *bridge.Bridge, err error) bool {
 	if r.BridgeValues().General.IgnoreFailureOnStart {
 		r.logger.Error(err)
+		r.setState(br, bridge.StateDisabled, nil)
 		// setting this bridge empty
 		*br = bridge.Bridge{
 			Log: br.Log,
@@ -130,64 +316,107 @@ func (r *Router) getBridge(account string) *bridge.Bridge {
 func (r *Router) handleReceive() {
 	for msg := range r.Message {
 		msg := msg // scopelint
+		select {
+		case <-r.stopped:
+			r.logger.Debugf("Shutting down, ignoring message from %s", msg.Account)
+			continue
+		default:
+		}
+		r.RLock()
 		r.handleEventGetChannelMembers(&msg)
 		r.handleEventFailure(&msg)
 		r.handleEventRejoinChannels(&msg)
 
+		// the account can be gone after a configuration reload
+		br := r.getBridge(msg.Account)
+		if br == nil {
+			r.RUnlock()
+			continue
+		}
 		// Set message protocol based on the account it came from
-		msg.Protocol = r.getBridge(msg.Account).Protocol
+		msg.Protocol = br.Protocol
+		metrics.MessagesReceived.Inc(msg.Account)
 
 		filesHandled := false
+		var routes *msgRoutes
 		for _, gw := range r.Gateways {
-			// record all the message ID's of the different bridges
-			var msgIDs []*BrMsgID
+			if r.isPaused(gw.Name) {
+				metrics.MessagesDropped.Inc(msg.Account, metrics.DropPaused)
+				continue
+			}
 			if gw.ignoreMessage(&msg) {
 				continue
 			}
 			msg.Timestamp = time.Now()
 			gw.modifyMessage(&msg)
+			gw.addQuote(&msg)
 			if !filesHandled {
 				gw.handleFiles(&msg)
 				filesHandled = true
 			}
-			for _, br := range gw.Bridges {
-				msgIDs = append(msgIDs, gw.handleMessage(&msg, br)...)
+			if routes == nil {
+				routes = r.routeMessage(&msg)
 			}
-
-			if msg.ID != "" {
-				_, exists := gw.Messages.Get(msg.Protocol + " " + msg.ID)
-
-				// Only add the message ID if it doesn't already exist
-				//
-				// For some bridges we always add/update the message ID.
-				// This is necessary as msgIDs will change if a bridge returns
-				// a different ID in response to edits.
-				if !exists {
-					gw.Messages.Add(msg.Protocol+" "+msg.ID, msgIDs)
+			var jobs []*sendJob
+			if !routes.only {
+				for _, br := range gw.Bridges {
+					jobs = append(jobs, gw.handleMessage(&msg, br)...)
 				}
 			}
+			jobs = routes.addJobs(gw, &msg, jobs)
+			r.dispatch(gw, &msg, jobs)
+		}
+		// routes to channels of gateways the message didn't come in on
+		if routes != nil {
+			r.dispatchRoutes(routes, &msg)
+		}
+		r.RUnlock()
+	}
+}
+
+// dispatch queues the jobs of a message for the gateway.
+func (r *Router) dispatch(gw *Gateway, msg *config.Message, jobs []*sendJob) {
+	// record all the message ID's of the different bridges once they've been sent
+	key := ""
+	if msg.ID != "" {
+		key = msg.Protocol + " " + msg.ID
+	}
+	if gw.isEdit(key, msg) {
+		edits := jobs[:0]
+		for _, job := range jobs {
+			if handleEdit(job) {
+				edits = append(edits, job)
+			}
 		}
+		jobs = edits
+	}
+	gw.addExcerpt(key, msg)
+	d := gw.newDelivery(key, len(jobs))
+	for _, job := range jobs {
+		job.delivery = d
+		r.enqueue(job)
 	}
 }
 
-// updateChannelMembers sends every minute an GetChannelMembers event to all bridges.
+// updateChannelMembers sends an GetChannelMembers event to the bridges that have
+// members right away and then every minute, until the router stops. Slack can take
+// a while to know its members, so they're only complete after the first minute.
 func (r *Router) updateChannelMembers() {
-	// TODO sleep a minute because slack can take a while
-	// fix this by having actually connectionDone events send to the router
-	time.Sleep(time.Minute)
 	for {
-		for _, gw := range r.Gateways {
-			for _, br := range gw.Bridges {
-				// only for slack now
-				if br.Protocol != "slack" {
-					continue
-				}
-				r.logger.Debugf("sending %s to %s", config.EventGetChannelMembers, br.Account)
-				if _, err := br.Send(config.Message{Event: config.EventGetChannelMembers}); err != nil {
-					r.logger.Errorf("updateChannelMembers: %s", err)
-				}
+		r.RLock()
+		bridges := r.bridges()
+		r.RUnlock()
+		for _, br := range bridges {
+			if br.Bridger == nil || !br.Capabilities().Members {
+				continue
 			}
+			r.logger.Debugf("sending %s to %s", config.EventGetChannelMembers, br.Account)
+			if _, err := br.Send(config.Message{Event: config.EventGetChannelMembers}); err != nil {
+				r.logger.Errorf("updateChannelMembers: %s", err)
+			}
+		}
+		if !r.sleep(time.Minute) {
+			return
 		}
-		time.Sleep(time.Minute)
 	}
 }
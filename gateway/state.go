@@ -0,0 +1,117 @@
+package gateway
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/jpillora/backoff"
+)
+
+const defaultReconnectMaxDelay = time.Minute
+
+// setState changes the state of br. When the state changed it's logged and the
+// state change handlers are called.
+func (r *Router) setState(br *bridge.Bridge, state bridge.State, err error) {
+	if !br.SetState(state, err) {
+		return
+	}
+	status := br.Status()
+	if err != nil {
+		r.logger.Infof("Bridge %s is %s: %s", br.Account, state, err)
+	} else {
+		r.logger.Infof("Bridge %s is %s", br.Account, state)
+	}
+
+	r.stateMu.Lock()
+	if state == bridge.StateDisabled || state == bridge.StateFailed {
+		r.inactive[br.Account] = status
+	} else {
+		delete(r.inactive, br.Account)
+	}
+	handlers := append([]func(bridge.Status){}, r.stateHandlers...)
+	r.stateMu.Unlock()
+
+	for _, fn := range handlers {
+		fn(status)
+	}
+}
+
+// OnStateChange registers fn to be called with the new status of a bridge when its state changes.
+func (r *Router) OnStateChange(fn func(bridge.Status)) {
+	r.stateMu.Lock()
+	r.stateHandlers = append(r.stateHandlers, fn)
+	r.stateMu.Unlock()
+}
+
+// Statuses returns the status of all the bridges of the router, including the ones that
+// were removed from the gateways because they failed to start.
+func (r *Router) Statuses() []bridge.Status {
+	r.RLock()
+	bridges := r.bridges()
+	r.RUnlock()
+
+	var res []bridge.Status
+	for _, br := range bridges {
+		res = append(res, br.Status())
+	}
+	r.stateMu.Lock()
+	for account, status := range r.inactive {
+		if _, ok := bridges[account]; !ok {
+			res = append(res, status)
+		}
+	}
+	r.stateMu.Unlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].Account < res[j].Account })
+	return res
+}
+
+// sendStateEvent sends a state change of a bridge as a bridge_state event to the api
+// bridges of the gateways the bridge is part of.
+func (r *Router) sendStateEvent(status bridge.Status) {
+	r.RLock()
+	defer r.RUnlock()
+	for _, gw := range r.Gateways {
+		if _, ok := gw.Bridges[status.Account]; !ok {
+			continue
+		}
+		msg := config.Message{
+			Username:  "system",
+			Text:      string(status.State),
+			Account:   status.Account,
+			Event:     config.EventBridgeState,
+			Gateway:   gw.Name,
+			Timestamp: time.Now(),
+			Extra:     map[string][]interface{}{config.EventBridgeState: {status}},
+		}
+		var jobs []*sendJob
+		for _, channel := range gw.Channels {
+			dest := gw.Bridges[channel.Account]
+			if dest == nil || !isAPIProtocol(dest.Protocol) || !strings.Contains(channel.Direction, "out") {
+				continue
+			}
+			jobs = append(jobs, &sendJob{gw: gw, rmsg: copyMessage(&msg), dest: dest, channel: *channel})
+		}
+		d := gw.newDelivery("", len(jobs))
+		for _, job := range jobs {
+			job.delivery = d
+			r.enqueue(job)
+		}
+	}
+}
+
+// reconnectBackoff returns the delays between the reconnect attempts of br.
+func reconnectBackoff(br *bridge.Bridge) *backoff.Backoff {
+	max := time.Duration(br.GetInt("ReconnectMaxDelay")) * time.Second
+	if max <= 0 {
+		max = defaultReconnectMaxDelay
+	}
+	return &backoff.Backoff{
+		Min:    5 * time.Second,
+		Max:    max,
+		Factor: 2,
+		Jitter: true,
+	}
+}
@@ -0,0 +1,44 @@
+package gateway
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgeState(t *testing.T) {
+	r, _ := maketestRouterWithBridgers(t, testconfig)
+
+	statuses := r.Statuses()
+	require.Len(t, statuses, 4)
+	assert.Equal(t, "discord.test", statuses[0].Account)
+	for _, status := range statuses {
+		assert.Equal(t, bridge.StateConnected, status.State)
+		assert.False(t, status.LastConnect.IsZero())
+	}
+
+	var changes []bridge.Status
+	r.OnStateChange(func(status bridge.Status) { changes = append(changes, status) })
+	irc := r.getBridge("irc.freenode")
+	r.setState(irc, bridge.StateReconnecting, errors.New("ping timeout"))
+	r.setState(irc, bridge.StateReconnecting, errors.New("connection refused"))
+	require.Len(t, changes, 1, "only state changes are reported")
+	assert.Equal(t, "irc.freenode", changes[0].Account)
+	assert.Equal(t, "ping timeout", changes[0].LastError)
+	assert.Equal(t, "connection refused", irc.Status().LastError)
+
+	// bridges that are removed after failing are still reported
+	r.setState(irc, bridge.StateFailed, errors.New("bad password"))
+	r.Lock()
+	for _, gw := range r.Gateways {
+		delete(gw.Bridges, "irc.freenode")
+	}
+	r.Unlock()
+	statuses = r.Statuses()
+	require.Len(t, statuses, 4)
+	assert.Equal(t, bridge.StateFailed, statuses[2].State)
+	assert.Equal(t, "irc.freenode", statuses[2].Account)
+}
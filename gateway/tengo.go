@@ -0,0 +1,191 @@
+package gateway
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/internal"
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/stdlib"
+)
+
+// tengoCheckInterval is how often the script files are checked for changes.
+var tengoCheckInterval = time.Second
+
+// tengoScripts caches the compiled tengo scripts.
+var tengoScripts = &tengoCache{scripts: make(map[string]*tengoScript)}
+
+type tengoCache struct {
+	sync.Mutex
+	scripts map[string]*tengoScript
+}
+
+// tengoScript is a tengo script that's compiled once and recompiled when its file changes.
+type tengoScript struct {
+	sync.Mutex
+
+	filename string
+	asset    string
+	checked  time.Time
+	modTime  time.Time
+	size     int64
+	compiled *tengo.Compiled
+	err      error
+}
+
+// get returns the script for filename, kind separates the uses of a script as
+// they're compiled with different variables. If filename is empty the compiled
+// in asset is used.
+func (c *tengoCache) get(kind, filename, asset string) *tengoScript {
+	key := kind + ":" + filename
+	c.Lock()
+	defer c.Unlock()
+	s, ok := c.scripts[key]
+	if !ok {
+		s = &tengoScript{filename: filename, asset: asset}
+		if filename != "" {
+			s.asset = ""
+		}
+		c.scripts[key] = s
+	}
+	return s
+}
+
+// compileTengoScripts compiles all the configured tengo scripts, so a script that's
+// missing or broken is found at startup instead of when the first message comes in.
+func (r *Router) compileTengoScripts() error {
+	msg := &config.Message{}
+	br := &bridge.Bridge{}
+	values := r.BridgeValues()
+	inMessage := values.Tengo.InMessage
+	if inMessage == "" {
+		inMessage = values.Tengo.Message
+	}
+	scripts := []struct {
+		kind, filename, asset string
+		vars                  map[string]interface{}
+	}{
+		{"in", values.General.TengoModifyMessage, "", inMessageTengoVars(msg)},
+		{"in", inMessage, "", inMessageTengoVars(msg)},
+		{"remotenickformat", values.Tengo.RemoteNickFormat, "", remoteNickTengoVars("", msg, br)},
+		{"out", values.Tengo.OutMessage, "tengo/outmessage.tengo", outMessageTengoVars("", msg, msg, br)},
+		{"route", values.Tengo.Route, "", routeTengoVars(msg)},
+	}
+	for _, script := range scripts {
+		if script.filename == "" && script.asset == "" {
+			continue
+		}
+		if _, err := tengoScripts.get(script.kind, script.filename, script.asset).get(script.vars); err != nil {
+			name := script.filename
+			if name == "" {
+				name = script.asset
+			}
+			return fmt.Errorf("tengo script %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// run runs a copy of the compiled script with vars set and returns it, so the
+// variables can be read. The script must always be run with the same variables.
+func (s *tengoScript) run(vars map[string]interface{}) (*tengo.Compiled, error) {
+	c, err := s.get(vars)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range vars {
+		if err := c.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Run(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// get returns a clone of the compiled script, (re)compiling it if needed.
+func (s *tengoScript) get(vars map[string]interface{}) (*tengo.Compiled, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.filename != "" && time.Since(s.checked) >= tengoCheckInterval {
+		s.checked = time.Now()
+		fi, err := os.Stat(s.filename)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.ModTime().Equal(s.modTime) || fi.Size() != s.size {
+			s.modTime = fi.ModTime()
+			s.size = fi.Size()
+			s.compiled, s.err = s.compile(vars)
+		}
+	}
+	if s.compiled == nil && s.err == nil {
+		s.compiled, s.err = s.compile(vars)
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.compiled.Clone(), nil
+}
+
+func (s *tengoScript) compile(vars map[string]interface{}) (*tengo.Compiled, error) {
+	var (
+		res []byte
+		err error
+	)
+	if s.filename != "" {
+		res, err = ioutil.ReadFile(s.filename)
+	} else {
+		res, err = internal.Asset(s.asset)
+	}
+	if err != nil {
+		return nil, err
+	}
+	script := tengo.NewScript(res)
+	script.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	for name, value := range vars {
+		if err := script.Add(name, value); err != nil {
+			return nil, err
+		}
+	}
+	return script.Compile()
+}
+
+// tengoMessage returns the msg map scripts get with all the fields of the message.
+func tengoMessage(msg *config.Message) map[string]interface{} {
+	files := []interface{}{}
+	for _, f := range msg.Extra["file"] {
+		fi, ok := f.(config.FileInfo)
+		if !ok {
+			continue
+		}
+		files = append(files, map[string]interface{}{
+			"name":    fi.Name,
+			"comment": fi.Comment,
+			"url":     fi.URL,
+			"size":    fi.Size,
+			"sha":     fi.SHA,
+		})
+	}
+	return map[string]interface{}{
+		"text":      msg.Text,
+		"channel":   msg.Channel,
+		"username":  msg.Username,
+		"userid":    msg.UserID,
+		"avatar":    msg.Avatar,
+		"account":   msg.Account,
+		"event":     msg.Event,
+		"protocol":  msg.Protocol,
+		"gateway":   msg.Gateway,
+		"parent_id": msg.ParentID,
+		"id":        msg.ID,
+		"timestamp": msg.Timestamp,
+		"files":     files,
+	}
+}
@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTengoScript(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "in.tengo")
+	write := func(script string, mtime time.Time) {
+		require.NoError(t, ioutil.WriteFile(filename, []byte(script), 0600))
+		require.NoError(t, os.Chtimes(filename, mtime, mtime))
+	}
+	write(`
+if len(msg.files) > 0 {
+    msgText = msg.files[0].name + " (" + msg.files[0].size + ") " + msg.id
+}
+msgParentID = "parent"
+msgEvent = "user_action"
+`, time.Now().Add(-time.Hour))
+
+	msg := &config.Message{Text: "file", ID: "1", Extra: map[string][]interface{}{
+		"file": {config.FileInfo{Name: "cat.png", Size: 42}},
+	}}
+	require.NoError(t, modifyInMessageTengo(filename, msg))
+	assert.Equal(t, "cat.png (42) 1", msg.Text)
+	assert.Equal(t, "parent", msg.ParentID)
+	assert.Equal(t, config.EventUserAction, msg.Event)
+
+	// the script is recompiled when the file changes
+	write(`msgAvatar = "https://example.com/" + msg.username + ".png"`, time.Now())
+	tengoScripts.get("in", filename, "").checked = time.Time{}
+	msg = &config.Message{Username: "joe"}
+	require.NoError(t, modifyInMessageTengo(filename, msg))
+	assert.Equal(t, "https://example.com/joe.png", msg.Avatar)
+	assert.Equal(t, "", msg.ParentID)
+
+	// a broken script gives an error until it's fixed
+	write(`msgText = `, time.Now().Add(time.Hour))
+	tengoScripts.get("in", filename, "").checked = time.Time{}
+	assert.Error(t, modifyInMessageTengo(filename, msg))
+}
+
+func TestCompileTengoScripts(t *testing.T) {
+	r, cfg := maketestRouterWithBridgers(t, testconfig)
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "good.tengo"), []byte(`msgText = "good"`), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "broken.tengo"), []byte(`msgText = `), 0600))
+
+	cfg.BridgeValues().Tengo.InMessage = filepath.Join(dir, "good.tengo")
+	assert.NoError(t, r.compileTengoScripts())
+
+	// broken and missing scripts are reported before any message uses them
+	cfg.BridgeValues().Tengo.Route = filepath.Join(dir, "broken.tengo")
+	assert.Error(t, r.compileTengoScripts())
+	cfg.BridgeValues().Tengo.Route = filepath.Join(dir, "missing.tengo")
+	assert.Error(t, r.compileTengoScripts())
+}
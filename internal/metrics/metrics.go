@@ -0,0 +1,253 @@
+// Package metrics keeps the counters and histograms of matterbridge and
+// exports them in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// MessagesReceived counts the messages received per account.
+	MessagesReceived = NewCounterVec("matterbridge_messages_received_total",
+		"Messages received from a bridge.", "account")
+	// MessagesSent counts the messages sent per destination account and channel.
+	MessagesSent = NewCounterVec("matterbridge_messages_sent_total",
+		"Messages sent to a channel of a bridge.", "account", "channel")
+	// SendFailures counts the sends that returned an error.
+	SendFailures = NewCounterVec("matterbridge_send_failures_total",
+		"Messages that failed to be sent to a channel of a bridge.", "account", "channel")
+	// MessagesDropped counts the messages that were dropped on purpose.
+	MessagesDropped = NewCounterVec("matterbridge_messages_dropped_total",
+		"Messages dropped by filters, scripts, flood control, paused gateways, full send queues or removed destinations.", "account", "reason")
+	// Reconnects counts the reconnect attempts per account.
+	Reconnects = NewCounterVec("matterbridge_reconnect_attempts_total",
+		"Attempts to reconnect a bridge.", "account")
+	// UploadBytes counts the bytes of files put on the mediaserver.
+	UploadBytes = NewCounterVec("matterbridge_file_upload_bytes_total",
+		"Bytes of files uploaded to the mediaserver.", "account")
+	// APIRejections counts the requests the api bridge rejected because of their token.
+	APIRejections = NewCounterVec("matterbridge_api_rejected_requests_total",
+		"Requests rejected by the api because of a missing, invalid or insufficient token.", "account", "reason")
+	// SendDuration observes the time a bridge takes to send a message.
+	SendDuration = NewHistogramVec("matterbridge_send_duration_seconds",
+		"Time it took a bridge to send a message.", DefBuckets, "account")
+)
+
+// Reasons for MessagesDropped.
+const (
+	DropIgnored   = "ignored"
+	DropTengo     = "tengo"
+	DropFlood     = "flood"
+	DropPaused    = "paused"
+	DropQueueFull = "queue_full"
+	DropRemoved   = "removed"
+)
+
+// DefBuckets are the default histogram buckets in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	registry = append(registry, c)
+	registryMu.Unlock()
+}
+
+// vec holds the series of a metric by their label values.
+type vec struct {
+	sync.Mutex
+
+	name   string
+	help   string
+	labels []string
+}
+
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s needs %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelString formats the labels of a series, extra is appended as is.
+func (v *vec) labelString(key string, extra string) string {
+	var pairs []string
+	if len(v.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, v.labels[i]+`="`+escape(value)+`"`)
+		}
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec
+	values map[string]float64
+}
+
+// NewCounterVec creates and registers a counter with the specified labels.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: vec{name: name, help: help, labels: labels}, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Inc adds one to the counter with the label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds n to the counter with the label values.
+func (c *CounterVec) Add(n float64, values ...string) {
+	key := c.key(values)
+	c.Lock()
+	c.values[key] += n
+	c.Unlock()
+}
+
+// Value returns the current value of the counter with the label values.
+func (c *CounterVec) Value(values ...string) float64 {
+	key := c.key(values)
+	c.Lock()
+	defer c.Unlock()
+	return c.values[key]
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(key, ""), formatFloat(c.values[key]))
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	vec
+	buckets []float64
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates and registers a histogram with the specified buckets and labels.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		vec:     vec{name: name, help: help, labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+	register(h)
+	return h
+}
+
+// Observe adds an observation to the histogram with the label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.Lock()
+	defer h.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// Since observes the time elapsed since t in seconds.
+func (h *HistogramVec) Since(t time.Time, values ...string) {
+	h.Observe(time.Since(t).Seconds(), values...)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(key, `le="`+formatFloat(upper)+`"`), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(key, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(key, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(key, ""), s.count)
+	}
+}
+
+// WriteTo writes all registered metrics in the Prometheus text format.
+func WriteTo(w io.Writer) {
+	registryMu.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryMu.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler returns a http.Handler serving the metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteTo(w)
+	})
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprint(f)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
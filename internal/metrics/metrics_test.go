@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	c := &CounterVec{vec: vec{name: "test_total", help: "Test counter.", labels: []string{"account"}}, values: make(map[string]float64)}
+	c.Inc("irc.\"test\"")
+	c.Add(2, "irc.\"test\"")
+	c.Inc("slack.test")
+
+	var buf bytes.Buffer
+	c.write(&buf)
+	assert.Equal(t, `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{account="irc.\"test\""} 3
+test_total{account="slack.test"} 1
+`, buf.String())
+
+	h := &HistogramVec{vec: vec{name: "test_seconds", help: "Test histogram."}, buckets: []float64{0.5, 1}, series: make(map[string]*histogram)}
+	h.Observe(0.2)
+	h.Observe(0.7)
+	h.Observe(3)
+
+	buf.Reset()
+	h.write(&buf)
+	assert.Equal(t, `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.5"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 3.9
+test_seconds_count 3
+`, buf.String())
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/gateway"
@@ -16,6 +20,8 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+const defaultShutdownTimeout = 10 * time.Second
+
 var (
 	flagConfig  = flag.String("conf", "matterbridge.toml", "config file")
 	flagDebug   = flag.Bool("debug", false, "enable debug")
@@ -66,7 +72,31 @@ func main() {
 		logger.Fatalf("Starting gateway failed: %s", err)
 	}
 	logger.Printf("Gateway(s) started succesfully. Now relaying messages")
-	select {}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for sig := range sigs {
+		if sig == syscall.SIGHUP {
+			logger.Println("Received SIGHUP, reloading configuration")
+			if err := cfg.Reload(); err != nil {
+				logger.Errorf("Failed to reload the configuration: %s", err)
+			}
+			continue
+		}
+		// a second signal kills us right away
+		signal.Stop(sigs)
+		logger.Printf("Received %s, shutting down", sig)
+		timeout := time.Duration(cfg.BridgeValues().General.ShutdownTimeout) * time.Second
+		if timeout <= 0 {
+			timeout = defaultShutdownTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := r.Stop(ctx); err != nil {
+			logger.Errorf("Shutdown didn't finish in time: %s", err)
+		}
+		cancel()
+		return
+	}
 }
 
 func setupLogger() *logrus.Logger {